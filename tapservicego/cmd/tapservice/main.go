@@ -2,12 +2,108 @@ package main
 
 import (
 	"ataps/internal/tapsync"
+	"context"
+	"flag"
 	"fmt"
+	"log/slog"
+	"os"
+	"os/signal"
+	"syscall"
 )
 
 func main() {
-	config := tapsync.NewConfig() // by default config uses env variables
+	flags := flag.NewFlagSet(os.Args[0], flag.ExitOnError)
+	configFile := flags.String("config", os.Getenv("CONFIG_FILE"), "YAML or TOML configuration file")
+	printConfig := flags.Bool("print-config", false, "print the configuration without secrets and exit")
+	opts := configFlags(flags)
+	flags.Parse(os.Args[1:])
+	// only the flags given in the command line override the
+	// values of the file and the environment variables
+	var configOpts []tapsync.ConfigOption
+	flags.Visit(func(f *flag.Flag) {
+		if opt, ok := opts[f.Name]; ok {
+			configOpts = append(configOpts, opt)
+		}
+	})
+	config, err := tapsync.LoadConfig(*configFile, configOpts...)
+	if err != nil {
+		fmt.Fprintln(os.Stderr, "Invalid configuration:", err)
+		os.Exit(2)
+	}
+	if *printConfig {
+		dump, err := config.Redacted().YAML()
+		if err != nil {
+			fmt.Fprintln(os.Stderr, err)
+			os.Exit(1)
+		}
+		fmt.Print(dump)
+		return
+	}
 	r := tapsync.NewTapSyncService(config)
-	r.Router.Run(fmt.Sprintf(":%d", config.Port))
-	r.DB.Close()
+	// log records from every package go through the service logger
+	slog.SetDefault(r.Logger)
+	// stop serving on SIGINT or SIGTERM, draining in-flight requests
+	ctx, stop := signal.NotifyContext(context.Background(), syscall.SIGINT, syscall.SIGTERM)
+	defer stop()
+	if err := r.ListenAndServe(ctx); err != nil {
+		slog.Error("Server stopped with error", "error", err)
+		os.Exit(1)
+	}
+}
+
+// configFlags defines the flags that override the configuration,
+// returning the option that applies each of them by flag name
+func configFlags(flags *flag.FlagSet) map[string]tapsync.ConfigOption {
+	databaseURL := flags.String("database-url", "", "database connection string")
+	port := flags.Int("port", 0, "port to listen on")
+	logLevel := flags.String("log-level", "", "log level: debug, info, warn or error")
+	tlsCert := flags.String("tls-cert-file", "", "certificate file to serve HTTPS")
+	tlsKey := flags.String("tls-key-file", "", "private key file to serve HTTPS")
+	maxOpenConns := flags.Int("db-max-open-conns", 0, "maximum number of open database connections")
+	maxIdleConns := flags.Int("db-max-idle-conns", 0, "maximum number of idle database connections")
+	connMaxLifetime := flags.Duration("db-conn-max-lifetime", 0, "maximum lifetime of a database connection")
+	connMaxIdleTime := flags.Duration("db-conn-max-idle-time", 0, "maximum idle time of a database connection")
+	queryTimeout := flags.Duration("query-timeout", 0, "maximum duration of a query")
+	readTimeout := flags.Duration("read-timeout", 0, "maximum duration to read a request")
+	writeTimeout := flags.Duration("write-timeout", 0, "maximum duration to write a response")
+	idleTimeout := flags.Duration("idle-timeout", 0, "maximum idle time of keep-alive connections")
+	drainTimeout := flags.Duration("drain-timeout", 0, "time given to in-flight requests when shutting down")
+	defaultMaxRec := flags.Int("default-maxrec", 0, "rows returned when MAXREC is not given")
+	maxRec := flags.Int("maxrec-limit", 0, "largest MAXREC allowed")
+	maxCost := flags.Float64("max-query-cost", 0, "largest estimated cost of the plan of a query")
+	maxEstimatedRows := flags.Float64("max-estimated-rows", 0, "largest number of rows estimated by the plan of a query")
+	driver := flags.String("db-driver", "", "driver used to run the queries: sql or pgx")
+	copyCSV := flags.Bool("copy-csv", false, "stream CSV results with COPY TO STDOUT")
+	cacheTTL := flags.Duration("cache-ttl", 0, "how long query results are cached, 0 disables the cache")
+	cacheMaxBytes := flags.Int64("cache-max-bytes", 0, "total size of the cached results")
+	cacheDir := flags.String("cache-dir", "", "directory where results are cached, instead of memory")
+	formats := flags.String("formats", "", "comma separated list of enabled formats")
+	languages := flags.String("languages", "", "comma separated list of enabled languages")
+	return map[string]tapsync.ConfigOption{
+		"database-url":          func(c *tapsync.Config) { c.DatabaseURL = *databaseURL },
+		"port":                  func(c *tapsync.Config) { c.Port = *port },
+		"log-level":             func(c *tapsync.Config) { c.LogLevel = *logLevel },
+		"tls-cert-file":         func(c *tapsync.Config) { c.TLS.CertFile = *tlsCert },
+		"tls-key-file":          func(c *tapsync.Config) { c.TLS.KeyFile = *tlsKey },
+		"db-max-open-conns":     func(c *tapsync.Config) { c.Pool.MaxOpenConns = *maxOpenConns },
+		"db-max-idle-conns":     func(c *tapsync.Config) { c.Pool.MaxIdleConns = *maxIdleConns },
+		"db-conn-max-lifetime":  func(c *tapsync.Config) { c.Pool.ConnMaxLifetime = *connMaxLifetime },
+		"db-conn-max-idle-time": func(c *tapsync.Config) { c.Pool.ConnMaxIdleTime = *connMaxIdleTime },
+		"query-timeout":         func(c *tapsync.Config) { c.Timeouts.Query = *queryTimeout },
+		"read-timeout":          func(c *tapsync.Config) { c.Timeouts.Read = *readTimeout },
+		"write-timeout":         func(c *tapsync.Config) { c.Timeouts.Write = *writeTimeout },
+		"idle-timeout":          func(c *tapsync.Config) { c.Timeouts.Idle = *idleTimeout },
+		"drain-timeout":         func(c *tapsync.Config) { c.DrainTimeout = *drainTimeout },
+		"default-maxrec":        func(c *tapsync.Config) { c.Limits.DefaultMaxRec = *defaultMaxRec },
+		"maxrec-limit":          func(c *tapsync.Config) { c.Limits.MaxRec = *maxRec },
+		"max-query-cost":        func(c *tapsync.Config) { c.Limits.MaxCost = *maxCost },
+		"max-estimated-rows":    func(c *tapsync.Config) { c.Limits.MaxEstimatedRows = *maxEstimatedRows },
+		"db-driver":             func(c *tapsync.Config) { c.Driver = *driver },
+		"copy-csv":              func(c *tapsync.Config) { c.CopyCSV = *copyCSV },
+		"cache-ttl":             func(c *tapsync.Config) { c.Cache.TTL = *cacheTTL },
+		"cache-max-bytes":       func(c *tapsync.Config) { c.Cache.MaxBytes = *cacheMaxBytes },
+		"cache-dir":             func(c *tapsync.Config) { c.Cache.Dir = *cacheDir },
+		"formats":               func(c *tapsync.Config) { c.Formats = tapsync.SplitList(*formats) },
+		"languages":             func(c *tapsync.Config) { c.Languages = tapsync.SplitList(*languages) },
+	}
 }
@@ -13,8 +13,6 @@ import (
 func (m *Tapservicego) BuildEnv(ctx context.Context, source *dagger.Directory) *dagger.Container {
 	return dag.Container().
 		From("golang:1.23.0-bookworm").
-		WithExec([]string{"apt-get", "update"}).
-		WithExec([]string{"apt-get", "install", "libcfitsio-dev", "--yes"}).
 		WithWorkdir("/usr/src/app").
 		WithFile("go.mod", source.File("go.mod")).
 		WithFile("go.sum", source.File("go.sum")).
@@ -28,8 +26,6 @@ func (m *Tapservicego) BuildEnv(ctx context.Context, source *dagger.Directory) *
 func (m *Tapservicego) Build(ctx context.Context, source *dagger.Directory, port int) *dagger.Container {
 	return dag.Container().
 		From("golang:1.23.0-bookworm").
-		WithExec([]string{"apt-get", "update"}).
-		WithExec([]string{"apt-get", "install", "libcfitsio-dev", "--yes"}).
 		WithFile("/bin/ataps", m.BuildEnv(ctx, source).File("/usr/local/bin/ataps")).
 		WithExposedPort(port).
 		WithEntrypoint([]string{"ataps"})
@@ -0,0 +1,32 @@
+package parsers
+
+import "ataps/pkg/votable"
+
+// Column describes the meaning of a column of the results.
+// The writers add it to the column with the same name,
+// and leave the columns without a description bare.
+type Column struct {
+	Name string
+	// Type is the name of the database type of the column,
+	// as given by sql.ColumnType.DatabaseTypeName,
+	// such as "INT8", or "_FLOAT8" for arrays
+	Type        string
+	Unit        string
+	UCD         string
+	Utype       string
+	Xtype       string
+	Description string
+	// Coosys and Timesys are the coordinate and time systems
+	// of the column, which the VOTable fields refer to
+	Coosys  *votable.Coosys
+	Timesys *votable.Timesys
+}
+
+// columnsByName indexes the columns by their name
+func columnsByName(columns []Column) map[string]Column {
+	byName := make(map[string]Column, len(columns))
+	for _, column := range columns {
+		byName[column.Name] = column
+	}
+	return byName
+}
@@ -4,10 +4,90 @@ import (
 	"bytes"
 	"encoding/csv"
 	"fmt"
-	"log"
-	"sort"
+	"strconv"
+	"strings"
 )
 
+// csvOptions are the dialect of CSV and TSV, see CSVOption
+type csvOptions struct {
+	delimiter      rune
+	null           string
+	floatFormat    byte
+	floatPrecision int
+	header         bool
+	quoteAll       bool
+	bom            bool
+	comments       []Keyword
+	columns        []Column
+}
+
+// CSVOption sets an option of the dialect of ParseCSV and ParseTSV
+type CSVOption func(*csvOptions)
+
+// WithDelimiter sets the delimiter of the fields,
+// a comma for CSV and a tab for TSV by default
+func WithDelimiter(delimiter rune) CSVOption {
+	return func(o *csvOptions) {
+		o.delimiter = delimiter
+	}
+}
+
+// WithNull sets the token written for NULLs, empty by default
+func WithNull(null string) CSVOption {
+	return func(o *csvOptions) {
+		o.null = null
+	}
+}
+
+// WithFloatFormat sets the format and the precision of floats, as in
+// strconv.FormatFloat. By default they are written with the 'g' format
+// and the smallest precision that represents them exactly.
+func WithFloatFormat(format byte, precision int) CSVOption {
+	return func(o *csvOptions) {
+		o.floatFormat = format
+		o.floatPrecision = precision
+	}
+}
+
+// WithHeader sets whether the names of the columns are written first,
+// which they are by default
+func WithHeader(header bool) CSVOption {
+	return func(o *csvOptions) {
+		o.header = header
+	}
+}
+
+// WithQuoteAll sets whether every field is quoted,
+// instead of only those that need it
+func WithQuoteAll(quoteAll bool) CSVOption {
+	return func(o *csvOptions) {
+		o.quoteAll = quoteAll
+	}
+}
+
+// WithBOM sets whether the output starts with the UTF-8 byte order mark,
+// which some spreadsheets need to read UTF-8
+func WithBOM(bom bool) CSVOption {
+	return func(o *csvOptions) {
+		o.bom = bom
+	}
+}
+
+// WithComments adds the keywords as comments before the data,
+// in lines such as "# QUERY: SELECT 1"
+func WithComments(comments ...Keyword) CSVOption {
+	return func(o *csvOptions) {
+		o.comments = append(o.comments, comments...)
+	}
+}
+
+// WithColumns sets the columns of the data, whose types format the values
+func WithColumns(columns ...Column) CSVOption {
+	return func(o *csvOptions) {
+		o.columns = columns
+	}
+}
+
 // ParseCSV converts a slice of maps to a CSV string
 // and returns the string.
 // The maps should be keyed by column names
@@ -18,6 +98,9 @@ import (
 //   - Each column should be separated by a comma
 //   - The first row should contain the column names
 //
+// NULLs are empty, and timestamps and arrays are written as in DALI,
+// which the options can change, see CSVOption.
+//
 // Example input:
 //
 //	 [
@@ -30,10 +113,13 @@ import (
 //	name,age
 //	Alice,30
 //	Bob,25
-func ParseCSV(data []map[string]interface{}) (string, error) {
+func ParseCSV(data []map[string]interface{}, opts ...CSVOption) (string, error) {
+	options := csvOptions{delimiter: ',', floatFormat: 'g', floatPrecision: -1, header: true}
+	for _, opt := range opts {
+		opt(&options)
+	}
 	var csvResult bytes.Buffer
-	w := csv.NewWriter(&csvResult)
-	err := parseCsvData(data, w)
+	err := parseCsvData(data, &csvResult, options)
 	if err != nil {
 		return "", err
 	}
@@ -62,57 +148,84 @@ func ParseCSV(data []map[string]interface{}) (string, error) {
 //	name\tage
 //	Alice\t30
 //	Bob\t25
-func ParseTSV(data []map[string]interface{}) (string, error) {
-	var tsvResult bytes.Buffer
-	w := csv.NewWriter(&tsvResult)
-	w.Comma = '\t'
-	err := parseCsvData(data, w)
-	if err != nil {
-		return "", err
-	}
-	return tsvResult.String(), nil
+func ParseTSV(data []map[string]interface{}, opts ...CSVOption) (string, error) {
+	return ParseCSV(data, append([]CSVOption{WithDelimiter('\t')}, opts...)...)
 }
 
-func parseCsvData(data []map[string]interface{}, w *csv.Writer) error {
-	if len(data) == 0 {
+func parseCsvData(data []map[string]interface{}, buffer *bytes.Buffer, options csvOptions) error {
+	if options.bom {
+		buffer.WriteString("\uFEFF")
+	}
+	for _, comment := range options.comments {
+		fmt.Fprintf(buffer, "# %s: %s\n", comment.Name, singleLine(comment.Value))
+	}
+	// the header of an empty result has the columns given, if any
+	headers := getColumnNames(data, options.columns)
+	if len(headers) == 0 {
 		return nil
 	}
-	headers := getHeaders(data[0])
-	err := w.Write(headers)
-	if err != nil {
-		log.Printf("Error writing headers: %v", err)
-		return err
+	byName := columnsByName(options.columns)
+	types := make([]fieldType, len(headers))
+	for i, header := range headers {
+		types[i] = getFieldType(data, header, byName[header])
+	}
+	w := csv.NewWriter(buffer)
+	w.Comma = options.delimiter
+	if options.header {
+		err := options.writeRecord(w, buffer, headers)
+		if err != nil {
+			return err
+		}
 	}
+	record := make([]string, len(headers))
 	for _, row := range data {
-		converted := convertRowValuesToString(row, headers)
-		err := w.Write(converted)
+		for i, header := range headers {
+			record[i] = options.formatValue(types[i], row[header])
+		}
+		err := options.writeRecord(w, buffer, record)
 		if err != nil {
-			log.Printf("Error writing row: %v", err)
 			return err
 		}
 	}
 	w.Flush()
 	if err := w.Error(); err != nil {
-		log.Printf("Error flushing writer: %v", err)
 		return err
 	}
 	return nil
 }
 
-func convertRowValuesToString(row map[string]interface{}, headers []string) []string {
-	var converted []string
-	for _, header := range headers {
-		converted = append(converted, fmt.Sprintf("%v", row[header]))
+// writeRecord writes the fields with the CSV writer, quoting those that
+// need it, or quotes all of them itself, writing to its buffer
+func (o csvOptions) writeRecord(w *csv.Writer, buffer *bytes.Buffer, fields []string) error {
+	if !o.quoteAll {
+		return w.Write(fields)
+	}
+	for i, field := range fields {
+		if i > 0 {
+			buffer.WriteRune(o.delimiter)
+		}
+		buffer.WriteString(`"` + strings.ReplaceAll(field, `"`, `""`) + `"`)
 	}
-	return converted
+	buffer.WriteString("\n")
+	return nil
 }
 
-func getHeaders(row map[string]interface{}) []string {
-	var headers []string
-	for header := range row {
-		headers = append(headers, header)
+// formatValue formats a value of the type, floats with the format
+// and precision of the options, and NULLs as their token
+func (o csvOptions) formatValue(t fieldType, v interface{}) string {
+	switch v := v.(type) {
+	case nil:
+		return o.null
+	case float32:
+		return strconv.FormatFloat(float64(v), o.floatFormat, o.floatPrecision, 32)
+	case float64:
+		bitSize := 64
+		// the FLOAT4 values are read as float64
+		if t.datatype == "float" {
+			bitSize = 32
+		}
+		return strconv.FormatFloat(v, o.floatFormat, o.floatPrecision, bitSize)
 	}
-	// go map iteration order is random, so we need sort the headers
-	sort.Strings(headers)
-	return headers
+	value, _ := t.formatValue(v)
+	return value
 }
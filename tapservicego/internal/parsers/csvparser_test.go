@@ -2,24 +2,12 @@ package parsers
 
 import (
 	"testing"
+	"time"
 
 	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
 )
 
-func TestConvertRowToString(t *testing.T) {
-	row := map[string]interface{}{"name": "Alice", "age": 30}
-	expected := []string{"Alice", "30"}
-	actual := convertRowValuesToString(row, []string{"name", "age"})
-	assert.Equal(t, expected, actual)
-}
-
-func TestGetHeaders(t *testing.T) {
-	row := map[string]interface{}{"name": "Alice", "age": 30}
-	expected := []string{"age", "name"}
-	actual := getHeaders(row)
-	assert.Equal(t, expected, actual)
-}
-
 func TestParseCSV(t *testing.T) {
 	data := []map[string]interface{}{
 		{"name": "Alice", "age": 30},
@@ -45,3 +33,60 @@ func TestParseTSV(t *testing.T) {
 	}
 	assert.Equal(t, expected, actual)
 }
+
+func TestParseCSVOptions(t *testing.T) {
+	data := []map[string]interface{}{
+		{"name": "Alice", "mag": 18.123456789, "flux": float64(float32(0.1)), "seen": time.Date(2024, 1, 2, 3, 4, 5, 0, time.UTC)},
+		{"name": `Bob "B"`, "mag": nil, "flux": nil, "seen": nil},
+	}
+	columns := []Column{{Name: "flux", Type: "FLOAT4"}, {Name: "mag", Type: "FLOAT8"}, {Name: "name", Type: "TEXT"}, {Name: "seen", Type: "TIMESTAMP"}}
+	testCases := []struct {
+		name     string
+		options  []CSVOption
+		expected string
+	}{
+		{
+			"default",
+			nil,
+			"flux,mag,name,seen\n0.1,18.123456789,Alice,2024-01-02T03:04:05\n,,\"Bob \"\"B\"\"\",\n",
+		},
+		{
+			"null and floats",
+			[]CSVOption{WithNull("NaN"), WithFloatFormat('e', 2)},
+			"flux,mag,name,seen\n1.00e-01,1.81e+01,Alice,2024-01-02T03:04:05\nNaN,NaN,\"Bob \"\"B\"\"\",NaN\n",
+		},
+		{
+			"no header and quote all",
+			[]CSVOption{WithHeader(false), WithQuoteAll(true), WithDelimiter(';')},
+			"\"0.1\";\"18.123456789\";\"Alice\";\"2024-01-02T03:04:05\"\n\"\";\"\";\"Bob \"\"B\"\"\";\"\"\n",
+		},
+		{
+			"bom and comments",
+			[]CSVOption{WithBOM(true), WithComments(Keyword{Name: "QUERY", Value: "SELECT\n1"}), WithHeader(false)},
+			"\uFEFF# QUERY: SELECT 1\n0.1,18.123456789,Alice,2024-01-02T03:04:05\n,,\"Bob \"\"B\"\"\",\n",
+		},
+	}
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			actual, err := ParseCSV(data, append(tc.options, WithColumns(columns...))...)
+			require.NoError(t, err)
+			assert.Equal(t, tc.expected, actual)
+		})
+	}
+}
+
+func TestParseCSVWithoutRows(t *testing.T) {
+	actual, err := ParseTSV(nil, WithColumns(Column{Name: "a", Type: "TEXT"}), WithComments(Keyword{Name: "QUERY_STATUS", Value: "OK"}))
+	require.NoError(t, err)
+	assert.Equal(t, "# QUERY_STATUS: OK\na\n", actual)
+	actual, err = ParseCSV(nil, WithColumns(Column{Name: "b"}, Column{Name: "a"}), WithHeader(false))
+	require.NoError(t, err)
+	assert.Equal(t, "", actual)
+	// without the columns nothing is known of the result
+	actual, err = ParseCSV(nil)
+	require.NoError(t, err)
+	assert.Equal(t, "", actual)
+	actual, err = ParseCSV(nil, WithColumns(Column{Name: "b"}, Column{Name: "a"}))
+	require.NoError(t, err)
+	assert.Equal(t, "a,b\n", actual)
+}
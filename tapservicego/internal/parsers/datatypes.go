@@ -0,0 +1,207 @@
+package parsers
+
+import (
+	"fmt"
+	"math"
+	"reflect"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// timestampLayout is the ISO 8601 layout of the VOTable timestamp xtype
+const timestampLayout = "2006-01-02T15:04:05.999"
+
+// fieldType is the VOTable type of a column
+type fieldType struct {
+	datatype  string
+	arraySize string
+	xtype     string
+	// array is set for PostgreSQL arrays, whose values
+	// are written as the list of their elements
+	array bool
+	// layout formats the dates and timestamps
+	layout string
+}
+
+// databaseFieldTypes maps the PostgreSQL types, by the names
+// given by database/sql, to their VOTable types
+var databaseFieldTypes = map[string]fieldType{
+	"BOOL":        {datatype: "boolean"},
+	"INT2":        {datatype: "short"},
+	"INT4":        {datatype: "int"},
+	"INT8":        {datatype: "long"},
+	"OID":         {datatype: "long"},
+	"XID":         {datatype: "long"},
+	"CID":         {datatype: "long"},
+	"FLOAT4":      {datatype: "float"},
+	"FLOAT8":      {datatype: "double"},
+	"NUMERIC":     {datatype: "double"},
+	"BYTEA":       {datatype: "unsignedByte", arraySize: "*"},
+	"UUID":        {datatype: "char", arraySize: "36"},
+	"DATE":        {datatype: "char", arraySize: "*", xtype: "timestamp", layout: "2006-01-02"},
+	"TIMESTAMP":   {datatype: "char", arraySize: "*", xtype: "timestamp", layout: timestampLayout},
+	"TIMESTAMPTZ": {datatype: "char", arraySize: "*", xtype: "timestamp", layout: timestampLayout},
+}
+
+// charFieldType is the type of text, and of every type without a better one
+var charFieldType = fieldType{datatype: "char", arraySize: "*"}
+
+// nullValues are the values written for the NULLs of the integer
+// datatypes, which have no empty value, and are declared with VALUES
+var nullValues = map[string]string{
+	"unsignedByte": "255",
+	"short":        strconv.Itoa(math.MinInt16),
+	"int":          strconv.Itoa(math.MinInt32),
+	"long":         strconv.Itoa(math.MinInt64),
+}
+
+// getFieldType returns the type of the column named key, mapping the
+// database type of the column when it is known, and otherwise looking
+// at the first value of the column that is not NULL
+func getFieldType(data []map[string]interface{}, key string, column Column) fieldType {
+	if column.Type != "" {
+		return databaseFieldType(column.Type)
+	}
+	for _, row := range data {
+		if row[key] != nil {
+			return valueFieldType(row[key])
+		}
+	}
+	return charFieldType
+}
+
+// databaseFieldType maps a PostgreSQL type to its VOTable type.
+// Arrays, whose names start by "_", of booleans and numbers are
+// arrays of variable size of their elements, and other arrays
+// are written as text.
+func databaseFieldType(name string) fieldType {
+	if element, ok := strings.CutPrefix(name, "_"); ok {
+		t, ok := databaseFieldTypes[element]
+		if !ok || t.arraySize != "" {
+			return charFieldType
+		}
+		t.arraySize = "*"
+		t.array = true
+		return t
+	}
+	if t, ok := databaseFieldTypes[name]; ok {
+		return t
+	}
+	return charFieldType
+}
+
+// valueFieldType returns the type of the values like v
+func valueFieldType(v interface{}) fieldType {
+	switch v.(type) {
+	case time.Time:
+		return databaseFieldTypes["TIMESTAMP"]
+	case []byte:
+		return databaseFieldTypes["BYTEA"]
+	}
+	datatype := getDataType(v)
+	if datatype == "char" {
+		return charFieldType
+	}
+	return fieldType{datatype: datatype}
+}
+
+func getDataType(v interface{}) string {
+	if v == nil {
+		return "char"
+	}
+
+	t := reflect.TypeOf(v)
+	k := t.Kind()
+
+	switch k {
+	case reflect.Bool:
+		return "boolean"
+	case reflect.Int8, reflect.Int16:
+		return "short"
+	case reflect.Uint8:
+		return "unsignedByte"
+	case reflect.Int32:
+		return "int"
+	case reflect.Int64, reflect.Int:
+		return "long"
+	case reflect.Float32:
+		return "float"
+	case reflect.Float64:
+		return "double"
+	case reflect.Complex64:
+		return "floatComplex"
+	case reflect.Complex128:
+		return "doubleComplex"
+	default:
+		return "char"
+	}
+}
+
+// formatValue formats a value of the type for the TABLEDATA.
+// NULLs of the integer types, including the elements of arrays,
+// are written with their null value, and null is set.
+func (t fieldType) formatValue(v interface{}) (value string, null bool) {
+	switch v := v.(type) {
+	case nil:
+		// arrays of variable size are empty
+		if t.arraySize != "" {
+			return "", false
+		}
+		return nullValues[t.datatype], nullValues[t.datatype] != ""
+	case time.Time:
+		layout := t.layout
+		if layout == "" {
+			layout = timestampLayout
+		}
+		return v.UTC().Format(layout), false
+	case []byte:
+		if t.datatype != "unsignedByte" {
+			return string(v), false
+		}
+		bytes := make([]string, len(v))
+		for i, b := range v {
+			bytes[i] = strconv.Itoa(int(b))
+		}
+		return strings.Join(bytes, " "), false
+	case string:
+		if t.array {
+			return t.formatArray(v)
+		}
+		return v, false
+	default:
+		return fmt.Sprintf("%v", v), false
+	}
+}
+
+// formatArray formats an array given in the text format of PostgreSQL,
+// such as {1,NULL,3}, as its elements separated by spaces.
+// The elements of multidimensional arrays are flattened.
+func (t fieldType) formatArray(v string) (value string, null bool) {
+	elements := arrayElements(v)
+	for i, element := range elements {
+		if element != "NULL" {
+			continue
+		}
+		switch t.datatype {
+		case "float", "double":
+			elements[i] = "NaN"
+		case "boolean":
+			elements[i] = "?"
+		default:
+			elements[i] = nullValues[t.datatype]
+			null = true
+		}
+	}
+	return strings.Join(elements, " "), null
+}
+
+// arrayElements returns the elements of an array given in the text
+// format of PostgreSQL, flattening multidimensional arrays
+func arrayElements(v string) []string {
+	v = strings.NewReplacer("{", "", "}", "").Replace(v)
+	if v == "" {
+		return nil
+	}
+	return strings.Split(v, ",")
+}
@@ -0,0 +1,158 @@
+package parsers
+
+import (
+	"bytes"
+	"encoding/csv"
+	"strings"
+
+	"gopkg.in/yaml.v3"
+)
+
+// ecsvDatatypes maps the VOTable datatypes to those of ECSV
+var ecsvDatatypes = map[string]string{
+	"boolean":      "bool",
+	"unsignedByte": "uint8",
+	"short":        "int16",
+	"int":          "int32",
+	"long":         "int64",
+	"float":        "float32",
+	"double":       "float64",
+}
+
+// ecsvHeader is the YAML header of an ECSV file
+type ecsvHeader struct {
+	Delimiter string       `yaml:"delimiter"`
+	Datatype  []ecsvColumn `yaml:"datatype"`
+	Schema    string       `yaml:"schema"`
+}
+
+// ecsvColumn describes a column of an ECSV file
+type ecsvColumn struct {
+	Name        string            `yaml:"name"`
+	Unit        string            `yaml:"unit,omitempty"`
+	Datatype    string            `yaml:"datatype"`
+	Subtype     string            `yaml:"subtype,omitempty"`
+	Description string            `yaml:"description,omitempty"`
+	Meta        map[string]string `yaml:"meta,omitempty"`
+}
+
+// ParseECSV converts a slice of maps to an ECSV string, the Enhanced
+// Character Separated Values format of Astropy, version 1.0.
+// The CSV data follows a header, commented by "# ", declaring the
+// datatype of each column, with its unit, description and UCD when
+// they are known.
+//
+// The datatypes are those of the database types of the columns, or
+// of their values. NULLs are empty fields, which ECSV readers mask.
+// Arrays of numbers and booleans are strings with a subtype, written
+// as JSON lists where NULL elements are null.
+//
+// Example output:
+//
+//	# %ECSV 1.0
+//	# ---
+//	# delimiter: ','
+//	# datatype:
+//	#   - name: age
+//	#     unit: yr
+//	#     datatype: int64
+//	#   - name: name
+//	#     datatype: string
+//	# schema: astropy-2.0
+//	age,name
+//	30,Alice
+//	,Bob
+func ParseECSV(data []map[string]interface{}, columns ...Column) (string, error) {
+	keys := getColumnNames(data, columns)
+	byName := columnsByName(columns)
+	types := make([]fieldType, len(keys))
+	header := ecsvHeader{Delimiter: ",", Schema: "astropy-2.0"}
+	for i, key := range keys {
+		column := byName[key]
+		types[i] = getFieldType(data, key, column)
+		ecsvColumn := ecsvColumn{
+			Name:        key,
+			Unit:        column.Unit,
+			Datatype:    "string",
+			Description: column.Description,
+		}
+		if datatype, ok := ecsvDatatypes[types[i].datatype]; ok {
+			if types[i].arraySize != "" {
+				ecsvColumn.Subtype = datatype + "[null]"
+			} else {
+				ecsvColumn.Datatype = datatype
+			}
+		}
+		if column.UCD != "" {
+			ecsvColumn.Meta = map[string]string{"ucd": column.UCD}
+		}
+		header.Datatype = append(header.Datatype, ecsvColumn)
+	}
+	var result bytes.Buffer
+	result.WriteString("# %ECSV 1.0\n# ---\n")
+	var yamlHeader bytes.Buffer
+	encoder := yaml.NewEncoder(&yamlHeader)
+	encoder.SetIndent(2)
+	if err := encoder.Encode(header); err != nil {
+		return "", err
+	}
+	for _, line := range strings.SplitAfter(strings.TrimSuffix(yamlHeader.String(), "\n"), "\n") {
+		result.WriteString("# " + line)
+	}
+	result.WriteString("\n")
+	w := csv.NewWriter(&result)
+	if err := w.Write(keys); err != nil {
+		return "", err
+	}
+	for _, row := range data {
+		values := make([]string, len(keys))
+		for i, key := range keys {
+			values[i] = types[i].formatECSV(row[key])
+		}
+		if err := w.Write(values); err != nil {
+			return "", err
+		}
+	}
+	w.Flush()
+	if err := w.Error(); err != nil {
+		return "", err
+	}
+	return result.String(), nil
+}
+
+// formatECSV formats a value of the type for ECSV, NULLs being
+// empty, booleans as in Python and arrays JSON lists
+func (t fieldType) formatECSV(v interface{}) string {
+	switch v := v.(type) {
+	case nil:
+		return ""
+	case bool:
+		if v {
+			return "True"
+		}
+		return "False"
+	}
+	if _, isNumeric := ecsvDatatypes[t.datatype]; !isNumeric || t.arraySize == "" {
+		value, _ := t.formatValue(v)
+		return value
+	}
+	var elements []string
+	if s, ok := v.(string); ok {
+		elements = arrayElements(s)
+	} else {
+		value, _ := t.formatValue(v)
+		elements = strings.Fields(value)
+	}
+	for i, element := range elements {
+		switch element {
+		// JSON has no NaN nor infinities
+		case "NULL", "NaN", "Infinity", "-Infinity":
+			elements[i] = "null"
+		case "t":
+			elements[i] = "true"
+		case "f":
+			elements[i] = "false"
+		}
+	}
+	return "[" + strings.Join(elements, ",") + "]"
+}
@@ -0,0 +1,54 @@
+package parsers
+
+import (
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestParseECSV(t *testing.T) {
+	data := []map[string]interface{}{
+		{"oid": "ZTF1", "ndet": int64(3), "meanra": 10.5, "stellar": true, "mags": "{18.5,NULL}"},
+		{"oid": "ZTF2, b", "ndet": nil, "meanra": nil, "stellar": false, "mags": nil},
+	}
+	result, err := ParseECSV(data,
+		Column{Name: "oid", Type: "VARCHAR", Description: "Object identifier: unique"},
+		Column{Name: "ndet", Type: "INT4"},
+		Column{Name: "meanra", Type: "FLOAT8", Unit: "deg", UCD: "pos.eq.ra;meta.main"},
+		Column{Name: "mags", Type: "_FLOAT8"},
+	)
+	require.NoError(t, err)
+	assert.Equal(t, `# %ECSV 1.0
+# ---
+# delimiter: ','
+# datatype:
+#   - name: mags
+#     datatype: string
+#     subtype: float64[null]
+#   - name: meanra
+#     unit: deg
+#     datatype: float64
+#     meta:
+#       ucd: pos.eq.ra;meta.main
+#   - name: ndet
+#     datatype: int32
+#   - name: oid
+#     datatype: string
+#     description: 'Object identifier: unique'
+#   - name: stellar
+#     datatype: bool
+# schema: astropy-2.0
+mags,meanra,ndet,oid,stellar
+"[18.5,null]",10.5,3,ZTF1,True
+,,,"ZTF2, b",False
+`, result)
+}
+
+func TestParseECSVEmpty(t *testing.T) {
+	result, err := ParseECSV(nil, Column{Name: "oid"})
+	require.NoError(t, err)
+	assert.Contains(t, result, "#   - name: oid\n#     datatype: string\n")
+	assert.True(t, strings.HasSuffix(result, "# schema: astropy-2.0\noid\n"))
+}
@@ -1,106 +1,430 @@
 package parsers
 
 import (
+	"ataps/pkg/fits"
 	"fmt"
-	"log"
-	"os"
+	"io"
+	"math"
 	"reflect"
-	"sort"
+	"strconv"
 	"strings"
-
-	"github.com/astrogo/cfitsio"
+	"time"
 )
 
-func ParseFits(data []map[string]interface{}) (string, error) {
-	// create fits file
-	f, err := os.CreateTemp("", "*.fits")
-	if err != nil {
-		return "", err
-	}
-	fname := f.Name()
-	f.Close() // close immediately since we only need the filename
-	os.Remove(fname)
-	fitsFile, err := cfitsio.Create(fname)
+// WriteFits writes the data to w as a FITS file with a binary table
+// named "results", describing its columns with the units, UCDs and
+// descriptions of the columns. The file is streamed as it is encoded.
+// The formats of the columns are those of their database types, when
+// they are known, and otherwise those of their values. NULLs are written
+// as NaN in float columns, as the TNULLn of integer columns, and as
+// blanks in string columns, see fits.TableWriter.WriteRow. Columns
+// without values and without a database type are written as strings.
+//
+// String columns are as wide as their longest value. PostgreSQL arrays
+// of numbers and booleans are written as vector columns as long as
+// their longest array, the shorter ones being padded with NULLs, and
+// other arrays as strings. Byte strings are vectors of bytes padded
+// with zeros. Dates and timestamps are written as ISO 8601 strings in
+// UTC, and the time system of the columns is declared with the TIMESYS
+// keywords of the header.
+func WriteFits(data []map[string]interface{}, w io.Writer, columns ...Column) error {
+	fitsColumns, err := createColumns(data, columns)
 	if err != nil {
-		return "", err
+		return err
 	}
-	// write primary hdu
-	phdu, err := cfitsio.NewPrimaryHDU(&fitsFile, cfitsio.NewDefaultHeader())
-	if err != nil {
-		return "", err
+	tableColumns := make([]fits.Column, len(fitsColumns))
+	for i, column := range fitsColumns {
+		tableColumns[i] = column.Column
 	}
-	defer phdu.Close()
-	// create table
-	fitsTable, err := CreateFits(data, &fitsFile)
+	table, err := fits.NewTableWriter(w, "results", tableColumns, int64(len(data)), timeKeywords(fitsColumns, columns)...)
 	if err != nil {
-		return "", err
+		return err
 	}
-	defer fitsTable.Close()
-	err = fitsFile.Close()
-	if err != nil {
-		return "", err
+	values := make([]interface{}, len(fitsColumns))
+	for _, row := range data {
+		for i, column := range fitsColumns {
+			values[i], err = column.value(row[column.Name])
+			if err != nil {
+				return err
+			}
+		}
+		if err := table.WriteRow(values); err != nil {
+			return err
+		}
 	}
-	return fname, err
+	return table.Close()
 }
 
-func CreateFits(data []map[string]interface{}, file *cfitsio.File) (*cfitsio.Table, error) {
-	// create columns using the keys of the first map
-	// the type of the column is determined by the type of the value
-	columns, err := createColumns(data)
-	if err != nil {
-		log.Printf("Error creating columns: %v", err)
-		return nil, err
+// databaseFormats maps the PostgreSQL types, by the names
+// given by database/sql, to the formats of FITS columns
+var databaseFormats = map[string]string{
+	"BOOL":    "L",
+	"INT2":    "I",
+	"INT4":    "J",
+	"INT8":    "K",
+	"OID":     "K",
+	"XID":     "K",
+	"CID":     "K",
+	"FLOAT4":  "E",
+	"FLOAT8":  "D",
+	"NUMERIC": "D",
+}
+
+// fitsNullValues are the TNULLn of the integer formats
+var fitsNullValues = map[string]int64{
+	"B": math.MaxUint8,
+	"I": math.MinInt16,
+	"J": math.MinInt32,
+	"K": math.MinInt64,
+}
+
+// fitsColumn is a column of the table with the conversion of its values
+type fitsColumn struct {
+	fits.Column
+	// code is the type code of the format
+	code string
+	// vector is set for the columns of arrays and bytes,
+	// whose repeat count is the length of the longest value
+	vector bool
+	// timestamp is set for the columns of dates and timestamps
+	timestamp bool
+	// convert converts the values that are not NULL to those written
+	convert func(value interface{}) (interface{}, error)
+}
+
+// value converts a value of the column to the one written
+func (c fitsColumn) value(v interface{}) (interface{}, error) {
+	if v == nil {
+		return nil, nil
 	}
-	data = cleanData(data, columns)
-	// create table from columns
-	table, err := cfitsio.NewTable(file, "results", columns, cfitsio.BINARY_TBL)
+	value, err := c.convert(v)
 	if err != nil {
-		return nil, err
+		return nil, fmt.Errorf("Column %s: %w", c.Name, err)
 	}
-	// populate the table
-	rslice := reflect.ValueOf(data)
-	for i := 0; i < rslice.Len(); i++ {
-		row := rslice.Index(i).Addr()
-		err := table.Write(row.Interface())
+	// the strings are measured as they are written, in ASCII
+	if s, ok := value.(string); ok && c.code == "A" {
+		return fits.ASCIIString(s), nil
+	}
+	return value, nil
+}
+
+func createColumns(data []map[string]interface{}, columns []Column) ([]fitsColumn, error) {
+	keys := getColumnNames(data, columns)
+	byName := columnsByName(columns)
+	fitsColumns := make([]fitsColumn, 0, len(keys))
+	for _, key := range keys {
+		column := byName[key]
+		fitsColumn, err := newFitsColumn(data, key, column)
 		if err != nil {
-			log.Printf("Error writing record: %v", err)
 			return nil, err
 		}
+		fitsColumn.Column = fits.Column{
+			Name:        key,
+			Unit:        column.Unit,
+			UCD:         column.UCD,
+			Utype:       column.Utype,
+			Description: column.Description,
+		}
+		hasNull := false
+		width := 0
+		for _, row := range data {
+			value, err := fitsColumn.value(row[key])
+			if err != nil {
+				return nil, err
+			}
+			if value == nil {
+				hasNull = true
+				continue
+			}
+			if fitsColumn.code == "A" || fitsColumn.vector {
+				width = max(width, reflect.ValueOf(value).Len())
+			}
+		}
+		fitsColumn.Format = fitsColumn.code
+		if fitsColumn.code == "A" || fitsColumn.vector {
+			// no column is narrower than one element
+			fitsColumn.Format = strconv.Itoa(max(width, 1)) + fitsColumn.code
+		}
+		// the elements of arrays can be NULL too, and pad the shorter
+		// ones, while bytes have no value left for NULLs and are zeros
+		if null, ok := fitsNullValues[fitsColumn.code]; ok && fitsColumn.code != "B" && (hasNull || fitsColumn.vector) {
+			fitsColumn.Null = &null
+		}
+		fitsColumns = append(fitsColumns, fitsColumn)
 	}
-	nrows := table.NumRows()
-	if nrows != int64(len(data)) {
-		return nil, fmt.Errorf("Error creating table: number of rows written (%d) does not match number of rows in data (%d)", nrows, len(data))
+	return fitsColumns, nil
+}
+
+// newFitsColumn returns the column named key, with the type code and
+// the conversion of its database type when it is known, and otherwise
+// of its first value that is not NULL
+func newFitsColumn(data []map[string]interface{}, key string, column Column) (fitsColumn, error) {
+	if column.Type != "" {
+		return databaseFitsColumn(column.Type), nil
 	}
-	return table, nil
+	for _, row := range data {
+		switch value := row[key].(type) {
+		case nil:
+			continue
+		case time.Time:
+			return databaseFitsColumn("TIMESTAMP"), nil
+		case []byte:
+			return databaseFitsColumn("BYTEA"), nil
+		case string:
+			return stringFitsColumn(), nil
+		default:
+			code := getFormat(value)
+			if code == "" {
+				return fitsColumn{}, fmt.Errorf("Error creating columns: unsupported type %T for key %s", value, key)
+			}
+			return fitsColumn{code: code, convert: identity}, nil
+		}
+	}
+	return stringFitsColumn(), nil
 }
 
-func createColumns(data []map[string]interface{}) ([]cfitsio.Column, error) {
-	columns := make([]cfitsio.Column, 0, len(data[0]))
-	keys := make([]string, 0, len(data[0]))
-	for key := range data[0] {
-		keys = append(keys, key)
+// databaseFitsColumn returns the column of a PostgreSQL type. Arrays,
+// whose names start by "_", of booleans and numbers are vectors of
+// their elements, and other types without a format are strings.
+func databaseFitsColumn(name string) fitsColumn {
+	if code, ok := databaseFormats[name]; ok {
+		return fitsColumn{code: code, convert: identity}
 	}
-	// sort the keys to ensure consistent column order
-	sort.Strings(keys)
-	var format string
-	for _, key := range keys {
-		for i := 0; i < len(data); i++ {
-			if data[i][key] == nil {
-				continue
+	if element, ok := strings.CutPrefix(name, "_"); ok {
+		if code, ok := databaseFormats[element]; ok {
+			return fitsColumn{code: code, vector: true, convert: func(v interface{}) (interface{}, error) {
+				return parseArray(v, code)
+			}}
+		}
+	}
+	switch t := databaseFieldType(name); {
+	case t.datatype == "unsignedByte":
+		return fitsColumn{code: "B", vector: true, convert: identity}
+	case t.xtype == "timestamp":
+		column := stringFitsColumn()
+		column.timestamp = true
+		column.convert = func(v interface{}) (interface{}, error) {
+			value, _ := t.formatValue(v)
+			return value, nil
+		}
+		return column
+	}
+	return stringFitsColumn()
+}
+
+// stringFitsColumn returns a column of strings, writing
+// the values of other types as they are formatted
+func stringFitsColumn() fitsColumn {
+	return fitsColumn{code: "A", convert: func(v interface{}) (interface{}, error) {
+		value, _ := charFieldType.formatValue(v)
+		return value, nil
+	}}
+}
+
+func identity(v interface{}) (interface{}, error) {
+	return v, nil
+}
+
+// parseArray parses an array given in the text format of PostgreSQL
+// into its elements of the type code, its NULLs being nil
+func parseArray(v interface{}, code string) (interface{}, error) {
+	s, ok := v.(string)
+	if !ok {
+		// arrays already scanned
+		return v, nil
+	}
+	elements := arrayElements(s)
+	values := make([]interface{}, len(elements))
+	for i, element := range elements {
+		if element == "NULL" {
+			continue
+		}
+		var err error
+		switch code {
+		case "L":
+			values[i], err = strconv.ParseBool(element)
+		case "E", "D":
+			values[i], err = strconv.ParseFloat(element, 64)
+		default:
+			values[i], err = strconv.ParseInt(element, 10, 64)
+		}
+		if err != nil {
+			return nil, fmt.Errorf("Invalid array %s: %w", s, err)
+		}
+	}
+	return values, nil
+}
+
+// timeKeywords returns the keywords declaring the time system of the
+// columns: the time scale, reference time and position of the first
+// column with a TIMESYS, or UTC for the dates and timestamps
+func timeKeywords(fitsColumns []fitsColumn, columns []Column) fits.Header {
+	byName := columnsByName(columns)
+	for _, fitsColumn := range fitsColumns {
+		timesys := byName[fitsColumn.Name].Timesys
+		if timesys == nil {
+			continue
+		}
+		keywords := fits.Header{{Keyword: "TIMESYS", Value: timesys.TimeScale, Comment: "time scale"}}
+		switch timesys.TimeOrigin {
+		case "MJD-origin":
+			keywords = append(keywords, fits.Card{Keyword: "MJDREF", Value: 0.0, Comment: "times are MJD"})
+		case "JD-origin":
+			keywords = append(keywords, fits.Card{Keyword: "JDREF", Value: 0.0, Comment: "times are JD"})
+		}
+		if timesys.RefPosition != "" {
+			keywords = append(keywords, fits.Card{Keyword: "TREFPOS", Value: timesys.RefPosition, Comment: "reference position"})
+		}
+		return keywords
+	}
+	for _, fitsColumn := range fitsColumns {
+		if fitsColumn.timestamp {
+			return fits.Header{{Keyword: "TIMESYS", Value: "UTC", Comment: "time scale"}}
+		}
+	}
+	return nil
+}
+
+// ReadFits reads the first table of a FITS file, a binary or an ASCII
+// table, as the rows and the columns of a query: the columns have the
+// database types of their formats and are described by their keywords,
+// and the rows have the values of fits.ReadTable, the vectors and the
+// variable-length arrays being given in the text format of PostgreSQL.
+func ReadFits(r io.Reader) ([]map[string]interface{}, []Column, error) {
+	table, err := fits.ReadTable(r)
+	if err != nil {
+		return nil, nil, err
+	}
+	xtension, _ := table.Header.Get("XTENSION")
+	columns := make([]Column, len(table.Columns))
+	for i, column := range table.Columns {
+		n := strconv.Itoa(i + 1)
+		scale, _ := table.Header.Get("TSCAL" + n)
+		zero, _ := table.Header.Get("TZERO" + n)
+		columns[i] = Column{
+			Name:        column.Name,
+			Type:        fitsDatabaseType(column.Format, xtension == "TABLE", scale, zero),
+			Unit:        column.Unit,
+			UCD:         column.UCD,
+			Utype:       column.Utype,
+			Description: column.Description,
+		}
+	}
+	data := make([]map[string]interface{}, len(table.Rows))
+	for i, values := range table.Rows {
+		row := make(map[string]interface{}, len(values))
+		for j, value := range values {
+			if elements, ok := value.([]interface{}); ok {
+				value = formatFitsArray(elements)
+			}
+			row[columns[j].Name] = value
+		}
+		data[i] = row
+	}
+	return data, columns, nil
+}
+
+// fitsDatabaseTypes maps the type codes of FITS columns
+// to the PostgreSQL types of their values
+var fitsDatabaseTypes = map[byte]string{
+	'L': "BOOL",
+	'X': "BOOL",
+	'B': "INT2",
+	'I': "INT2",
+	'J': "INT4",
+	'K': "INT8",
+	'E': "FLOAT4",
+	'D': "FLOAT8",
+	'A': "TEXT",
+}
+
+// fitsOffsetTypes are the types of the integers offset by a TZEROn,
+// which are wider, as the unsigned integers
+var fitsOffsetTypes = map[byte]string{
+	'B': "INT2",
+	'I': "INT4",
+	'J': "INT8",
+	'K': "NUMERIC",
+}
+
+// fitsDatabaseType returns the PostgreSQL type of the values of
+// a column, given its format and its TSCALn and TZEROn, if any
+func fitsDatabaseType(format string, ascii bool, scale interface{}, zero interface{}) string {
+	if ascii && format != "" {
+		switch format[0] {
+		case 'A':
+			return "TEXT"
+		case 'I':
+			if scale == nil && zero == nil {
+				return "INT8"
 			}
-			value := data[i][key]
-			format = getFormat(value)
-			if format == "" {
-				return nil, fmt.Errorf("Error creating columns: unsupported type %T for key %s", value, key)
+		}
+		return "FLOAT8"
+	}
+	// the formats are a repeat count followed by a type code
+	typeCode := strings.TrimLeft(format, "0123456789")
+	if typeCode == "" {
+		return "TEXT"
+	}
+	repeat := format[:len(format)-len(typeCode)]
+	code := typeCode[0]
+	array := code == 'X' || repeat != "" && repeat != "1"
+	if code == 'P' || code == 'Q' {
+		// the descriptors are followed by the type code of their elements
+		if len(typeCode) < 2 {
+			return "TEXT"
+		}
+		code, array = typeCode[1], true
+	}
+	t := fitsDatabaseTypes[code]
+	switch {
+	case code == 'A':
+		return t
+	case scale != nil && scale != 1.0 && scale != int64(1):
+		t = "FLOAT8"
+	case zero != nil && zero != 0.0 && zero != int64(0):
+		if offsetType, ok := fitsOffsetTypes[code]; ok && isInteger(zero) {
+			t = offsetType
+		} else {
+			t = "FLOAT8"
+		}
+	}
+	if array {
+		return "_" + t
+	}
+	return t
+}
+
+func isInteger(v interface{}) bool {
+	switch v := v.(type) {
+	case int64:
+		return true
+	case float64:
+		return v == math.Trunc(v)
+	}
+	return false
+}
+
+// formatFitsArray formats the elements of an array
+// in the text format of PostgreSQL, such as {1,NULL,3}
+func formatFitsArray(elements []interface{}) string {
+	formatted := make([]string, len(elements))
+	for i, element := range elements {
+		switch element := element.(type) {
+		case nil:
+			formatted[i] = "NULL"
+		case bool:
+			formatted[i] = "f"
+			if element {
+				formatted[i] = "t"
 			}
-			columns = append(columns, cfitsio.Column{
-				Name:   key,
-				Format: format,
-			})
-			break
+		case float64:
+			formatted[i] = strconv.FormatFloat(element, 'g', -1, 64)
+		default:
+			formatted[i] = fmt.Sprintf("%v", element)
 		}
 	}
-	return columns, nil
+	return "{" + strings.Join(formatted, ",") + "}"
 }
 
 func getFormat(value interface{}) string {
@@ -117,65 +441,9 @@ func getFormat(value interface{}) string {
 		return "E" // 32-bit floating point
 	case float64:
 		return "D" // 64-bit floating point
-	case string:
-		stringLength := len(value.(string))
-		return fmt.Sprintf("%dA", stringLength) // Character string with length
 	case bool:
 		return "L" // logical
 	default:
 		return ""
 	}
 }
-
-func cleanData(data []map[string]interface{}, columns []cfitsio.Column) []map[string]interface{} {
-	cleanedData := make([]map[string]interface{}, len(data))
-	for i := 0; i < len(data); i++ {
-		cleanedData[i] = make(map[string]interface{}, len(columns))
-	}
-	keys := make([]string, 0, len(data[0]))
-	for key := range data[0] {
-		keys = append(keys, key)
-	}
-	// sort the keys to ensure consistent column order
-	sort.Strings(keys)
-	for _, key := range keys {
-		for i := 0; i < len(data); i++ {
-			if data[i][key] != nil {
-				cleanedData[i][key] = data[i][key]
-				continue
-			}
-			for j := 0; j < len(columns); j++ {
-				if columns[j].Name != key {
-					continue
-				}
-				format := columns[j].Format
-				cleanedData[i][key] = getZeroValueForFormat(format)
-			}
-		}
-	}
-	return cleanedData
-}
-
-func getZeroValueForFormat(format string) interface{} {
-	if strings.Contains(format, "A") {
-		format = "A"
-	}
-	switch format {
-	case "I":
-		return int16(0)
-	case "J":
-		return int32(0)
-	case "K":
-		return int64(0)
-	case "E":
-		return float32(0)
-	case "D":
-		return float64(0)
-	case "A":
-		return ""
-	case "L":
-		return false
-	default:
-		return nil
-	}
-}
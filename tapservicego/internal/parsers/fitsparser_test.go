@@ -1,16 +1,18 @@
 package parsers
 
 import (
-	// "reflect"
-
-	"os"
+	"ataps/pkg/fits"
+	"ataps/pkg/votable"
+	"bytes"
+	"math"
 	"testing"
+	"time"
 
-	"github.com/astrogo/cfitsio"
 	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
 )
 
-func TestParseFits(t *testing.T) {
+func TestWriteFits(t *testing.T) {
 	data := []map[string]interface{}{
 		{
 			"name":         "Alice",
@@ -37,52 +39,35 @@ func TestParseFits(t *testing.T) {
 			"float64value": nil,
 		},
 	}
-	fname, err := ParseFits(data)
-	if err != nil {
+	var buffer bytes.Buffer
+	if err := WriteFits(data, &buffer); err != nil {
 		t.Fatal(err)
 	}
-	defer os.Remove(fname)
 	// should be able to read the fits file and parse it
-	fitsFile, err := cfitsio.Open(fname, cfitsio.ReadOnly)
+	parsedData, columns, err := ReadFits(&buffer)
 	if err != nil {
 		t.Fatal(err)
 	}
-	defer fitsFile.Close()
-	table := fitsFile.HDU(1).(*cfitsio.Table)
-	assert.True(t, table.Type() == cfitsio.BINARY_TBL)
-	assert.Equal(t, "results", table.Name())
-	assert.Equal(t, len(data[0])-1, table.NumCols())
-	assert.Equal(t, int64(len(data)), table.NumRows())
-	rows, err := table.Read(0, table.NumRows())
-	assert.Nil(t, err)
-	defer rows.Close()
-	count := 0
-	parsedData := []map[string]interface{}{}
-	for rows.Next() {
-		row := map[string]interface{}{}
-		err = rows.Scan(&row)
-		assert.Nil(t, err)
-		parsedData = append(parsedData, row)
-		count = count + 1
-	}
+	// the column with only NULLs is kept
+	assert.Equal(t, len(data[0]), len(columns))
+	assert.Equal(t, len(data), len(parsedData))
 	for i, row := range parsedData {
+		assert.Equal(t, "", row["nullvalue"])
 		if i == len(data)-1 {
-			assert.NotContains(t, row, "nullvalue")
-			assert.Equal(t, row["name"], " ")
-			assert.Equal(t, row["age"], int64(0))
-			assert.Equal(t, row["boolvalue"], false)
-			assert.Equal(t, row["float32value"], float32(0.0))
-			assert.Equal(t, row["float64value"], float64(0.0))
+			// the NULL strings are blank
+			assert.Equal(t, "", row["name"])
+			assert.Nil(t, row["age"])
+			assert.Nil(t, row["boolvalue"])
+			assert.Nil(t, row["float32value"])
+			assert.Nil(t, row["float64value"])
 			break
 		}
-		assert.NotContains(t, row, "nullvalue")
 		assert.Equal(t, data[i]["name"], row["name"])
 		assert.Equal(t, data[i]["age"], row["age"])
 		assert.Equal(t, data[i]["boolvalue"], row["boolvalue"])
-		assert.Equal(t, data[i]["float32value"], row["float32value"])
+		assert.Equal(t, float64(data[i]["float32value"].(float32)), row["float32value"])
 		assert.Equal(t, data[i]["float64value"], row["float64value"])
 	}
-	assert.Equal(t, len(data), count)
 }
 
 func TestCreateColumns(t *testing.T) {
@@ -90,7 +75,7 @@ func TestCreateColumns(t *testing.T) {
 		{"name": "Alice", "age": 30},
 		{"name": "Bob", "age": 25},
 	}
-	col, err := createColumns(data)
+	col, err := createColumns(data, []Column{{Name: "age", Unit: "yr"}})
 	assert.Nil(t, err)
 	assert.NotNil(t, col)
 	assert.Equal(t, 2, len(col))
@@ -98,42 +83,259 @@ func TestCreateColumns(t *testing.T) {
 	assert.Equal(t, "name", col[1].Name)
 	assert.Equal(t, "K", col[0].Format)
 	assert.Equal(t, "5A", col[1].Format)
+	assert.Equal(t, "yr", col[0].Unit)
+	assert.Equal(t, "", col[1].Unit)
 }
 
-func TestCreateFits(t *testing.T) {
+func TestWriteFitsColumns(t *testing.T) {
 	data := []map[string]interface{}{
-		{"name": "Alice", "age": 30},
-		{"name": "Bob", "age": 25},
+		{"oid": "ZTF1", "ra": 10.5, "ndet": int64(3)},
+		{"oid": "ZTF2", "ra": 11.5, "ndet": int64(4)},
 	}
-	// create fits file
-	f, err := os.CreateTemp("", "*.fits")
-	if err != nil {
-		t.Fatal(err)
+	var buffer bytes.Buffer
+	err := WriteFits(data, &buffer, Column{
+		Name:        "ra",
+		Unit:        "deg",
+		UCD:         "pos.eq.ra",
+		Utype:       "stc:AstroCoords.Position2D.Value2.C1",
+		Description: "Right ascension of the detection",
+	})
+	require.NoError(t, err)
+	content := buffer.Bytes()
+	require.Zero(t, len(content)%fits.BlockSize)
+	// the primary header takes a block, and the table header another
+	require.Len(t, content, 3*fits.BlockSize)
+	header := string(content[fits.BlockSize : 2*fits.BlockSize])
+	for _, card := range []fits.Card{
+		{Keyword: "NAXIS1", Value: 8 + 8 + 4},
+		{Keyword: "NAXIS2", Value: int64(2)},
+		{Keyword: "TTYPE1", Value: "ndet"},
+		{Keyword: "TFORM1", Value: "K"},
+		{Keyword: "TTYPE2", Value: "oid"},
+		{Keyword: "TFORM2", Value: "4A"},
+		{Keyword: "TTYPE3", Value: "ra"},
+		{Keyword: "TUNIT3", Value: "deg"},
+		{Keyword: "TUCD3", Value: "pos.eq.ra"},
+		{Keyword: "TUTYP3", Value: "stc:AstroCoords.Position2D.Value2.C1"},
+		{Keyword: "TCOMM3", Value: "Right ascension of the detection"},
+	} {
+		assert.Contains(t, header, card.String()[:30], card.Keyword)
 	}
-	fname := f.Name()
-	os.Remove(fname)
-	defer os.Remove(fname)
-	f.Close() // close immediately since we only need the filename
-	fitsFile, err := cfitsio.Create(fname)
-	if err != nil {
-		t.Fatal(err)
+	assert.NotContains(t, header, "TUNIT1")
+	row := content[2*fits.BlockSize : 2*fits.BlockSize+20]
+	assert.Equal(t, []byte{0, 0, 0, 0, 0, 0, 0, 3}, row[:8])
+	assert.Equal(t, "ZTF1", string(row[8:12]))
+	assert.Equal(t, []byte{0x40, 0x25, 0, 0, 0, 0, 0, 0}, row[12:20])
+}
+
+func TestWriteFitsEmpty(t *testing.T) {
+	var buffer bytes.Buffer
+	require.NoError(t, WriteFits(nil, &buffer))
+	assert.Len(t, buffer.Bytes(), 2*fits.BlockSize)
+	assert.Contains(t, buffer.String(), "TFIELDS =                    0")
+}
+
+func TestWriteFitsNulls(t *testing.T) {
+	data := []map[string]interface{}{
+		{"ndet": int64(3), "ra": 10.5, "oid": "ZTF1", "fid": nil, "comment": nil},
+		{"ndet": nil, "ra": nil, "oid": nil, "fid": nil, "comment": nil},
 	}
-	defer fitsFile.Close()
-	// write primary hdu
-	phdu, err := cfitsio.NewPrimaryHDU(&fitsFile, cfitsio.NewDefaultHeader())
-	if err != nil {
-		t.Fatal(err)
+	var buffer bytes.Buffer
+	err := WriteFits(data, &buffer, Column{Name: "fid", Type: "INT2"}, Column{Name: "ra", Type: "FLOAT8"})
+	require.NoError(t, err)
+	content := buffer.Bytes()
+	header := string(content[fits.BlockSize : 2*fits.BlockSize])
+	// the columns are comment, fid, ndet, oid and ra
+	for _, card := range []fits.Card{
+		{Keyword: "TTYPE1", Value: "comment"},
+		{Keyword: "TFORM1", Value: "1A"},
+		{Keyword: "TFORM2", Value: "I"},
+		{Keyword: "TNULL2", Value: int64(math.MinInt16)},
+		{Keyword: "TFORM3", Value: "K"},
+		{Keyword: "TNULL3", Value: int64(math.MinInt64)},
+		{Keyword: "TFORM5", Value: "D"},
+	} {
+		assert.Contains(t, header, card.String()[:30], card.Keyword)
 	}
-	defer phdu.Close()
-	result, err := CreateFits(data, &fitsFile)
-	if err != nil {
-		t.Fatal(err)
+	assert.NotContains(t, header, "TNULL5")
+	width := 1 + 2 + 8 + 4 + 8
+	rows := content[2*fits.BlockSize : 2*fits.BlockSize+2*width]
+	assert.Equal(t, []byte(" \x80\x00\x00\x00\x00\x00\x00\x00\x00\x03ZTF1\x40\x25\x00\x00\x00\x00\x00\x00"), rows[:width])
+	assert.Equal(t, []byte(" \x80\x00\x80\x00\x00\x00\x00\x00\x00\x00    \x7f\xf8\x00\x00\x00\x00\x00\x00"), rows[width:])
+}
+
+func TestWriteFitsWidths(t *testing.T) {
+	data := []map[string]interface{}{
+		{"oid": "ZTF1"},
+		{"oid": "ZTF18abcdefg"},
+		{"oid": nil},
+	}
+	var buffer bytes.Buffer
+	require.NoError(t, WriteFits(data, &buffer))
+	content := buffer.Bytes()
+	// the strings are as wide as the longest one
+	assert.Contains(t, string(content[fits.BlockSize:2*fits.BlockSize]), fits.Card{Keyword: "TFORM1", Value: "12A"}.String()[:30])
+	assert.Equal(t, "ZTF1        ZTF18abcdefg            ", string(content[2*fits.BlockSize:2*fits.BlockSize+36]))
+}
+
+func TestWriteFitsNonASCII(t *testing.T) {
+	data := []map[string]interface{}{{"name": "Café\n"}, {"name": "ñu"}}
+	var buffer bytes.Buffer
+	require.NoError(t, WriteFits(data, &buffer))
+	content := buffer.Bytes()
+	// each character other than printable ASCII takes a byte
+	assert.Contains(t, string(content[fits.BlockSize:2*fits.BlockSize]), fits.Card{Keyword: "TFORM1", Value: "5A"}.String()[:30])
+	assert.Equal(t, "Caf???u   ", string(content[2*fits.BlockSize:2*fits.BlockSize+10]))
+}
+
+func TestWriteFitsArrays(t *testing.T) {
+	data := []map[string]interface{}{
+		{"fids": "{1,2}", "mags": "{18.5,NULL,19}", "flags": "{t}", "stamp": []byte{1, 2}, "names": "{a,b}"},
+		{"fids": "{3}", "mags": "{}", "flags": nil, "stamp": nil, "names": nil},
+	}
+	var buffer bytes.Buffer
+	err := WriteFits(data, &buffer,
+		Column{Name: "fids", Type: "_INT2"},
+		Column{Name: "mags", Type: "_FLOAT8"},
+		Column{Name: "flags", Type: "_BOOL"},
+		Column{Name: "stamp", Type: "BYTEA"},
+		Column{Name: "names", Type: "_TEXT"},
+	)
+	require.NoError(t, err)
+	content := buffer.Bytes()
+	header := string(content[fits.BlockSize : 2*fits.BlockSize])
+	// the columns are fids, flags, mags, names and stamp
+	for _, card := range []fits.Card{
+		{Keyword: "TFORM1", Value: "2I"},
+		{Keyword: "TNULL1", Value: int64(math.MinInt16)},
+		{Keyword: "TFORM2", Value: "1L"},
+		{Keyword: "TFORM3", Value: "3D"},
+		{Keyword: "TFORM4", Value: "5A"},
+		{Keyword: "TFORM5", Value: "2B"},
+	} {
+		assert.Contains(t, header, card.String()[:30], card.Keyword)
+	}
+	assert.NotContains(t, header, "TNULL5")
+	width := 4 + 1 + 24 + 5 + 2
+	rows := content[2*fits.BlockSize : 2*fits.BlockSize+2*width]
+	nan := "\x7f\xf8\x00\x00\x00\x00\x00\x00"
+	assert.Equal(t, []byte("\x00\x01\x00\x02T\x40\x32\x80\x00\x00\x00\x00\x00"+nan+"\x40\x33\x00\x00\x00\x00\x00\x00{a,b}\x01\x02"), rows[:width])
+	// the shorter arrays are padded with NULLs
+	assert.Equal(t, []byte("\x00\x03\x80\x00\x00"+nan+nan+nan+"     \x00\x00"), rows[width:])
+}
+
+func TestWriteFitsArraysInvalid(t *testing.T) {
+	data := []map[string]interface{}{{"fids": "{1,a}"}}
+	err := WriteFits(data, &bytes.Buffer{}, Column{Name: "fids", Type: "_INT4"})
+	assert.ErrorContains(t, err, "Column fids: Invalid array {1,a}")
+}
+
+func TestWriteFitsTimes(t *testing.T) {
+	data := []map[string]interface{}{
+		{"mjd": 60000.5, "created": time.Date(2023, 2, 25, 12, 30, 0, 0, time.UTC), "night": "2023-02-25"},
+	}
+	var buffer bytes.Buffer
+	err := WriteFits(data, &buffer,
+		Column{Name: "mjd", Type: "FLOAT8", Unit: "d", Timesys: &votable.Timesys{ID: "mjd_utc", TimeOrigin: "MJD-origin", TimeScale: "UTC", RefPosition: "TOPOCENTER"}},
+		Column{Name: "night", Type: "DATE"},
+	)
+	require.NoError(t, err)
+	content := buffer.Bytes()
+	header := string(content[fits.BlockSize : 2*fits.BlockSize])
+	for _, card := range []fits.Card{
+		{Keyword: "TTYPE1", Value: "created"},
+		{Keyword: "TFORM1", Value: "19A"},
+		{Keyword: "TFORM2", Value: "D"},
+		{Keyword: "TUNIT2", Value: "d"},
+		{Keyword: "TFORM3", Value: "10A"},
+		{Keyword: "TIMESYS", Value: "UTC", Comment: "time scale"},
+		{Keyword: "MJDREF", Value: 0.0},
+		{Keyword: "TREFPOS", Value: "TOPOCENTER", Comment: "reference position"},
+	} {
+		assert.Contains(t, header, card.String()[:30], card.Keyword)
+	}
+	assert.Equal(t, "2023-02-25T12:30:00", string(content[2*fits.BlockSize:2*fits.BlockSize+19]))
+	// dates and timestamps alone are in UTC
+	buffer.Reset()
+	require.NoError(t, WriteFits(data, &buffer, Column{Name: "night", Type: "DATE"}))
+	header = string(buffer.Bytes()[fits.BlockSize : 2*fits.BlockSize])
+	assert.Contains(t, header, fits.Card{Keyword: "TIMESYS", Value: "UTC", Comment: "time scale"}.String()[:30])
+	assert.NotContains(t, header, "MJDREF")
+}
+
+func TestReadFits(t *testing.T) {
+	data := []map[string]interface{}{
+		{"oid": "ZTF1", "ndet": int64(3), "mags": "{18.5,NULL}", "fids": "{1,2}", "ra": 10.5},
+		{"oid": "ZTF2", "ndet": nil, "mags": "{19}", "fids": "{3}", "ra": nil},
+	}
+	columns := []Column{
+		{Name: "ndet", Type: "INT4", UCD: "meta.number"},
+		{Name: "mags", Type: "_FLOAT4", Unit: "mag"},
+		{Name: "fids", Type: "_INT8"},
+		{Name: "ra", Type: "FLOAT8", Description: "Right ascension"},
+	}
+	var buffer bytes.Buffer
+	require.NoError(t, WriteFits(data, &buffer, columns...))
+	parsed, parsedColumns, err := ReadFits(&buffer)
+	require.NoError(t, err)
+	assert.Equal(t, []Column{
+		{Name: "fids", Type: "_INT8"},
+		{Name: "mags", Type: "_FLOAT4", Unit: "mag"},
+		{Name: "ndet", Type: "INT4", UCD: "meta.number"},
+		{Name: "oid", Type: "TEXT"},
+		{Name: "ra", Type: "FLOAT8", Description: "Right ascension"},
+	}, parsedColumns)
+	// the shorter arrays are padded with NULLs
+	assert.Equal(t, []map[string]interface{}{
+		{"oid": "ZTF1", "ndet": int64(3), "mags": "{18.5,NULL}", "fids": "{1,2}", "ra": 10.5},
+		{"oid": "ZTF2", "ndet": nil, "mags": "{19,NULL}", "fids": "{3,NULL}", "ra": nil},
+	}, parsed)
+	_, _, err = ReadFits(bytes.NewReader(nil))
+	assert.ErrorContains(t, err, "No table in the FITS file")
+}
+
+// FuzzReadFits checks that malformed files return errors
+// rather than panicking, as they are uploaded by the users
+func FuzzReadFits(f *testing.F) {
+	var buffer bytes.Buffer
+	err := WriteFits([]map[string]interface{}{{"fids": "{1,2}", "oid": "ZTF1"}}, &buffer, Column{Name: "fids", Type: "_INT2"})
+	require.NoError(f, err)
+	f.Add(buffer.Bytes())
+	// descriptors without the type code of their elements
+	f.Add(bytes.Replace(buffer.Bytes(), []byte("'2I "), []byte("'1P "), 1))
+	f.Add([]byte(nil))
+	f.Fuzz(func(t *testing.T, data []byte) {
+		ReadFits(bytes.NewReader(data))
+	})
+}
+
+func TestFitsDatabaseType(t *testing.T) {
+	testCases := []struct {
+		format      string
+		ascii       bool
+		scale, zero interface{}
+		expected    string
+	}{
+		{"K", false, nil, nil, "INT8"},
+		{"1E", false, nil, nil, "FLOAT4"},
+		{"20A", false, nil, nil, "TEXT"},
+		{"3D", false, nil, nil, "_FLOAT8"},
+		{"12X", false, nil, nil, "_BOOL"},
+		{"1PJ(10)", false, nil, nil, "_INT4"},
+		{"QA(8)", false, nil, nil, "TEXT"},
+		{"I", false, nil, 32768.0, "INT4"},
+		{"K", false, nil, 9223372036854775808.0, "NUMERIC"},
+		{"J", false, 0.5, nil, "FLOAT8"},
+		{"2B", false, 1.0, 0.5, "_FLOAT8"},
+		{"I6", true, nil, nil, "INT8"},
+		{"I6", true, nil, int64(10), "FLOAT8"},
+		{"F10.4", true, nil, nil, "FLOAT8"},
+		{"A8", true, nil, nil, "TEXT"},
+		{"1P", false, nil, nil, "TEXT"},
+		{"P", false, nil, nil, "TEXT"},
+		{"0Q", false, nil, nil, "TEXT"},
+	}
+	for _, tc := range testCases {
+		assert.Equal(t, tc.expected, fitsDatabaseType(tc.format, tc.ascii, tc.scale, tc.zero), tc.format)
 	}
-	assert.NotNil(t, result)
-	assert.Equal(t, "results", result.Name())
-	assert.Equal(t, 2, result.NumCols())
-	assert.Equal(t, "age", result.Col(0).Name)
-	assert.Equal(t, "name", result.Col(1).Name)
-	assert.Equal(t, "K", result.Col(0).Format)
-	assert.Equal(t, "5A", result.Col(1).Format)
 }
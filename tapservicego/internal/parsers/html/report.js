@@ -0,0 +1,78 @@
+(function () {
+	"use strict";
+	var pageSize = 50;
+	var table = document.getElementById("results");
+	var headers = Array.prototype.slice.call(table.tHead.rows[0].cells);
+	var filters = Array.prototype.slice.call(table.tHead.rows[1].querySelectorAll("input"));
+	var body = table.tBodies[0];
+	var rows = Array.prototype.slice.call(body.rows);
+	var nav = document.querySelector("nav.pages");
+	var page = 0;
+	var visible = rows;
+
+	// the value of a cell, without its links, NULLs being null
+	function value(row, column) {
+		var cell = row.cells[column];
+		if (cell.hasAttribute("data-null")) {
+			return null;
+		}
+		return cell.firstChild ? cell.firstChild.nodeValue || "" : "";
+	}
+
+	function render() {
+		var pages = Math.max(1, Math.ceil(visible.length / pageSize));
+		page = Math.min(page, pages - 1);
+		rows.forEach(function (row) { row.hidden = true; });
+		visible.slice(page * pageSize, (page + 1) * pageSize).forEach(function (row) {
+			row.hidden = false;
+			body.appendChild(row);
+		});
+		nav.hidden = pages < 2;
+		nav.querySelector(".page").textContent = "Page " + (page + 1) + " of " + pages;
+		nav.querySelector(".previous").disabled = page === 0;
+		nav.querySelector(".next").disabled = page >= pages - 1;
+	}
+
+	function filter() {
+		var terms = filters.map(function (input) { return input.value.toLowerCase(); });
+		visible = rows.filter(function (row) {
+			return terms.every(function (term, column) {
+				if (term === "") {
+					return true;
+				}
+				var v = value(row, column);
+				return (v === null ? "null" : v.toLowerCase()).indexOf(term) >= 0;
+			});
+		});
+		page = 0;
+		render();
+	}
+
+	function sort(column) {
+		var header = headers[column];
+		var ascending = header.getAttribute("aria-sort") !== "ascending";
+		var numeric = header.classList.contains("num");
+		headers.forEach(function (h) { h.removeAttribute("aria-sort"); });
+		header.setAttribute("aria-sort", ascending ? "ascending" : "descending");
+		var compare = function (a, b) {
+			var x = value(a, column), y = value(b, column);
+			// NULLs go last in both orders
+			if (x === null || y === null) {
+				return (x === null) - (y === null);
+			}
+			var order = numeric ? Number(x) - Number(y) : x.localeCompare(y);
+			return ascending ? order : -order;
+		};
+		rows.sort(compare);
+		visible.sort(compare);
+		render();
+	}
+
+	headers.forEach(function (header, column) {
+		header.addEventListener("click", function () { sort(column); });
+	});
+	filters.forEach(function (input) { input.addEventListener("input", filter); });
+	nav.querySelector(".previous").addEventListener("click", function () { page--; render(); });
+	nav.querySelector(".next").addEventListener("click", function () { page++; render(); });
+	render();
+})();
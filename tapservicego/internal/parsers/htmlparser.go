@@ -1,42 +1,179 @@
 package parsers
 
 import (
+	"embed"
+	"fmt"
 	"html/template"
 	"io"
+	"net/url"
+	"strings"
 )
 
-func ParseHTML(data []map[string]interface{}, writer io.Writer) error {
-	const tpl = `<!DOCTYPE html>
-<html>
-	<head>
-		<title>Results</title>
-	</head>
-	<body>
-		<h1>Results</h1>
-		<table>
-			<tr>
-				{{- range $key, $value := index . 0 }}
-				<th>{{$key}}</th>
-				{{- end }}
-			</tr>
-			{{- range $index, $row := .}}
-			<tr>
-				{{- range $key, $value := $row }}
-				<td>{{$value}}</td>
-				{{- end }}
-			</tr>
-			{{- end}}
-		</table>
-	</body>
-</html>
-`
-	t, err := template.New("html").Parse(tpl)
+// htmlFiles are the template of the HTML reports
+// and the styles and scripts embedded in them
+//
+//go:embed html
+var htmlFiles embed.FS
+
+// htmlTemplate is the template of the HTML reports
+var htmlTemplate = template.Must(template.ParseFS(htmlFiles, "html/report.html"))
+
+// objectLinks are the links written next to the object identifiers,
+// to the ALeRCE explorer and to the light curve of the object
+var objectLinks = []htmlLink{
+	{Name: "explorer", URL: "https://alerce.online/object/%s"},
+	{Name: "light curve", URL: "https://api.alerce.online/ztf/v1/objects/%s/lightcurve"},
+}
+
+// objectColumn is the name of the column of the object identifiers
+const objectColumn = "oid"
+
+// htmlOptions are the options of ParseHTML, see HTMLOption
+type htmlOptions struct {
+	keywords []Keyword
+	params   []Keyword
+	formats  []string
+	overflow bool
+}
+
+// HTMLOption sets an option of ParseHTML
+type HTMLOption func(*htmlOptions)
+
+// WithKeywords shows the keywords over the table, as
+// the provenance of the results, such as the query
+func WithKeywords(keywords ...Keyword) HTMLOption {
+	return func(o *htmlOptions) {
+		o.keywords = append(o.keywords, keywords...)
+	}
+}
+
+// WithDownloads adds buttons to download the results in the formats,
+// posting the parameters of the query with each FORMAT to the page
+func WithDownloads(params []Keyword, formats ...string) HTMLOption {
+	return func(o *htmlOptions) {
+		o.params = params
+		o.formats = formats
+	}
+}
+
+// WithOverflow tells that the results were truncated to MAXREC rows
+func WithOverflow(overflow bool) HTMLOption {
+	return func(o *htmlOptions) {
+		o.overflow = overflow
+	}
+}
+
+// htmlLink is a link of a cell
+type htmlLink struct {
+	Name string
+	URL  string
+}
+
+// htmlColumn is a column of an HTML report
+type htmlColumn struct {
+	Name    string
+	Title   string
+	Numeric bool
+}
+
+// htmlCell is a cell of an HTML report
+type htmlCell struct {
+	Value   string
+	Numeric bool
+	Null    bool
+	Links   []htmlLink
+}
+
+// ParseHTML writes the data as a self-contained HTML report, whose
+// table can be sorted by clicking on the names of the columns,
+// filtered by the values of the columns, and is paged by 50 rows.
+// The headers of the columns with a description have it as their
+// title, with their unit and UCD, so it shows when the pointer is
+// over them. NULLs are written as NULL, and timestamps and arrays as
+// in DALI. The object identifiers of the oid column link to the
+// ALeRCE explorer and to their light curve.
+//
+// The columns are those of the data, or the columns given when there
+// are no rows. The options can show the query over the table and add
+// buttons to download the results in other formats, see HTMLOption.
+func ParseHTML(data []map[string]interface{}, writer io.Writer, columns []Column, opts ...HTMLOption) error {
+	var options htmlOptions
+	for _, opt := range opts {
+		opt(&options)
+	}
+	css, err := htmlFiles.ReadFile("html/report.css")
 	if err != nil {
 		return err
 	}
-	err = t.Execute(writer, data)
+	js, err := htmlFiles.ReadFile("html/report.js")
 	if err != nil {
 		return err
 	}
-	return nil
+	keys := getColumnNames(data, columns)
+	byName := columnsByName(columns)
+	htmlColumns := make([]htmlColumn, len(keys))
+	rows := make([][]htmlCell, len(data))
+	for i := range rows {
+		rows[i] = make([]htmlCell, len(keys))
+	}
+	for j, key := range keys {
+		t := getFieldType(data, key, byName[key])
+		_, numeric := ipacTypes[t.datatype]
+		numeric = numeric && t.arraySize == ""
+		htmlColumns[j] = htmlColumn{Name: key, Title: columnTitle(byName[key]), Numeric: numeric}
+		for i, row := range data {
+			cell := htmlCell{Value: "NULL", Numeric: numeric, Null: row[key] == nil}
+			if !cell.Null {
+				cell.Value, _ = t.formatValue(row[key])
+				if key == objectColumn {
+					for _, link := range objectLinks {
+						href := fmt.Sprintf(link.URL, url.PathEscape(cell.Value))
+						cell.Links = append(cell.Links, htmlLink{Name: link.Name, URL: href})
+					}
+				}
+			}
+			rows[i][j] = cell
+		}
+	}
+	count := fmt.Sprintf("%d rows", len(data))
+	if len(data) == 1 {
+		count = "1 row"
+	}
+	return htmlTemplate.Execute(writer, struct {
+		CSS      template.CSS
+		JS       template.JS
+		Keywords []Keyword
+		Params   []Keyword
+		Formats  []string
+		Count    string
+		Overflow bool
+		Columns  []htmlColumn
+		Rows     [][]htmlCell
+	}{
+		CSS:      template.CSS(css),
+		JS:       template.JS(js),
+		Keywords: options.keywords,
+		Params:   options.params,
+		Formats:  options.formats,
+		Count:    count,
+		Overflow: options.overflow,
+		Columns:  htmlColumns,
+		Rows:     rows,
+	})
+}
+
+// columnTitle describes the column in a single line,
+// such as "Mean right ascension [deg] (pos.eq.ra;meta.main)"
+func columnTitle(column Column) string {
+	parts := []string{}
+	if column.Description != "" {
+		parts = append(parts, column.Description)
+	}
+	if column.Unit != "" {
+		parts = append(parts, fmt.Sprintf("[%s]", column.Unit))
+	}
+	if column.UCD != "" {
+		parts = append(parts, fmt.Sprintf("(%s)", column.UCD))
+	}
+	return strings.Join(parts, " ")
 }
@@ -2,42 +2,71 @@ package parsers
 
 import (
 	"bytes"
+	"strings"
 	"testing"
+	"time"
 
 	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
 )
 
 func TestParseHTML(t *testing.T) {
 	data := []map[string]interface{}{
 		{"name": "Alice", "age": 30},
-		{"name": "Bob", "age": 25},
+		{"name": "Bob", "age": nil},
 	}
 	var htmlResult bytes.Buffer
-	err := ParseHTML(data, &htmlResult)
-	expected := `<!DOCTYPE html>
-<html>
-	<head>
-		<title>Results</title>
-	</head>
-	<body>
-		<h1>Results</h1>
-		<table>
-			<tr>
-				<th>age</th>
-				<th>name</th>
-			</tr>
-			<tr>
-				<td>30</td>
-				<td>Alice</td>
-			</tr>
-			<tr>
-				<td>25</td>
-				<td>Bob</td>
-			</tr>
-		</table>
-	</body>
-</html>
-`
-	assert.Nil(t, err)
-	assert.Equal(t, expected, htmlResult.String())
+	err := ParseHTML(data, &htmlResult, nil)
+	require.NoError(t, err)
+	result := htmlResult.String()
+	assert.True(t, strings.HasPrefix(result, "<!DOCTYPE html>\n"))
+	assert.Contains(t, result, `<p class="count">2 rows</p>`)
+	assert.Contains(t, result, "<th class=\"num\">age</th>\n\t\t\t\t\t<th>name</th>\n")
+	assert.Contains(t, result, "<td class=\"num\">30</td>\n\t\t\t\t\t<td>Alice</td>\n")
+	assert.Contains(t, result, "<td class=\"num\" data-null>NULL</td>\n\t\t\t\t\t<td>Bob</td>\n")
+	// the styles and scripts are embedded
+	assert.Contains(t, result, "<style>body {")
+	assert.Contains(t, result, `<script>(function () {`)
+	assert.NotContains(t, result, "<form")
+	assert.NotContains(t, result, "http")
+}
+
+func TestParseHTMLColumns(t *testing.T) {
+	data := []map[string]interface{}{
+		{"meanra": 10.5, "oid": "ZTF20aaelulu", "firstmjd": time.Date(2020, 1, 2, 3, 4, 5, 0, time.UTC)},
+	}
+	var htmlResult bytes.Buffer
+	err := ParseHTML(data, &htmlResult, []Column{
+		{Name: "meanra", Unit: "deg", UCD: "pos.eq.ra;meta.main", Description: "Mean right ascension"},
+		{Name: "oid", Description: `Object "identifier"`},
+	})
+	require.NoError(t, err)
+	result := htmlResult.String()
+	assert.Contains(t, result, `<th title="Mean right ascension [deg] (pos.eq.ra;meta.main)" class="num">meanra</th>`)
+	assert.Contains(t, result, `<th title="Object &#34;identifier&#34;">oid</th>`)
+	assert.Contains(t, result, `<td>2020-01-02T03:04:05</td>`)
+	assert.Contains(t, result, `<p class="count">1 row</p>`)
+	assert.Contains(t, result, `<td>ZTF20aaelulu<span class="links"> `+
+		`<a href="https://alerce.online/object/ZTF20aaelulu" target="_blank" rel="noopener">explorer</a> `+
+		`<a href="https://api.alerce.online/ztf/v1/objects/ZTF20aaelulu/lightcurve" target="_blank" rel="noopener">light curve</a></span></td>`)
+}
+
+func TestParseHTMLOptions(t *testing.T) {
+	var htmlResult bytes.Buffer
+	query := "SELECT oid FROM object WHERE ndet > 1 LIMIT 1"
+	err := ParseHTML(nil, &htmlResult, []Column{{Name: "oid", Type: "VARCHAR"}},
+		WithKeywords(Keyword{Name: "LANG", Value: "PSQL"}, Keyword{Name: "QUERY", Value: query}),
+		WithDownloads([]Keyword{{Name: "LANG", Value: "PSQL"}, {Name: "QUERY", Value: query}}, "csv", "votable"),
+		WithOverflow(true),
+	)
+	require.NoError(t, err)
+	result := htmlResult.String()
+	assert.Contains(t, result, "<dt>LANG</dt>\n\t\t\t<dd>PSQL</dd>")
+	assert.Contains(t, result, "<dt>QUERY</dt>\n\t\t\t<dd><pre>SELECT oid FROM object WHERE ndet &gt; 1 LIMIT 1</pre></dd>")
+	assert.Contains(t, result, `<input type="hidden" name="QUERY" value="SELECT oid FROM object WHERE ndet &gt; 1 LIMIT 1">`)
+	assert.Contains(t, result, `<button type="submit" name="FORMAT" value="csv">csv</button>`)
+	assert.Contains(t, result, `<button type="submit" name="FORMAT" value="votable">votable</button>`)
+	assert.Contains(t, result, `<p class="count">0 rows, truncated to MAXREC</p>`)
+	assert.Contains(t, result, "<th>oid</th>")
+	assert.Contains(t, result, "<tbody>\n\t\t\t</tbody>")
 }
@@ -0,0 +1,119 @@
+package parsers
+
+import (
+	"fmt"
+	"strings"
+)
+
+// Keyword is a keyword of the header of a table, such as
+// the query giving its results
+type Keyword struct {
+	Name  string
+	Value string
+}
+
+// ipacNull is the value written for the NULLs of every column
+const ipacNull = "null"
+
+// ipacTypes maps the VOTable datatypes to the types of IPAC tables
+var ipacTypes = map[string]string{
+	"unsignedByte": "int",
+	"short":        "int",
+	"int":          "int",
+	"long":         "long",
+	"float":        "real",
+	"double":       "double",
+}
+
+// ParseIPAC converts a slice of maps to an IPAC table, the fixed-width
+// ASCII format of the IRSA tools. The keywords are written first, as
+// the provenance of the results, followed by the descriptions of the
+// columns as comments and the header rows giving the name, type, unit
+// and NULL value of each column, "null", between "|".
+//
+// The types are those of the database types of the columns, or of their
+// values, booleans and arrays being written as strings, and dates and
+// timestamps having the type date. Each column is as wide as its widest
+// value or header, the numbers being aligned to the right.
+//
+// Example output:
+//
+//	\fixlen = T
+//	\QUERY = 'SELECT name, age FROM people'
+//	|age |name |
+//	|long|char |
+//	|yr  |     |
+//	|null|null |
+//	   30 Alice
+//	 null Bob
+func ParseIPAC(data []map[string]interface{}, keywords []Keyword, columns ...Column) string {
+	keys := getColumnNames(data, columns)
+	byName := columnsByName(columns)
+	var b strings.Builder
+	b.WriteString("\\fixlen = T\n")
+	for _, keyword := range keywords {
+		// the quotes of the values are doubled, as in FITS
+		value := strings.ReplaceAll(singleLine(keyword.Value), "'", "''")
+		fmt.Fprintf(&b, "\\%s = '%s'\n", keyword.Name, value)
+	}
+	for _, key := range keys {
+		if description := byName[key].Description; description != "" {
+			fmt.Fprintf(&b, "\\ %s: %s\n", key, singleLine(description))
+		}
+	}
+	types := make([]string, len(keys))
+	widths := make([]int, len(keys))
+	numeric := make([]bool, len(keys))
+	values := make([][]string, len(data))
+	for i := range values {
+		values[i] = make([]string, len(keys))
+	}
+	for j, key := range keys {
+		t := getFieldType(data, key, byName[key])
+		types[j] = "char"
+		if ipacType, ok := ipacTypes[t.datatype]; ok && t.arraySize == "" {
+			types[j] = ipacType
+			numeric[j] = true
+		} else if t.xtype == "timestamp" {
+			types[j] = "date"
+		}
+		widths[j] = max(len(key), len(types[j]), len(byName[key].Unit), len(ipacNull))
+		for i, row := range data {
+			value := ipacNull
+			if row[key] != nil {
+				value, _ = t.formatValue(row[key])
+				value = singleLine(value)
+			}
+			values[i][j] = value
+			widths[j] = max(widths[j], len(value))
+		}
+	}
+	headers := [][]string{keys, types, make([]string, len(keys)), make([]string, len(keys))}
+	for j, key := range keys {
+		headers[2][j] = byName[key].Unit
+		headers[3][j] = ipacNull
+	}
+	for _, header := range headers {
+		for j, value := range header {
+			fmt.Fprintf(&b, "|%-*s", widths[j], value)
+		}
+		b.WriteString("|\n")
+	}
+	for _, row := range values {
+		for j, value := range row {
+			if numeric[j] {
+				fmt.Fprintf(&b, " %*s", widths[j], value)
+			} else {
+				fmt.Fprintf(&b, " %-*s", widths[j], value)
+			}
+		}
+		b.WriteString("\n")
+	}
+	return b.String()
+}
+
+// singleLine replaces the line breaks of a value by blanks, as
+// the keywords and the rows of IPAC tables take a single line
+func singleLine(value string) string {
+	return strings.NewReplacer("\r\n", " ", "\n", " ", "\r", " ").Replace(value)
+}
@@ -0,0 +1,43 @@
+package parsers
+
+import (
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestParseIPAC(t *testing.T) {
+	data := []map[string]interface{}{
+		{"oid": "ZTF18abcdefg", "ndet": int64(3), "meanra": 10.5, "stellar": true, "firstdate": time.Date(2023, 2, 25, 12, 30, 0, 0, time.UTC)},
+		{"oid": "ZTF2\nb", "ndet": nil, "meanra": nil, "stellar": nil, "firstdate": nil},
+	}
+	keywords := []Keyword{{Name: "QUERY", Value: "SELECT *\nFROM object"}}
+	result := ParseIPAC(data, keywords,
+		Column{Name: "oid", Type: "VARCHAR", Description: "Object identifier"},
+		Column{Name: "ndet", Type: "INT4"},
+		Column{Name: "meanra", Type: "FLOAT8", Unit: "deg"},
+	)
+	expected := `\fixlen = T
+\QUERY = 'SELECT * FROM object'
+\ oid: Object identifier
+|firstdate          |meanra|ndet|oid         |stellar|
+|date               |double|int |char        |char   |
+|                   |deg   |    |            |       |
+|null               |null  |null|null        |null   |
+ 2023-02-25T12:30:00   10.5    3 ZTF18abcdefg true   
+ null                  null null ZTF2 b       null   
+`
+	assert.Equal(t, expected, result)
+}
+
+func TestParseIPACQuotedKeyword(t *testing.T) {
+	keywords := []Keyword{{Name: "QUERY", Value: "SELECT * FROM object WHERE oid = 'ZTF1'"}}
+	result := ParseIPAC(nil, keywords, Column{Name: "oid"})
+	assert.Contains(t, result, `\QUERY = 'SELECT * FROM object WHERE oid = ''ZTF1'''`+"\n")
+}
+
+func TestParseIPACEmpty(t *testing.T) {
+	result := ParseIPAC(nil, nil, Column{Name: "oid"})
+	assert.Equal(t, "\\fixlen = T\n|oid |\n|char|\n|    |\n|null|\n", result)
+}
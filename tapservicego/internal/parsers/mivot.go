@@ -0,0 +1,226 @@
+package parsers
+
+import (
+	"ataps/pkg/votable"
+	"fmt"
+	"slices"
+	"sort"
+)
+
+// resultsTableID is the ID the MIVOT templates use to refer to the results
+const resultsTableID = "results"
+
+// mivotModels are the data models used by the annotations
+var mivotModels = []votable.Model{
+	{Name: "ivoa", URL: "https://www.ivoa.net/xml/VODML/IVOA-v1.vo-dml.xml"},
+	{Name: "meas", URL: "https://www.ivoa.net/xml/Meas/20200908/Meas-v1.0.vo-dml.xml"},
+	{Name: "coords", URL: "https://www.ivoa.net/xml/STC/20200908/Coords-v1.0.vo-dml.xml"},
+}
+
+// CreateAnnotatedVOTable is like CreateVOTable, but the VOTable has a
+// MIVOT block mapping the columns of the results to the Meas and Coords
+// models: the sky position with its errors, the time of the observation
+// and the magnitude with its error, in the photometric system of the
+// filter of each row. The columns are recognized by their UCDs, and when
+// several columns have the same UCD the first one by name is used.
+func CreateAnnotatedVOTable(data []map[string]interface{}, columns ...Column) (votable.VOTable, error) {
+	result, err := CreateVOTable(data, columns...)
+	if err != nil {
+		return result, err
+	}
+	byName := columnsByName(columns)
+	keys := getColumnNames(data, columns)
+	find := func(ucds ...string) (Column, bool) {
+		for _, ucd := range ucds {
+			for _, key := range keys {
+				if byName[key].UCD == ucd {
+					return byName[key], true
+				}
+			}
+		}
+		return Column{}, false
+	}
+	vodml := &votable.VODML{Xmlns: votable.MIVOTNamespace, Globals: &votable.Globals{}}
+	var instances []votable.Instance
+	var refs []string
+	lon, hasLon := find("pos.eq.ra;meta.main", "pos.eq.ra")
+	lat, hasLat := find("pos.eq.dec;meta.main", "pos.eq.dec")
+	if hasLon && hasLat {
+		coord := votable.Instance{
+			DmRole: "meas:Position.coord",
+			DmType: "coords:LonLatPoint",
+			Attributes: []votable.Attribute{
+				columnAttribute("coords:LonLatPoint.lon", lon),
+				columnAttribute("coords:LonLatPoint.lat", lat),
+			},
+		}
+		if lon.Coosys != nil {
+			id := "_spacesys_" + lon.Coosys.ID
+			vodml.Globals.Instances = append(vodml.Globals.Instances, spaceSysInstance(id, lon.Coosys))
+			coord.References = []votable.Reference{{DmRole: "coords:Coordinate.coordSys", DmRef: id}}
+		}
+		position := measureInstance("meas:Position", "pos", coord)
+		refs = append(refs, lon.Name, lat.Name)
+		lonError, hasLonError := find("stat.stdev;pos.eq.ra", "stat.error;pos.eq.ra")
+		latError, hasLatError := find("stat.stdev;pos.eq.dec", "stat.error;pos.eq.dec")
+		if hasLonError && hasLatError {
+			lonAxis := columnAttribute("meas:Ellipse.semiAxis", lonError)
+			lonAxis.ArrayIndex = "0"
+			latAxis := columnAttribute("meas:Ellipse.semiAxis", latError)
+			latAxis.ArrayIndex = "1"
+			position.Instances = append(position.Instances, errorInstance(votable.Instance{
+				DmRole: "meas:Error.statError",
+				DmType: "meas:Ellipse",
+				Attributes: []votable.Attribute{
+					lonAxis,
+					latAxis,
+					{DmRole: "meas:Ellipse.posAngle", DmType: "ivoa:RealQuantity", Value: "0", Unit: "deg"},
+				},
+			}))
+			refs = append(refs, lonError.Name, latError.Name)
+		}
+		instances = append(instances, position)
+	}
+	if mjd, ok := find("time.epoch"); ok {
+		coord := votable.Instance{
+			DmRole:     "meas:Time.coord",
+			DmType:     "coords:MJD",
+			Attributes: []votable.Attribute{{DmRole: "coords:MJD.date", DmType: "ivoa:real", Ref: mjd.Name}},
+		}
+		if mjd.Timesys != nil {
+			id := "_timesys_" + mjd.Timesys.ID
+			vodml.Globals.Instances = append(vodml.Globals.Instances, timeSysInstance(id, mjd.Timesys))
+			coord.References = []votable.Reference{{DmRole: "coords:Coordinate.coordSys", DmRef: id}}
+		}
+		instances = append(instances, measureInstance("meas:Time", "time.epoch", coord))
+		refs = append(refs, mjd.Name)
+	}
+	if mag, ok := find("phot.mag"); ok {
+		coord := votable.Instance{
+			DmRole:     "meas:GenericMeasure.coord",
+			DmType:     "coords:PhysicalCoordinate",
+			Attributes: []votable.Attribute{columnAttribute("coords:PhysicalCoordinate.cval", mag)},
+		}
+		if filter, ok := find("instr.filter"); ok && len(data) > 0 {
+			vodml.Globals.Collections = append(vodml.Globals.Collections, photSysCollection(data, filter.Name))
+			coord.References = []votable.Reference{{
+				DmRole:      "coords:Coordinate.coordSys",
+				SourceRef:   "_photsys",
+				ForeignKeys: []votable.ForeignKey{{Ref: filter.Name}},
+			}}
+			refs = append(refs, filter.Name)
+		}
+		photometry := measureInstance("meas:GenericMeasure", "phot.mag", coord)
+		if magError, ok := find("stat.error;phot.mag"); ok {
+			photometry.Instances = append(photometry.Instances, errorInstance(votable.Instance{
+				DmRole:     "meas:Error.statError",
+				DmType:     "meas:Symmetrical",
+				Attributes: []votable.Attribute{columnAttribute("meas:Symmetrical.radius", magError)},
+			}))
+			refs = append(refs, magError.Name)
+		}
+		instances = append(instances, photometry)
+		refs = append(refs, mag.Name)
+	}
+	if len(instances) == 0 {
+		vodml.Report = &votable.Report{Status: "FAILED", Message: "No columns of the results can be annotated"}
+		vodml.Globals = nil
+	} else {
+		vodml.Report = &votable.Report{Status: "OK", Message: "Positions, times and magnitudes of the results"}
+		vodml.Models = mivotModels
+		vodml.Templates = []votable.Templates{{TableRef: resultsTableID, Instances: instances}}
+		if len(vodml.Globals.Instances) == 0 && len(vodml.Globals.Collections) == 0 {
+			vodml.Globals = nil
+		}
+	}
+	table := &result.Resource.Tables[0]
+	table.ID = resultsTableID
+	// the attributes refer to the fields by their ID
+	for i := range table.Fields {
+		if slices.Contains(refs, table.Fields[i].Name) {
+			table.Fields[i].ID = table.Fields[i].Name
+		}
+	}
+	result.Resource.Resources = []votable.Resource{{Type: "meta", VODML: vodml}}
+	return result, nil
+}
+
+// measureInstance creates a measure of the type with its coordinate
+func measureInstance(dmtype string, ucd string, coord votable.Instance) votable.Instance {
+	return votable.Instance{
+		DmType:     dmtype,
+		Attributes: []votable.Attribute{{DmRole: "meas:Measure.ucd", DmType: "ivoa:string", Value: ucd}},
+		Instances:  []votable.Instance{coord},
+	}
+}
+
+// errorInstance creates the error of a measure with its statistical error
+func errorInstance(statError votable.Instance) votable.Instance {
+	return votable.Instance{
+		DmRole:    "meas:Measure.error",
+		DmType:    "meas:Error",
+		Instances: []votable.Instance{statError},
+	}
+}
+
+// columnAttribute creates a quantity taken from the column
+func columnAttribute(dmrole string, column Column) votable.Attribute {
+	return votable.Attribute{DmRole: dmrole, DmType: "ivoa:RealQuantity", Ref: column.Name, Unit: column.Unit}
+}
+
+// spaceSysInstance creates the space system of a coordinate system
+func spaceSysInstance(id string, coosys *votable.Coosys) votable.Instance {
+	frame := votable.Instance{
+		DmRole:     "coords:PhysicalCoordSys.frame",
+		DmType:     "coords:SpaceFrame",
+		Attributes: []votable.Attribute{{DmRole: "coords:SpaceFrame.spaceRefFrame", DmType: "ivoa:string", Value: coosys.System}},
+	}
+	if coosys.Equinox != "" {
+		frame.Attributes = append(frame.Attributes, votable.Attribute{DmRole: "coords:SpaceFrame.equinox", DmType: "coords:Epoch", Value: coosys.Equinox})
+	}
+	return votable.Instance{DmID: id, DmType: "coords:SpaceSys", Instances: []votable.Instance{frame}}
+}
+
+// timeSysInstance creates the time system of a TIMESYS
+func timeSysInstance(id string, timesys *votable.Timesys) votable.Instance {
+	return votable.Instance{
+		DmID:   id,
+		DmType: "coords:TimeSys",
+		Instances: []votable.Instance{{
+			DmRole:     "coords:PhysicalCoordSys.frame",
+			DmType:     "coords:TimeFrame",
+			Attributes: []votable.Attribute{{DmRole: "coords:TimeFrame.timescale", DmType: "ivoa:string", Value: timesys.TimeScale}},
+			Instances: []votable.Instance{{
+				DmRole:     "coords:TimeFrame.refPosition",
+				DmType:     "coords:StdRefLocation",
+				Attributes: []votable.Attribute{{DmRole: "coords:StdRefLocation.position", DmType: "ivoa:string", Value: timesys.RefPosition}},
+			}},
+		}},
+	}
+}
+
+// photSysCollection creates a photometric system for each value of the
+// filter column in the data, keyed by the value, so each row refers
+// to the system of its filter
+func photSysCollection(data []map[string]interface{}, filter string) votable.Collection {
+	var values []string
+	for _, row := range data {
+		if row[filter] == nil {
+			continue
+		}
+		value := fmt.Sprintf("%v", row[filter])
+		if !slices.Contains(values, value) {
+			values = append(values, value)
+		}
+	}
+	sort.Strings(values)
+	collection := votable.Collection{DmID: "_photsys"}
+	for _, value := range values {
+		collection.Instances = append(collection.Instances, votable.Instance{
+			DmID:        "_photsys_" + value,
+			DmType:      "coords:PhysicalCoordSys",
+			PrimaryKeys: []votable.PrimaryKey{{DmType: "ivoa:string", Value: value}},
+		})
+	}
+	return collection
+}
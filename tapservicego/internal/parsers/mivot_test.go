@@ -0,0 +1,96 @@
+package parsers
+
+import (
+	"ataps/pkg/votable"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func detectionColumns() []Column {
+	icrs := &votable.Coosys{ID: "icrs", System: "ICRS"}
+	mjd := &votable.Timesys{ID: "mjd_utc", TimeOrigin: "MJD-origin", TimeScale: "UTC", RefPosition: "TOPOCENTER"}
+	return []Column{
+		{Name: "ra", Unit: "deg", UCD: "pos.eq.ra", Coosys: icrs},
+		{Name: "dec", Unit: "deg", UCD: "pos.eq.dec", Coosys: icrs},
+		{Name: "sigmara", Unit: "deg", UCD: "stat.stdev;pos.eq.ra"},
+		{Name: "sigmadec", Unit: "deg", UCD: "stat.stdev;pos.eq.dec"},
+		{Name: "mjd", Unit: "d", UCD: "time.epoch", Timesys: mjd},
+		{Name: "magpsf", Unit: "mag", UCD: "phot.mag"},
+		{Name: "magpsf_corr", Unit: "mag", UCD: "phot.mag"},
+		{Name: "sigmapsf", Unit: "mag", UCD: "stat.error;phot.mag"},
+		{Name: "fid", UCD: "instr.filter"},
+	}
+}
+
+func TestCreateAnnotatedVOTable(t *testing.T) {
+	data := []map[string]interface{}{
+		{"ra": 10.5, "dec": -20.5, "sigmara": 0.1, "sigmadec": 0.2, "mjd": 60000.5, "magpsf": 18.0, "magpsf_corr": 18.1, "sigmapsf": 0.05, "fid": int64(2), "oid": "ZTF1"},
+		{"ra": 10.5, "dec": -20.5, "sigmara": 0.1, "sigmadec": 0.2, "mjd": 60001.5, "magpsf": 18.2, "magpsf_corr": nil, "sigmapsf": 0.06, "fid": int64(1), "oid": "ZTF1"},
+	}
+	result, err := CreateAnnotatedVOTable(data, detectionColumns()...)
+	require.NoError(t, err)
+	assert.Equal(t, "results", result.Resource.Tables[0].ID)
+	require.Len(t, result.Resource.Resources, 1)
+	meta := result.Resource.Resources[0]
+	assert.Equal(t, "meta", meta.Type)
+	vodml := meta.VODML
+	require.NotNil(t, vodml)
+	assert.Equal(t, "OK", vodml.Report.Status)
+	assert.Len(t, vodml.Models, 3)
+	assert.Equal(t, []string{"_spacesys_icrs", "_timesys_mjd_utc"}, []string{vodml.Globals.Instances[0].DmID, vodml.Globals.Instances[1].DmID})
+	// a photometric system for each filter of the rows
+	photsys := vodml.Globals.Collections[0]
+	assert.Equal(t, "_photsys", photsys.DmID)
+	assert.Equal(t, "_photsys_1", photsys.Instances[0].DmID)
+	assert.Equal(t, "1", photsys.Instances[0].PrimaryKeys[0].Value)
+	assert.Equal(t, "_photsys_2", photsys.Instances[1].DmID)
+	instances := vodml.Templates[0].Instances
+	assert.Equal(t, "results", vodml.Templates[0].TableRef)
+	assert.Equal(t, []string{"meas:Position", "meas:Time", "meas:GenericMeasure"}, []string{instances[0].DmType, instances[1].DmType, instances[2].DmType})
+	position := instances[0]
+	assert.Equal(t, "ra", position.Instances[0].Attributes[0].Ref)
+	assert.Equal(t, "deg", position.Instances[0].Attributes[0].Unit)
+	assert.Equal(t, "dec", position.Instances[0].Attributes[1].Ref)
+	assert.Equal(t, "_spacesys_icrs", position.Instances[0].References[0].DmRef)
+	assert.Equal(t, "sigmara", position.Instances[1].Instances[0].Attributes[0].Ref)
+	assert.Equal(t, "sigmadec", position.Instances[1].Instances[0].Attributes[1].Ref)
+	photometry := instances[2]
+	// the first column by name is used among those with the same UCD
+	assert.Equal(t, "magpsf", photometry.Instances[0].Attributes[0].Ref)
+	assert.Equal(t, "_photsys", photometry.Instances[0].References[0].SourceRef)
+	assert.Equal(t, "fid", photometry.Instances[0].References[0].ForeignKeys[0].Ref)
+	assert.Equal(t, "sigmapsf", photometry.Instances[1].Instances[0].Attributes[0].Ref)
+	// only the fields used by the annotations have an ID
+	ids := map[string]string{}
+	for _, field := range result.Resource.Tables[0].Fields {
+		ids[field.Name] = field.ID
+	}
+	assert.Equal(t, "magpsf", ids["magpsf"])
+	assert.Equal(t, "", ids["magpsf_corr"])
+	assert.Equal(t, "", ids["oid"])
+	xml, err := VOTableToXML(result)
+	require.NoError(t, err)
+	assert.Contains(t, xml, `<RESOURCE type="meta">
+			<VODML xmlns="http://www.ivoa.net/xml/mivot">
+				<REPORT status="OK">Positions, times and magnitudes of the results</REPORT>`)
+	assert.Contains(t, xml, `<ATTRIBUTE dmrole="meas:Ellipse.semiAxis" dmtype="ivoa:RealQuantity" ref="sigmadec" unit="deg" arrayindex="1"></ATTRIBUTE>`)
+	assert.Contains(t, xml, `<REFERENCE dmrole="coords:Coordinate.coordSys" sourceref="_photsys">
+								<FOREIGN_KEY ref="fid"></FOREIGN_KEY>
+							</REFERENCE>`)
+	parsed, err := votable.NewVOTableFromString(xml)
+	require.NoError(t, err)
+	assert.Equal(t, vodml.Templates, parsed.Resource.Resources[0].VODML.Templates)
+}
+
+func TestCreateAnnotatedVOTableWithoutAnnotations(t *testing.T) {
+	data := []map[string]interface{}{{"oid": "ZTF1", "ndet": int64(3)}}
+	result, err := CreateAnnotatedVOTable(data, Column{Name: "ndet", UCD: "meta.number"})
+	require.NoError(t, err)
+	vodml := result.Resource.Resources[0].VODML
+	assert.Equal(t, "FAILED", vodml.Report.Status)
+	assert.Empty(t, vodml.Models)
+	assert.Nil(t, vodml.Globals)
+	assert.Empty(t, vodml.Templates)
+}
@@ -1,40 +1,177 @@
 package parsers
 
 import (
-	"bytes"
 	"fmt"
+	"strings"
+	"time"
+	"unicode/utf8"
 )
 
-func ParseText(data []map[string]interface{}) string {
-	var textResult bytes.Buffer
-	parseTextData(data, &textResult)
-	return textResult.String()
-}
-
-func parseTextData(data []map[string]interface{}, buffer *bytes.Buffer) {
-	fmt.Fprintln(buffer, "# Results:")
-	fmt.Fprintln(buffer, "#")
-	fmt.Fprintln(buffer, "# Headers:")
-	headers := getHeaders(data[0])
-	header_line := "# "
-	for i, header := range headers {
-		if i == len(headers)-1 {
-			header_line += fmt.Sprintf("%v", header)
-			break
+// textOptions are the options of ParseText, see TextOption
+type textOptions struct {
+	truncate  int
+	wrap      int
+	queryTime time.Duration
+}
+
+// TextOption sets an option of ParseText
+type TextOption func(*textOptions)
+
+// WithTruncate truncates the values longer than width characters,
+// ending them with "…". They are not truncated by default.
+func WithTruncate(width int) TextOption {
+	return func(o *textOptions) {
+		o.truncate = width
+	}
+}
+
+// WithWrap splits the tables wider than width characters into pages
+// of the columns that fit in the width, written one after the other.
+// The tables are not split by default.
+func WithWrap(width int) TextOption {
+	return func(o *textOptions) {
+		o.wrap = width
+	}
+}
+
+// WithQueryTime writes the time taken by the query in the footer
+func WithQueryTime(queryTime time.Duration) TextOption {
+	return func(o *textOptions) {
+		o.queryTime = queryTime
+	}
+}
+
+// textColumn is a column of a text table with its formatted values
+type textColumn struct {
+	name    string
+	numeric bool
+	width   int
+	values  []string
+}
+
+// ParseText converts a slice of maps to an aligned text table,
+// as psql writes them. The names of the columns are centered over
+// their values, which are separated by " | ", the numbers being
+// aligned to the right, and a footer gives the number of rows.
+// NULLs are empty, and timestamps and arrays are written as in DALI.
+//
+// The columns are those of the data, or the columns given when there
+// are no rows. The options can truncate long values, page wide tables
+// and add the time of the query to the footer, see TextOption.
+//
+// Example output:
+//
+//	 age |    city     | name
+//	-----+-------------+-------
+//	  30 | New York    | Alice
+//	  25 | Los Angeles | Bob
+//	(2 rows)
+func ParseText(data []map[string]interface{}, columns []Column, opts ...TextOption) string {
+	var options textOptions
+	for _, opt := range opts {
+		opt(&options)
+	}
+	keys := getColumnNames(data, columns)
+	byName := columnsByName(columns)
+	textColumns := make([]textColumn, len(keys))
+	for j, key := range keys {
+		t := getFieldType(data, key, byName[key])
+		_, numeric := ipacTypes[t.datatype]
+		column := textColumn{name: key, numeric: numeric && t.arraySize == "", values: make([]string, len(data))}
+		column.width = utf8.RuneCountInString(key)
+		for i, row := range data {
+			if row[key] == nil {
+				continue
+			}
+			value, _ := t.formatValue(row[key])
+			value = options.truncateValue(singleLine(value))
+			column.values[i] = value
+			column.width = max(column.width, utf8.RuneCountInString(value))
+		}
+		textColumns[j] = column
+	}
+	var b strings.Builder
+	for i, page := range options.pages(textColumns) {
+		if i > 0 {
+			b.WriteString("\n")
 		}
-		header_line += fmt.Sprintf("%v | ", header)
-	}
-	fmt.Fprintln(buffer, header_line)
-	for _, row := range data {
-		converted := convertRowValuesToString(row, headers)
-		row_line := ""
-		for i, value := range converted {
-			if i == len(converted)-1 {
-				row_line += fmt.Sprintf("%v", value)
-				break
+		writeTextPage(&b, page, len(data))
+	}
+	if len(data) == 1 {
+		b.WriteString("(1 row)\n")
+	} else {
+		fmt.Fprintf(&b, "(%d rows)\n", len(data))
+	}
+	if options.queryTime > 0 {
+		fmt.Fprintf(&b, "Time: %.3f ms\n", float64(options.queryTime)/float64(time.Millisecond))
+	}
+	return b.String()
+}
+
+// truncateValue truncates the value to the width of the options
+func (o textOptions) truncateValue(value string) string {
+	if o.truncate <= 0 || utf8.RuneCountInString(value) <= o.truncate {
+		return value
+	}
+	runes := []rune(value)
+	return string(runes[:max(o.truncate-1, 0)]) + "…"
+}
+
+// pages splits the columns into pages that fit in the width to wrap,
+// each having at least a column
+func (o textOptions) pages(columns []textColumn) [][]textColumn {
+	if len(columns) == 0 {
+		return nil
+	}
+	if o.wrap <= 0 {
+		return [][]textColumn{columns}
+	}
+	var pages [][]textColumn
+	start, width := 0, 0
+	for j, column := range columns {
+		// the separators take three characters, and the
+		// first column is padded on both sides
+		columnWidth := column.width + 3
+		if j == start {
+			columnWidth = column.width + 2
+		}
+		if j > start && width+columnWidth > o.wrap {
+			pages = append(pages, columns[start:j])
+			start, columnWidth = j, column.width+2
+			width = 0
+		}
+		width += columnWidth
+	}
+	return append(pages, columns[start:])
+}
+
+// writeTextPage writes the header and the rows of the columns
+func writeTextPage(b *strings.Builder, columns []textColumn, rows int) {
+	cells := make([]string, len(columns))
+	for j, column := range columns {
+		padding := column.width - utf8.RuneCountInString(column.name)
+		cells[j] = strings.Repeat(" ", padding/2) + column.name + strings.Repeat(" ", padding-padding/2)
+	}
+	writeTextLine(b, cells, " | ", " ")
+	for j, column := range columns {
+		cells[j] = strings.Repeat("-", column.width)
+	}
+	writeTextLine(b, cells, "-+-", "-")
+	for i := 0; i < rows; i++ {
+		for j, column := range columns {
+			padding := strings.Repeat(" ", column.width-utf8.RuneCountInString(column.values[i]))
+			if column.numeric {
+				cells[j] = padding + column.values[i]
+			} else {
+				cells[j] = column.values[i] + padding
 			}
-			row_line += fmt.Sprintf("%v | ", value)
 		}
-		fmt.Fprintln(buffer, row_line)
+		writeTextLine(b, cells, " | ", " ")
 	}
 }
+
+// writeTextLine writes the cells joined by the separator, between
+// the padding, without the trailing blanks
+func writeTextLine(b *strings.Builder, cells []string, separator string, padding string) {
+	b.WriteString(strings.TrimRight(padding+strings.Join(cells, separator)+padding, " ") + "\n")
+}
@@ -2,6 +2,7 @@ package parsers
 
 import (
 	"testing"
+	"time"
 
 	"github.com/stretchr/testify/assert"
 )
@@ -11,13 +12,56 @@ func TestParseText(t *testing.T) {
 		{"name": "Alice", "age": 30, "city": "New York"},
 		{"name": "Bob", "age": 25, "city": "Los Angeles"},
 	}
-	expected := `# Results:
-#
-# Headers:
-# age | city | name
-30 | New York | Alice
-25 | Los Angeles | Bob
+	expected := ` age |    city     | name
+-----+-------------+-------
+  30 | New York    | Alice
+  25 | Los Angeles | Bob
+(2 rows)
 `
-	result := ParseText(data)
+	result := ParseText(data, nil)
 	assert.Equal(t, expected, result)
 }
+
+func TestParseTextColumns(t *testing.T) {
+	data := []map[string]interface{}{
+		{"oid": "ZTF1", "mag": 18.5, "seen": time.Date(2024, 1, 2, 3, 4, 5, 0, time.UTC), "flags": "{1,2}"},
+		{"oid": "ZTF12345678901234567890", "mag": nil, "seen": nil, "flags": nil},
+	}
+	columns := []Column{{Name: "flags", Type: "_INT4"}, {Name: "mag", Type: "FLOAT8"}, {Name: "oid", Type: "TEXT"}, {Name: "seen", Type: "TIMESTAMP"}}
+	expected := ` flags | mag  |         oid          |        seen
+-------+------+----------------------+---------------------
+ 1 2   | 18.5 | ZTF1                 | 2024-01-02T03:04:05
+       |      | ZTF1234567890123456… |
+(2 rows)
+Time: 1.500 ms
+`
+	result := ParseText(data, columns, WithTruncate(20), WithQueryTime(1500*time.Microsecond))
+	assert.Equal(t, expected, result)
+}
+
+func TestParseTextEmpty(t *testing.T) {
+	assert.Equal(t, "(0 rows)\n", ParseText(nil, nil))
+	expected := ` id | name
+----+------
+(0 rows)
+`
+	assert.Equal(t, expected, ParseText(nil, []Column{{Name: "name", Type: "TEXT"}, {Name: "id", Type: "INT8"}}))
+}
+
+func TestParseTextWrap(t *testing.T) {
+	data := []map[string]interface{}{{"a": "aaaa", "b": "bbbb", "c": "cccc"}, {"a": "x", "b": "y", "c": "z"}}
+	expected := `  a   |  b
+------+------
+ aaaa | bbbb
+ x    | y
+
+  c
+------
+ cccc
+ z
+(2 rows)
+`
+	assert.Equal(t, expected, ParseText(data, nil, WithWrap(15)))
+	// a column wider than the width has its own page
+	assert.Equal(t, 3, len(textOptions{wrap: 3}.pages(make([]textColumn, 3))))
+}
@@ -3,13 +3,18 @@ package parsers
 import (
 	"ataps/pkg/votable"
 	"encoding/xml"
-	"fmt"
-	"reflect"
+	"slices"
 	"sort"
 	"strings"
 )
 
-func CreateVOTable(data []map[string]interface{}) (votable.VOTable, error) {
+// CreateVOTable creates a VOTable with the data, describing
+// its fields with the units, UCDs and descriptions of the columns.
+// The datatypes of the fields are those of the database types of
+// the columns, when they are known, and otherwise those of the values.
+// Columns are taken from the data, or from the columns when there
+// are no rows.
+func CreateVOTable(data []map[string]interface{}, columns ...Column) (votable.VOTable, error) {
 	result := votable.VOTable{
 		Version: "1.4",
 		Xmlns:   "http://www.ivoa.net/xml/VOTable/v1.4",
@@ -24,101 +29,101 @@ func CreateVOTable(data []map[string]interface{}) (votable.VOTable, error) {
 			},
 		},
 	}
-	result.Resource.Tables[0].Fields = addFields(data)
-	result.Resource.Tables[0].Data.TableData = addTableData(data)
+	keys := getColumnNames(data, columns)
+	byName := columnsByName(columns)
+	types := make([]fieldType, len(keys))
+	for i, key := range keys {
+		types[i] = getFieldType(data, key, byName[key])
+	}
+	tableData, nulls := addTableData(data, keys, types)
+	result.Resource.Tables[0].Fields = addFields(keys, types, byName, nulls)
+	result.Resource.Tables[0].Data.TableData = tableData
+	result.Resource.Coosys, result.Resource.Timesys = addSystems(keys, byName)
 	return result, nil
 }
 
-func addFields(data []map[string]interface{}) []votable.Field {
-	if len(data) == 0 {
-		return []votable.Field{}
-	}
-	fields := []votable.Field{}
-	var keys []string
-	for key := range data[0] {
-		keys = append(keys, key)
-	}
-	sort.Strings(keys)
+// addSystems returns the coordinate and time systems
+// the fields of the columns refer to, without duplicates
+func addSystems(keys []string, byName map[string]Column) ([]votable.Coosys, []votable.Timesys) {
+	var coosys []votable.Coosys
+	var timesys []votable.Timesys
 	for _, key := range keys {
-		// TODO: add unit, description, etc.
-		datatype := getDataType(data[0][key])
-		field := votable.Field{Name: key, Datatype: datatype}
-		arraySize := getArraySize(data[0], key, datatype)
-		if arraySize != "0" {
-			field.ArraySize = arraySize
+		column := byName[key]
+		if column.Coosys != nil && !slices.ContainsFunc(coosys, func(c votable.Coosys) bool { return c.ID == column.Coosys.ID }) {
+			coosys = append(coosys, *column.Coosys)
+		}
+		if column.Timesys != nil && !slices.ContainsFunc(timesys, func(t votable.Timesys) bool { return t.ID == column.Timesys.ID }) {
+			timesys = append(timesys, *column.Timesys)
 		}
-		fields = append(fields, field)
 	}
-	return fields
+	return coosys, timesys
 }
 
-func getArraySize(val map[string]interface{}, key string, datatype string) string {
-	if val[key] == nil {
-		return "0"
-	}
-	if datatype == "char" {
-		return "100*"
+// getColumnNames returns the sorted names of the columns of the data,
+// or of the columns when there is no data
+func getColumnNames(data []map[string]interface{}, columns []Column) []string {
+	keys := []string{}
+	if len(data) > 0 {
+		for key := range data[0] {
+			keys = append(keys, key)
+		}
+	} else {
+		for _, column := range columns {
+			keys = append(keys, column.Name)
+		}
 	}
-	return "0"
+	sort.Strings(keys)
+	return keys
 }
 
-func getDataType(v interface{}) string {
-	if v == nil {
-		return "float"
-	}
-
-	t := reflect.TypeOf(v)
-	k := t.Kind()
-
-	switch k {
-	case reflect.Bool:
-		return "boolean"
-	case reflect.Int8, reflect.Int16:
-		return "short"
-	case reflect.Uint8:
-		return "unsignedByte"
-	case reflect.Int32:
-		return "int"
-	case reflect.Int64, reflect.Int:
-		return "long"
-	case reflect.Float32:
-		return "float"
-	case reflect.Float64:
-		return "double"
-	case reflect.Complex64:
-		return "floatComplex"
-	case reflect.Complex128:
-		return "doubleComplex"
-	case reflect.String:
-		return "char"
-	default:
-		return "unknown"
+// addFields creates the fields of the columns, declaring the null
+// value of the integer columns that have NULLs
+func addFields(keys []string, types []fieldType, byName map[string]Column, nulls []bool) []votable.Field {
+	fields := []votable.Field{}
+	for i, key := range keys {
+		column := byName[key]
+		xtype := column.Xtype
+		if xtype == "" {
+			xtype = types[i].xtype
+		}
+		field := votable.Field{
+			Name:        key,
+			Datatype:    types[i].datatype,
+			ArraySize:   types[i].arraySize,
+			Unit:        column.Unit,
+			Ucd:         column.UCD,
+			Utype:       column.Utype,
+			Xtype:       xtype,
+			Description: column.Description,
+		}
+		if column.Coosys != nil {
+			field.Ref = column.Coosys.ID
+		} else if column.Timesys != nil {
+			field.Ref = column.Timesys.ID
+		}
+		if nulls[i] {
+			field.Values = &votable.Values{Null: nullValues[types[i].datatype]}
+		}
+		fields = append(fields, field)
 	}
+	return fields
 }
 
-func addTableData(data []map[string]interface{}) votable.TableData {
+// addTableData formats the rows, and tells which columns have NULLs
+// written with a null value
+func addTableData(data []map[string]interface{}, keys []string, types []fieldType) (votable.TableData, []bool) {
 	tableData := votable.TableData{}
+	nulls := make([]bool, len(keys))
 	for _, row := range data {
-		tableData.Rows = append(tableData.Rows, votable.Row{Columns: addColumns(row)})
-	}
-	return tableData
-}
-
-func addColumns(row map[string]interface{}) []votable.Column {
-	columns := []votable.Column{}
-	keys := make([]string, 0, len(row))
-	for key := range row {
-		keys = append(keys, key)
-	}
-	sort.Strings(keys)
-	for _, key := range keys {
-		if row[key] == nil {
-			columns = append(columns, votable.Column{Value: ""})
-			continue
+		columns := make([]votable.Column, len(keys))
+		for i, key := range keys {
+			value, null := types[i].formatValue(row[key])
+			columns[i] = votable.Column{Value: value}
+			nulls[i] = nulls[i] || null
 		}
-		columns = append(columns, votable.Column{Value: fmt.Sprintf("%v", row[key])})
+		tableData.Rows = append(tableData.Rows, votable.Row{Columns: columns})
 	}
-	return columns
+	return tableData, nulls
 }
 
 func VOTableToXML(votable votable.VOTable) (string, error) {
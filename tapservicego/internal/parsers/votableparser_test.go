@@ -3,6 +3,7 @@ package parsers
 import (
 	"ataps/pkg/votable"
 	"testing"
+	"time"
 
 	"github.com/stretchr/testify/assert"
 )
@@ -67,6 +68,61 @@ func TestCreateVOTable(t *testing.T) {
 	assert.Equal(t, "4", votable.Resource.Tables[0].Data.TableData.Rows[1].Columns[1].Value)
 }
 
+func TestCreateVOTableColumns(t *testing.T) {
+	data := []map[string]interface{}{
+		{"meanra": 10.5, "ndet": int64(3)},
+	}
+	votable, err := CreateVOTable(data, Column{
+		Name:        "meanra",
+		Unit:        "deg",
+		UCD:         "pos.eq.ra;meta.main",
+		Utype:       "stc:AstroCoords.Position2D.Value2.C1",
+		Description: "Mean right ascension",
+	})
+	assert.NoError(t, err)
+	fields := votable.Resource.Tables[0].Fields
+	assert.Equal(t, "deg", fields[0].Unit)
+	assert.Equal(t, "pos.eq.ra;meta.main", fields[0].Ucd)
+	assert.Equal(t, "stc:AstroCoords.Position2D.Value2.C1", fields[0].Utype)
+	assert.Equal(t, "Mean right ascension", fields[0].Description)
+	// columns without metadata are left bare
+	assert.Equal(t, "", fields[1].Unit)
+	assert.Equal(t, "", fields[1].Description)
+	result, err := VOTableToXML(votable)
+	assert.NoError(t, err)
+	assert.Contains(t, result, `<FIELD name="meanra" datatype="double" unit="deg" ucd="pos.eq.ra;meta.main" utype="stc:AstroCoords.Position2D.Value2.C1">
+				<DESCRIPTION>Mean right ascension</DESCRIPTION>
+			</FIELD>`)
+	assert.Contains(t, result, `<FIELD name="ndet" datatype="long"></FIELD>`)
+}
+
+func TestCreateVOTableSystems(t *testing.T) {
+	icrs := &votable.Coosys{ID: "icrs", System: "ICRS"}
+	mjd := &votable.Timesys{ID: "mjd_utc", TimeOrigin: "MJD-origin", TimeScale: "UTC", RefPosition: "TOPOCENTER"}
+	data := []map[string]interface{}{
+		{"ra": 10.5, "dec": -20.5, "mjd": 60000.5, "magpsf": 18.0},
+	}
+	result, err := CreateVOTable(data,
+		Column{Name: "ra", Coosys: icrs},
+		Column{Name: "dec", Coosys: icrs},
+		Column{Name: "mjd", Timesys: mjd},
+	)
+	assert.NoError(t, err)
+	// each system is written once
+	assert.Equal(t, []votable.Coosys{*icrs}, result.Resource.Coosys)
+	assert.Equal(t, []votable.Timesys{*mjd}, result.Resource.Timesys)
+	xml, err := VOTableToXML(result)
+	assert.NoError(t, err)
+	assert.Contains(t, xml, `<INFO name="QUERY_STATUS" value="OK"></INFO>
+		<COOSYS ID="icrs" system="ICRS"></COOSYS>
+		<TIMESYS ID="mjd_utc" timeorigin="MJD-origin" timescale="UTC" refposition="TOPOCENTER"></TIMESYS>
+		<TABLE name="results">`)
+	assert.Contains(t, xml, `<FIELD name="dec" datatype="double" ref="icrs"></FIELD>`)
+	assert.Contains(t, xml, `<FIELD name="magpsf" datatype="double"></FIELD>`)
+	assert.Contains(t, xml, `<FIELD name="mjd" datatype="double" ref="mjd_utc"></FIELD>`)
+	assert.Contains(t, xml, `<FIELD name="ra" datatype="double" ref="icrs"></FIELD>`)
+}
+
 func TestCreateVOTableEmptyData(t *testing.T) {
 	data := []map[string]interface{}{}
 	votable, err := CreateVOTable(data)
@@ -154,3 +210,74 @@ func TestVOTableToXML(t *testing.T) {
 </VOTABLE>`
 	assert.Equal(t, expectedResult, result)
 }
+
+func TestCreateVOTableTypes(t *testing.T) {
+	timestamp := time.Date(2024, 3, 1, 12, 30, 0, 500000000, time.FixedZone("CLT", -3*3600))
+	data := []map[string]interface{}{
+		{"a": "{1.5,NULL}", "b": []byte{1, 2}, "i": nil, "j": "{1,NULL}", "t": timestamp, "s": nil},
+		{"a": "{}", "b": nil, "i": int64(2), "j": nil, "t": nil, "s": "text"},
+	}
+	votable, err := CreateVOTable(data,
+		Column{Name: "a", Type: "_FLOAT8"},
+		Column{Name: "b", Type: "BYTEA"},
+		Column{Name: "i", Type: "INT4"},
+		Column{Name: "j", Type: "_INT2"},
+		Column{Name: "t", Type: "TIMESTAMPTZ"},
+	)
+	assert.NoError(t, err)
+	fields := votable.Resource.Tables[0].Fields
+	assert.Equal(t, []string{"double", "unsignedByte", "int", "short", "char", "char"},
+		[]string{fields[0].Datatype, fields[1].Datatype, fields[2].Datatype, fields[3].Datatype, fields[4].Datatype, fields[5].Datatype})
+	assert.Equal(t, "*", fields[0].ArraySize)
+	assert.Equal(t, "", fields[2].ArraySize)
+	assert.Equal(t, "timestamp", fields[5].Xtype)
+	// only the integer columns with NULLs declare a null value
+	assert.Nil(t, fields[0].Values)
+	assert.Nil(t, fields[1].Values)
+	assert.Equal(t, "-2147483648", fields[2].Values.Null)
+	assert.Equal(t, "-32768", fields[3].Values.Null)
+	rows := votable.Resource.Tables[0].Data.TableData.Rows
+	values := func(row int) []string {
+		var values []string
+		for _, column := range rows[row].Columns {
+			values = append(values, column.Value)
+		}
+		return values
+	}
+	assert.Equal(t, []string{"1.5 NaN", "1 2", "-2147483648", "1 -32768", "", "2024-03-01T15:30:00.5"}, values(0))
+	assert.Equal(t, []string{"", "", "2", "", "text", ""}, values(1))
+}
+
+func TestCreateVOTableEmptyDataColumns(t *testing.T) {
+	votable, err := CreateVOTable(nil, Column{Name: "oid", Type: "TEXT"}, Column{Name: "ndet", Type: "INT4"})
+	assert.NoError(t, err)
+	fields := votable.Resource.Tables[0].Fields
+	assert.Len(t, fields, 2)
+	assert.Equal(t, "ndet", fields[0].Name)
+	assert.Equal(t, "int", fields[0].Datatype)
+	assert.Equal(t, "oid", fields[1].Name)
+	assert.Equal(t, "char", fields[1].Datatype)
+	assert.Equal(t, "*", fields[1].ArraySize)
+}
+
+func TestDatabaseFieldType(t *testing.T) {
+	testCases := []struct {
+		name     string
+		expected fieldType
+	}{
+		{"BOOL", fieldType{datatype: "boolean"}},
+		{"INT8", fieldType{datatype: "long"}},
+		{"NUMERIC", fieldType{datatype: "double"}},
+		{"UUID", fieldType{datatype: "char", arraySize: "36"}},
+		{"JSONB", charFieldType},
+		{"TEXT", charFieldType},
+		{"_FLOAT4", fieldType{datatype: "float", arraySize: "*", array: true}},
+		{"_BOOL", fieldType{datatype: "boolean", arraySize: "*", array: true}},
+		{"_TEXT", charFieldType},
+		{"_BYTEA", charFieldType},
+		{"16385", charFieldType},
+	}
+	for _, tc := range testCases {
+		assert.Equal(t, tc.expected, databaseFieldType(tc.name), tc.name)
+	}
+}
@@ -60,7 +60,10 @@ func (suite *AlerceTestSuite) TestCsv_DetectionsFromAnObject() {
 func (suite *AlerceTestSuite) TestCsv_DetectionsFromUnknownObject() {
 	var oid = "unknown"
 	records := test_get_csv_data_from_object(suite, "detection", nil, &oid)
-	suite.Require().Equal(0, len(records))
+	// only the header, as COPY writes it
+	columnNames := GetColumnNames(alercedb.Detection{})
+	sort.Strings(columnNames)
+	suite.Require().Equal([][]string{columnNames}, records)
 }
 
 func (suite *AlerceTestSuite) TestCsv_NonDetection() {
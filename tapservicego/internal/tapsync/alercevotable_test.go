@@ -16,7 +16,15 @@ func (suite *AlerceTestSuite) TestVotable_Object() {
 	suite.Require().Len(voTable.Resource.Tables[0].Fields, len(columnNames))
 	for _, field := range voTable.Resource.Tables[0].Fields {
 		suite.Require().Contains(columnNames, field.Name)
+		switch field.Name {
+		case "meanra", "meandec":
+			suite.Equal(alercedb.ICRS.ID, field.Ref)
+		case "firstmjd", "lastmjd":
+			suite.Equal(alercedb.MJDTime.ID, field.Ref)
+		}
 	}
+	suite.Equal([]votable.Coosys{*alercedb.ICRS}, voTable.Resource.Coosys)
+	suite.Equal([]votable.Timesys{*alercedb.MJDTime}, voTable.Resource.Timesys)
 	suite.Require().Len(voTable.Resource.Tables[0].Data.TableData.Rows, 3)
 }
 
@@ -89,3 +97,17 @@ func (suite *AlerceTestSuite) TestVotable_Probabilities() {
 	}
 	suite.Require().Len(voTable.Resource.Tables[0].Data.TableData.Rows, 3)
 }
+
+func (suite *AlerceTestSuite) TestVotableMivot_Detection() {
+	w := SendTestQuery("LANG=PSQL&&FORMAT=votable-mivot&&QUERY=SELECT * FROM detection LIMIT 3", suite.Service)
+	suite.Require().Equal(http.StatusOK, w.Code)
+	suite.Require().Equal("application/x-votable+xml", w.Header().Get("Content-Type"))
+	voTable, err := votable.NewVOTableFromString(w.Body.String())
+	suite.Require().NoError(err)
+	suite.Require().Len(voTable.Resource.Resources, 1)
+	vodml := voTable.Resource.Resources[0].VODML
+	suite.Require().NotNil(vodml)
+	suite.Equal("OK", vodml.Report.Status)
+	suite.Len(vodml.Templates[0].Instances, 3)
+	suite.Require().Len(voTable.Resource.Tables[0].Data.TableData.Rows, 3)
+}
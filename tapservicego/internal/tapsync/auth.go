@@ -0,0 +1,256 @@
+package tapsync
+
+import (
+	"bufio"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"os"
+	"strings"
+	"sync"
+
+	"github.com/gin-gonic/gin"
+	"golang.org/x/crypto/bcrypt"
+)
+
+// identityKey is the gin context key where the
+// authentication middleware stores the *Identity of the request
+const identityKey = "ataps.identity"
+
+// errNoCredentials is returned by authenticators when the request
+// does not carry the kind of credentials they handle
+var errNoCredentials = errors.New("no credentials")
+
+// AuthConfig is the configuration of the authentication
+// and authorization of the service.
+// Every authenticator is optional, and if none is configured
+// all requests are anonymous.
+type AuthConfig struct {
+	// TokensFile is a JSON file mapping the SHA-256 hex digest
+	// of static bearer tokens to identities
+	TokensFile string `yaml:"tokens_file"`
+	// HtpasswdFile is a file with user:bcrypt-hash lines
+	// used for HTTP basic authentication
+	HtpasswdFile string `yaml:"htpasswd_file"`
+	// JWKSFile is a JSON Web Key Set used to validate JWT bearer tokens
+	JWKSFile string `yaml:"jwks_file"`
+	// JWTIssuer is the expected iss claim of JWT tokens, if not empty
+	JWTIssuer string `yaml:"jwt_issuer"`
+	// JWTAudience is the expected aud claim of JWT tokens, if not empty
+	JWTAudience string `yaml:"jwt_audience"`
+	// JWTGroupsClaim is the claim holding the groups of the user
+	JWTGroupsClaim string `yaml:"jwt_groups_claim"`
+	// PolicyFile is a JSON file with the tables each identity can query.
+	// If empty, every identity can query every table.
+	PolicyFile string `yaml:"policy_file"`
+}
+
+// Identity is the authenticated user of a request
+type Identity struct {
+	Name   string   `json:"name"`
+	Groups []string `json:"groups"`
+	// Provider is the kind of credentials that authenticated
+	// the user: "token", "basic" or "jwt"
+	Provider string `json:"-"`
+}
+
+// Key identifies the user among those of every authenticator, as
+// names are only unique among the users of the same kind: a JWT with
+// the subject alice is jwt:alice, not the basic:alice of htpasswd
+func (i *Identity) Key() string {
+	return i.Provider + ":" + i.Name
+}
+
+// Authenticator validates the credentials of a request.
+// It returns errNoCredentials when the request does not carry
+// credentials it understands, so the next authenticator can be tried.
+type Authenticator interface {
+	Authenticate(r *http.Request) (*Identity, error)
+}
+
+// StaticTokenAuthenticator authenticates requests
+// with a fixed set of bearer tokens.
+// Tokens are stored as SHA-256 hex digests.
+type StaticTokenAuthenticator struct {
+	tokens map[string]Identity
+}
+
+// NewStaticTokenAuthenticator reads the tokens file, a JSON object
+// keyed by the SHA-256 hex digest of each token:
+//
+//	{"<sha256 of token>": {"name": "alice", "groups": ["collaboration"]}}
+func NewStaticTokenAuthenticator(fname string) (*StaticTokenAuthenticator, error) {
+	content, err := os.ReadFile(fname)
+	if err != nil {
+		return nil, err
+	}
+	tokens := map[string]Identity{}
+	if err := json.Unmarshal(content, &tokens); err != nil {
+		return nil, fmt.Errorf("Error reading tokens file %s: %w", fname, err)
+	}
+	normalized := make(map[string]Identity, len(tokens))
+	for digest, identity := range tokens {
+		normalized[strings.ToLower(digest)] = identity
+	}
+	return &StaticTokenAuthenticator{tokens: normalized}, nil
+}
+
+func (a *StaticTokenAuthenticator) Authenticate(r *http.Request) (*Identity, error) {
+	token, ok := bearerToken(r)
+	if !ok || looksLikeJWT(token) {
+		return nil, errNoCredentials
+	}
+	sum := sha256.Sum256([]byte(token))
+	identity, ok := a.tokens[hex.EncodeToString(sum[:])]
+	if !ok {
+		return nil, fmt.Errorf("Invalid token")
+	}
+	identity.Provider = "token"
+	return &identity, nil
+}
+
+// BasicAuthenticator authenticates requests using
+// HTTP basic authentication against bcrypt hashes
+type BasicAuthenticator struct {
+	users map[string][]byte
+}
+
+// NewBasicAuthenticator reads an htpasswd file with bcrypt hashes,
+// one user:hash pair per line
+func NewBasicAuthenticator(fname string) (*BasicAuthenticator, error) {
+	f, err := os.Open(fname)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+	users := map[string][]byte{}
+	scanner := bufio.NewScanner(f)
+	for scanner.Scan() {
+		line := strings.TrimSpace(scanner.Text())
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+		user, hash, ok := strings.Cut(line, ":")
+		if !ok {
+			return nil, fmt.Errorf("Error reading htpasswd file %s: invalid line %q", fname, line)
+		}
+		users[user] = []byte(hash)
+	}
+	if err := scanner.Err(); err != nil {
+		return nil, err
+	}
+	return &BasicAuthenticator{users: users}, nil
+}
+
+func (a *BasicAuthenticator) Authenticate(r *http.Request) (*Identity, error) {
+	user, password, ok := r.BasicAuth()
+	if !ok {
+		return nil, errNoCredentials
+	}
+	hash, ok := a.users[user]
+	if !ok {
+		// compare anyway so unknown users take as long as known ones
+		bcrypt.CompareHashAndPassword(dummyHash(), []byte(password))
+		return nil, fmt.Errorf("Invalid user or password")
+	}
+	if err := bcrypt.CompareHashAndPassword(hash, []byte(password)); err != nil {
+		return nil, fmt.Errorf("Invalid user or password")
+	}
+	return &Identity{Name: user, Provider: "basic"}, nil
+}
+
+var dummyHash = sync.OnceValue(func() []byte {
+	hash, _ := bcrypt.GenerateFromPassword([]byte("dummy"), bcrypt.DefaultCost)
+	return hash
+})
+
+// NewAuthenticators creates the authenticators enabled in the configuration
+func NewAuthenticators(config AuthConfig) ([]Authenticator, error) {
+	authenticators := []Authenticator{}
+	if config.TokensFile != "" {
+		a, err := NewStaticTokenAuthenticator(config.TokensFile)
+		if err != nil {
+			return nil, err
+		}
+		authenticators = append(authenticators, a)
+	}
+	if config.JWKSFile != "" {
+		a, err := NewJWTAuthenticator(config.JWKSFile, config.JWTIssuer, config.JWTAudience, config.JWTGroupsClaim)
+		if err != nil {
+			return nil, err
+		}
+		authenticators = append(authenticators, a)
+	}
+	if config.HtpasswdFile != "" {
+		a, err := NewBasicAuthenticator(config.HtpasswdFile)
+		if err != nil {
+			return nil, err
+		}
+		authenticators = append(authenticators, a)
+	}
+	return authenticators, nil
+}
+
+// AuthenticationMiddleware tries each authenticator in order and stores
+// the resulting identity in the gin context.
+// Requests without credentials continue as anonymous,
+// while requests with invalid or unsupported credentials
+// are rejected with 401.
+func AuthenticationMiddleware(authenticators []Authenticator) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		for _, authenticator := range authenticators {
+			identity, err := authenticator.Authenticate(c.Request)
+			if errors.Is(err, errNoCredentials) {
+				continue
+			}
+			if err != nil {
+				abortUnauthorized(c, err)
+				return
+			}
+			c.Set(identityKey, identity)
+			c.Next()
+			return
+		}
+		if c.GetHeader("Authorization") != "" {
+			abortUnauthorized(c, fmt.Errorf("Unsupported credentials"))
+			return
+		}
+		c.Next()
+	}
+}
+
+func abortUnauthorized(c *gin.Context, err error) {
+	code := http.StatusUnauthorized
+	c.Header("WWW-Authenticate", `Bearer realm="ataps"`)
+	c.Error(err)
+	c.XML(code, getErrorVOTable(err, code))
+	c.Abort()
+}
+
+// getIdentity returns the identity of the request,
+// or nil if the request is anonymous
+func getIdentity(c *gin.Context) *Identity {
+	value, ok := c.Get(identityKey)
+	if !ok {
+		return nil
+	}
+	identity, _ := value.(*Identity)
+	return identity
+}
+
+func bearerToken(r *http.Request) (string, bool) {
+	token, ok := strings.CutPrefix(r.Header.Get("Authorization"), "Bearer ")
+	if !ok || token == "" {
+		return "", false
+	}
+	return strings.TrimSpace(token), true
+}
+
+// looksLikeJWT reports whether a bearer token is a compact JWS,
+// so static tokens and JWT can be told apart
+func looksLikeJWT(token string) bool {
+	return strings.Count(token, ".") == 2
+}
@@ -0,0 +1,113 @@
+package tapsync
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/gin-gonic/gin"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"golang.org/x/crypto/bcrypt"
+)
+
+func writeTestFile(t *testing.T, name string, content string) string {
+	fname := filepath.Join(t.TempDir(), name)
+	err := os.WriteFile(fname, []byte(content), 0600)
+	require.NoError(t, err)
+	return fname
+}
+
+func tokenDigest(token string) string {
+	sum := sha256.Sum256([]byte(token))
+	return hex.EncodeToString(sum[:])
+}
+
+func TestStaticTokenAuthenticator(t *testing.T) {
+	fname := writeTestFile(t, "tokens.json", `{"`+tokenDigest("secret")+`": {"name": "alice", "groups": ["collaboration"]}}`)
+	authenticator, err := NewStaticTokenAuthenticator(fname)
+	require.NoError(t, err)
+
+	req, _ := http.NewRequest("POST", "/sync", nil)
+	req.Header.Add("Authorization", "Bearer secret")
+	identity, err := authenticator.Authenticate(req)
+	require.NoError(t, err)
+	assert.Equal(t, "token:alice", identity.Key())
+	assert.Equal(t, []string{"collaboration"}, identity.Groups)
+
+	req.Header.Set("Authorization", "Bearer wrong")
+	_, err = authenticator.Authenticate(req)
+	assert.EqualError(t, err, "Invalid token")
+
+	req.Header.Del("Authorization")
+	_, err = authenticator.Authenticate(req)
+	assert.ErrorIs(t, err, errNoCredentials)
+}
+
+func TestBasicAuthenticator(t *testing.T) {
+	hash, err := bcrypt.GenerateFromPassword([]byte("password"), bcrypt.MinCost)
+	require.NoError(t, err)
+	fname := writeTestFile(t, "htpasswd", "# users\nbob:"+string(hash)+"\n")
+	authenticator, err := NewBasicAuthenticator(fname)
+	require.NoError(t, err)
+
+	req, _ := http.NewRequest("POST", "/sync", nil)
+	req.SetBasicAuth("bob", "password")
+	identity, err := authenticator.Authenticate(req)
+	require.NoError(t, err)
+	assert.Equal(t, "basic:bob", identity.Key())
+
+	req.SetBasicAuth("bob", "wrong")
+	_, err = authenticator.Authenticate(req)
+	assert.EqualError(t, err, "Invalid user or password")
+
+	req.SetBasicAuth("unknown", "password")
+	_, err = authenticator.Authenticate(req)
+	assert.EqualError(t, err, "Invalid user or password")
+}
+
+func TestAuthenticationMiddleware(t *testing.T) {
+	fname := writeTestFile(t, "tokens.json", `{"`+tokenDigest("secret")+`": {"name": "alice"}}`)
+	authenticators, err := NewAuthenticators(AuthConfig{TokensFile: fname})
+	require.NoError(t, err)
+	gin.SetMode(gin.TestMode)
+	router := gin.New()
+	router.POST("/sync", AuthenticationMiddleware(authenticators), func(c *gin.Context) {
+		identity := getIdentity(c)
+		if identity == nil {
+			c.String(http.StatusOK, "anonymous")
+			return
+		}
+		c.String(http.StatusOK, identity.Name)
+	})
+	send := func(authorization string) *httptest.ResponseRecorder {
+		w := httptest.NewRecorder()
+		req, _ := http.NewRequest("POST", "/sync", nil)
+		if authorization != "" {
+			req.Header.Add("Authorization", authorization)
+		}
+		router.ServeHTTP(w, req)
+		return w
+	}
+
+	w := send("")
+	assert.Equal(t, http.StatusOK, w.Code)
+	assert.Equal(t, "anonymous", w.Body.String())
+
+	w = send("Bearer secret")
+	assert.Equal(t, http.StatusOK, w.Code)
+	assert.Equal(t, "alice", w.Body.String())
+
+	w = send("Bearer wrong")
+	assert.Equal(t, http.StatusUnauthorized, w.Code)
+	assert.Contains(t, w.Body.String(), "Invalid token")
+	assert.NotEmpty(t, w.Header().Get("WWW-Authenticate"))
+
+	w = send("Basic Ym9iOnBhc3N3b3Jk")
+	assert.Equal(t, http.StatusUnauthorized, w.Code)
+	assert.Contains(t, w.Body.String(), "Unsupported credentials")
+}
@@ -0,0 +1,425 @@
+package tapsync
+
+import (
+	"context"
+	"database/sql"
+	"database/sql/driver"
+	"errors"
+	"fmt"
+	"io"
+	"log/slog"
+	"net"
+	"slices"
+	"strings"
+	"sync/atomic"
+	"time"
+
+	"github.com/jackc/pgx/v5/pgconn"
+)
+
+// DefaultBackend is the name of the backend of Config.DatabaseURL,
+// which serves the schemas not mapped to other backends
+const DefaultBackend = "default"
+
+// replica is a read replica of a backend
+type replica struct {
+	name    string
+	db      *sql.DB
+	healthy atomic.Bool
+}
+
+// Backend is a PostgreSQL database with optional read replicas.
+// Queries are sent to the healthy replicas in turns,
+// and to the primary when no replica is available.
+type Backend struct {
+	Name     string
+	Primary  *sql.DB
+	replicas []*replica
+	next     atomic.Uint64
+	// handleQuery runs the queries, HandleSQLQueryContext by default
+	handleQuery func(ctx context.Context, query string, db *sql.DB, opts ...QueryOption) ([]map[string]interface{}, error)
+}
+
+// NewBackend creates a backend from its databases,
+// considering every replica healthy
+func NewBackend(name string, primary *sql.DB, replicas ...*sql.DB) *Backend {
+	backend := &Backend{Name: name, Primary: primary, handleQuery: HandleSQLQueryContext}
+	for i, db := range replicas {
+		r := &replica{name: fmt.Sprintf("%s_replica_%d", name, i), db: db}
+		r.healthy.Store(true)
+		backend.replicas = append(backend.replicas, r)
+	}
+	return backend
+}
+
+// candidates returns the databases a query can be sent to,
+// in the order they should be tried: the healthy replicas,
+// starting by the next one in turn, and then the primary
+func (b *Backend) candidates() []*replica {
+	candidates := make([]*replica, 0, len(b.replicas)+1)
+	if len(b.replicas) > 0 {
+		start := int(b.next.Add(1)-1) % len(b.replicas)
+		for i := range b.replicas {
+			r := b.replicas[(start+i)%len(b.replicas)]
+			if r.healthy.Load() {
+				candidates = append(candidates, r)
+			}
+		}
+	}
+	return append(candidates, &replica{name: b.Name, db: b.Primary})
+}
+
+// Reader returns the database the next query would be sent to
+func (b *Backend) Reader() *sql.DB {
+	return b.candidates()[0].db
+}
+
+// Query executes the query like HandleSQLQueryContext.
+// When a replica can not be reached the query is retried on the
+// next one, or on the primary, and the replica is marked unhealthy
+// until a health check finds it reachable again.
+func (b *Backend) Query(ctx context.Context, query string, opts ...QueryOption) ([]map[string]interface{}, error) {
+	var err error
+	for _, candidate := range b.candidates() {
+		var result []map[string]interface{}
+		result, err = b.handleQuery(ctx, query, candidate.db, opts...)
+		if !b.failover(ctx, candidate, err) {
+			return result, err
+		}
+	}
+	return nil, err
+}
+
+// CopyCSV writes the result of the query to w like CopyCSV.
+// Queries fail over like in Query, as long as nothing was written.
+func (b *Backend) CopyCSV(ctx context.Context, query string, w io.Writer) (int64, error) {
+	var err error
+	counter := &countingWriter{w: w}
+	for _, candidate := range b.candidates() {
+		var rows int64
+		rows, err = CopyCSV(ctx, query, candidate.db, counter)
+		if counter.n > 0 || !b.failover(ctx, candidate, err) {
+			return rows, err
+		}
+	}
+	return 0, err
+}
+
+// failover reports whether the query that failed with err on the
+// candidate must be retried on the next one, marking it unhealthy
+func (b *Backend) failover(ctx context.Context, candidate *replica, err error) bool {
+	if err == nil || candidate.db == b.Primary || !isConnectionError(ctx, err) {
+		return false
+	}
+	if candidate.healthy.CompareAndSwap(true, false) {
+		slog.WarnContext(ctx, "Replica unreachable, failing over", "backend", b.Name, "replica", candidate.name, "error", err)
+	}
+	return true
+}
+
+// countingWriter counts the bytes written to w
+type countingWriter struct {
+	w io.Writer
+	n int64
+}
+
+func (cw *countingWriter) Write(p []byte) (int, error) {
+	n, err := cw.w.Write(p)
+	cw.n += int64(n)
+	return n, err
+}
+
+// CheckHealth pings the replicas, marking them healthy or unhealthy
+func (b *Backend) CheckHealth(ctx context.Context) {
+	for _, r := range b.replicas {
+		pingCtx, cancel := context.WithTimeout(ctx, readinessTimeout)
+		err := r.db.PingContext(pingCtx)
+		cancel()
+		healthy := err == nil
+		if r.healthy.Swap(healthy) != healthy {
+			if healthy {
+				slog.InfoContext(ctx, "Replica is healthy again", "backend", b.Name, "replica", r.name)
+			} else {
+				slog.WarnContext(ctx, "Replica unreachable", "backend", b.Name, "replica", r.name, "error", err)
+			}
+		}
+	}
+}
+
+// Close closes the connections to the primary and the replicas
+func (b *Backend) Close() error {
+	errs := []error{b.Primary.Close()}
+	for _, r := range b.replicas {
+		errs = append(errs, r.db.Close())
+	}
+	return errors.Join(errs...)
+}
+
+// isConnectionError reports whether the query failed because
+// the database could not be reached, rather than because of the
+// query itself, so it can be retried on another database.
+// Only errors connecting, of the network or of a connection lost
+// are, and the errors of PostgreSQL of the classes of connection
+// exceptions and of servers shutting down.
+func isConnectionError(ctx context.Context, err error) bool {
+	if ctx.Err() != nil {
+		return false
+	}
+	var pgErr *pgconn.PgError
+	if errors.As(err, &pgErr) {
+		return strings.HasPrefix(pgErr.Code, "08") || strings.HasPrefix(pgErr.Code, "57P")
+	}
+	var connectErr *pgconn.ConnectError
+	var netErr net.Error
+	return pgconn.SafeToRetry(err) ||
+		errors.As(err, &connectErr) ||
+		errors.As(err, &netErr) ||
+		errors.Is(err, io.EOF) ||
+		errors.Is(err, io.ErrUnexpectedEOF) ||
+		errors.Is(err, driver.ErrBadConn)
+}
+
+// Backends are the databases that serve the tables of the service.
+// Each schema is served by one backend, the default backend
+// serving every schema not mapped to another one.
+type Backends struct {
+	backends []*Backend
+	schemas  map[string]*Backend
+}
+
+// NewBackends connects to the databases of the configuration.
+// Primaries must be reachable, while unreachable replicas
+// are marked unhealthy until a health check finds them.
+func NewBackends(config *Config) (*Backends, error) {
+	backendConfigs := append([]BackendConfig{{
+		Name:     DefaultBackend,
+		URL:      config.DatabaseURL,
+		Replicas: config.DatabaseReplicas,
+	}}, config.Backends...)
+	backends := &Backends{schemas: map[string]*Backend{}}
+	for _, backendConfig := range backendConfigs {
+		backend, err := openBackend(backendConfig, config.Pool)
+		if err != nil {
+			backends.Close()
+			return nil, fmt.Errorf("Error connecting to backend %s: %w", backendConfig.Name, err)
+		}
+		if config.Driver == DriverPgx {
+			backend.handleQuery = HandlePgxQuery
+		}
+		backends.add(backend, backendConfig.Schemas...)
+	}
+	backends.CheckHealth(context.Background())
+	return backends, nil
+}
+
+func openBackend(config BackendConfig, pool PoolConfig) (*Backend, error) {
+	primary, err := GetDB(config.URL)
+	if err != nil {
+		return nil, err
+	}
+	pool.Apply(primary)
+	var replicas []*sql.DB
+	for _, replicaUrl := range config.Replicas {
+		db, err := OpenDB(replicaUrl)
+		if err != nil {
+			primary.Close()
+			for _, replica := range replicas {
+				replica.Close()
+			}
+			return nil, err
+		}
+		pool.Apply(db)
+		replicas = append(replicas, db)
+	}
+	return NewBackend(config.Name, primary, replicas...), nil
+}
+
+// add adds a backend serving the schemas.
+// The first backend added is the default one.
+func (b *Backends) add(backend *Backend, schemas ...string) {
+	if b.schemas == nil {
+		b.schemas = map[string]*Backend{}
+	}
+	b.backends = append(b.backends, backend)
+	for _, schema := range schemas {
+		b.schemas[strings.ToLower(schema)] = backend
+	}
+}
+
+// Default returns the backend of the schemas not mapped to other backends
+func (b *Backends) Default() *Backend {
+	return b.backends[0]
+}
+
+// All returns every backend, starting by the default one
+func (b *Backends) All() []*Backend {
+	return b.backends
+}
+
+// ForQuery returns the backend that serves the tables of the query.
+// Tables of schemas mapped to a backend must be qualified by their schema,
+// and a query can not read tables of several backends.
+func (b *Backends) ForQuery(query string) (*Backend, error) {
+	var found *Backend
+	var foundSchema string
+	for _, schema := range querySchemas(query) {
+		backend, ok := b.schemas[schema]
+		if !ok || backend == found {
+			continue
+		}
+		if found != nil {
+			return nil, fmt.Errorf("Query reads schemas %s and %s, which are in different databases", foundSchema, schema)
+		}
+		found, foundSchema = backend, schema
+	}
+	if found == nil {
+		return b.Default(), nil
+	}
+	return found, nil
+}
+
+// CheckHealth checks the replicas of every backend
+func (b *Backends) CheckHealth(ctx context.Context) {
+	for _, backend := range b.backends {
+		backend.CheckHealth(ctx)
+	}
+}
+
+// RunHealthChecks checks the replicas every interval until the context is done.
+// A zero interval disables the checks.
+func (b *Backends) RunHealthChecks(ctx context.Context, interval time.Duration) {
+	if interval <= 0 {
+		return
+	}
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+			b.CheckHealth(ctx)
+		}
+	}
+}
+
+// Close closes the connections of every backend
+func (b *Backends) Close() error {
+	var errs []error
+	for _, backend := range b.backends {
+		errs = append(errs, backend.Close())
+	}
+	return errors.Join(errs...)
+}
+
+// querySchemas returns the lowercased qualifiers of the qualified
+// names of a query, such as "alerce" in alerce.object or "Alerce".object.
+// String literals and comments are skipped.
+// The qualifiers include table aliases, which are harmless
+// as long as no alias is named like a mapped schema.
+func querySchemas(query string) []string {
+	var schemas []string
+	var previous string
+	afterDot := false
+	for i := 0; i < len(query); {
+		c := query[i]
+		switch {
+		case c == '-' && strings.HasPrefix(query[i:], "--"):
+			end := strings.IndexByte(query[i:], '\n')
+			if end < 0 {
+				return schemas
+			}
+			i += end
+		case c == '/' && strings.HasPrefix(query[i:], "/*"):
+			end := strings.Index(query[i+2:], "*/")
+			if end < 0 {
+				return schemas
+			}
+			i += end + 4
+		case c == '\'':
+			i = skipQuoted(query, i, '\'')
+			previous, afterDot = "", false
+		case c == '$':
+			i = skipDollarQuoted(query, i)
+			previous, afterDot = "", false
+		case c == '"':
+			end := skipQuoted(query, i, '"')
+			name := strings.ReplaceAll(query[i+1:max(end-1, i+1)], `""`, `"`)
+			if afterDot && previous != "" {
+				schemas = append(schemas, previous)
+			}
+			previous, afterDot = strings.ToLower(name), false
+			i = end
+		case isIdentifierStart(c):
+			end := i + 1
+			for end < len(query) && isIdentifierPart(query[end]) {
+				end++
+			}
+			if afterDot && previous != "" {
+				schemas = append(schemas, previous)
+			}
+			previous, afterDot = strings.ToLower(query[i:end]), false
+			i = end
+		case c == '.':
+			afterDot = previous != "" && !afterDot
+			if !afterDot {
+				previous = ""
+			}
+			i++
+		case c == ' ' || c == '\t' || c == '\n' || c == '\r':
+			i++
+		default:
+			previous, afterDot = "", false
+			i++
+		}
+	}
+	slices.Sort(schemas)
+	return slices.Compact(schemas)
+}
+
+// skipQuoted returns the position after the quoted text starting at i,
+// where the quote is escaped by doubling it
+func skipQuoted(query string, i int, quote byte) int {
+	for i++; i < len(query); i++ {
+		if query[i] == quote {
+			if i+1 < len(query) && query[i+1] == quote {
+				i++
+				continue
+			}
+			return i + 1
+		}
+	}
+	return len(query)
+}
+
+// skipDollarQuoted returns the position after the dollar quoted text
+// starting at i, such as $$text$$ or $tag$text$tag$.
+// Positional parameters such as $1 are skipped as a single character.
+func skipDollarQuoted(query string, i int) int {
+	end := strings.IndexByte(query[i+1:], '$')
+	if end < 0 {
+		return i + 1
+	}
+	tag := query[i : i+end+2]
+	for _, c := range []byte(tag[1 : len(tag)-1]) {
+		if !isIdentifierPart(c) || c == '$' {
+			return i + 1
+		}
+	}
+	if len(tag) > 2 && !isIdentifierStart(tag[1]) {
+		return i + 1
+	}
+	closing := strings.Index(query[i+len(tag):], tag)
+	if closing < 0 {
+		return len(query)
+	}
+	return i + len(tag) + closing + len(tag)
+}
+
+func isIdentifierStart(c byte) bool {
+	return c == '_' || (c >= 'a' && c <= 'z') || (c >= 'A' && c <= 'Z') || c >= 0x80
+}
+
+func isIdentifierPart(c byte) bool {
+	return isIdentifierStart(c) || (c >= '0' && c <= '9') || c == '$'
+}
@@ -0,0 +1,151 @@
+package tapsync
+
+import (
+	"context"
+	"database/sql"
+	"database/sql/driver"
+	"fmt"
+	"io"
+	"net"
+	"strconv"
+	"syscall"
+	"testing"
+
+	"github.com/jackc/pgx/v5/pgconn"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// newUnreachableDB opens a database that refuses every connection
+func newUnreachableDB(t *testing.T) *sql.DB {
+	db, err := OpenDB("host=127.0.0.1 port=1 connect_timeout=1")
+	require.NoError(t, err)
+	t.Cleanup(func() { db.Close() })
+	return db
+}
+
+func TestQuerySchemas(t *testing.T) {
+	testCases := []struct {
+		query    string
+		expected []string
+	}{
+		{"SELECT * FROM object", nil},
+		{"SELECT * FROM alerce.object", []string{"alerce"}},
+		{"SELECT * FROM Alerce . Object", []string{"alerce"}},
+		{`SELECT * FROM "Archive"."detection"`, []string{"archive"}},
+		{`SELECT * FROM "weird""schema".t`, []string{`weird"schema`}},
+		{"SELECT o.oid FROM alerce.object o JOIN archive.detection d ON o.oid = d.oid", []string{"alerce", "archive", "d", "o"}},
+		{"SELECT * FROM db.schema.table", []string{"db", "schema"}},
+		{"SELECT 'archive.detection' FROM object", nil},
+		{"SELECT 'it''s archive.detection' FROM object", nil},
+		{"SELECT $$archive.detection$$, $tag$ archive.x $tag$ FROM object", nil},
+		{"SELECT * FROM object -- archive.detection\nWHERE ndet > 1.5", nil},
+		{"SELECT * FROM /* archive.detection */ object", nil},
+		{"SELECT * FROM object WHERE oid = $1 AND x.y = 1", []string{"x"}},
+		{"SELECT * FROM alerce.object WHERE mean_ra > 1.5 AND meanra.x = 2", []string{"alerce", "meanra"}},
+	}
+	for _, tc := range testCases {
+		t.Run(tc.query, func(t *testing.T) {
+			assert.Equal(t, tc.expected, querySchemas(tc.query))
+		})
+	}
+}
+
+func TestBackendsForQuery(t *testing.T) {
+	backends := &Backends{}
+	defaultBackend := NewBackend(DefaultBackend, nil)
+	archive := NewBackend("archive", nil)
+	backends.add(defaultBackend)
+	backends.add(archive, "archive", "Historical")
+	testCases := []struct {
+		query    string
+		expected *Backend
+	}{
+		{"SELECT * FROM object", defaultBackend},
+		{"SELECT * FROM alerce.object", defaultBackend},
+		{"SELECT * FROM archive.detection", archive},
+		{"SELECT * FROM archive.detection d JOIN historical.object o ON d.oid = o.oid", archive},
+		{`SELECT * FROM "HISTORICAL".object`, archive},
+		{"SELECT 'archive.detection' FROM object", defaultBackend},
+	}
+	for _, tc := range testCases {
+		backend, err := backends.ForQuery(tc.query)
+		require.NoError(t, err, tc.query)
+		assert.Same(t, tc.expected, backend, tc.query)
+	}
+	other := NewBackend("other", nil)
+	backends.add(other, "other")
+	_, err := backends.ForQuery("SELECT * FROM archive.detection JOIN other.object USING (oid)")
+	assert.ErrorContains(t, err, "Query reads schemas archive and other, which are in different databases")
+	assert.Equal(t, []*Backend{defaultBackend, archive, other}, backends.All())
+}
+
+func TestBackendCandidates(t *testing.T) {
+	primary := newUnreachableDB(t)
+	replicas := []*sql.DB{newUnreachableDB(t), newUnreachableDB(t), newUnreachableDB(t)}
+	backend := NewBackend("test", primary, replicas...)
+	// replicas are used in turns, and the primary is the last resort
+	for i := 0; i < 4; i++ {
+		candidates := backend.candidates()
+		require.Len(t, candidates, 4)
+		assert.Same(t, replicas[i%3], candidates[0].db)
+		assert.Same(t, replicas[(i+1)%3], candidates[1].db)
+		assert.Same(t, primary, candidates[3].db)
+	}
+	backend.replicas[1].healthy.Store(false)
+	candidates := backend.candidates()
+	require.Len(t, candidates, 3)
+	for _, candidate := range candidates {
+		assert.NotSame(t, replicas[1], candidate.db)
+	}
+	for _, r := range backend.replicas {
+		r.healthy.Store(false)
+	}
+	assert.Same(t, primary, backend.Reader())
+	assert.Same(t, primary, NewBackend("test", primary).Reader())
+}
+
+func TestBackendFailover(t *testing.T) {
+	backend := NewBackend("test", newUnreachableDB(t), newUnreachableDB(t), newUnreachableDB(t))
+	_, err := backend.Query(context.Background(), "SELECT 1")
+	// the error is the one of the primary, after trying both replicas
+	assert.Error(t, err)
+	for _, r := range backend.replicas {
+		assert.False(t, r.healthy.Load(), r.name)
+	}
+	assert.Equal(t, "test_replica_0", backend.replicas[0].name)
+}
+
+func TestBackendCheckHealth(t *testing.T) {
+	backend := NewBackend("test", newUnreachableDB(t), newUnreachableDB(t))
+	require.True(t, backend.replicas[0].healthy.Load())
+	backend.CheckHealth(context.Background())
+	assert.False(t, backend.replicas[0].healthy.Load())
+}
+
+func TestIsConnectionError(t *testing.T) {
+	ctx := context.Background()
+	cancelled, cancel := context.WithCancel(ctx)
+	cancel()
+	assert.True(t, isConnectionError(ctx, &net.OpError{Op: "dial", Net: "tcp", Err: syscall.ECONNREFUSED}))
+	assert.True(t, isConnectionError(ctx, fmt.Errorf("reading: %w", io.ErrUnexpectedEOF)))
+	assert.True(t, isConnectionError(ctx, driver.ErrBadConn))
+	assert.True(t, isConnectionError(ctx, &pgconn.PgError{Code: "57P01"}))
+	assert.True(t, isConnectionError(ctx, &pgconn.PgError{Code: "08006"}))
+	assert.False(t, isConnectionError(ctx, &pgconn.PgError{Code: "42P01"}))
+	assert.False(t, isConnectionError(cancelled, context.Canceled))
+	// errors of the query other than those of PostgreSQL
+	assert.False(t, isConnectionError(ctx, fmt.Errorf("can't scan into dest[0]: %w", strconv.ErrRange)))
+	assert.False(t, isConnectionError(ctx, fmt.Errorf("Database driver is not pgx")))
+}
+
+func TestBackendNoFailoverOnQueryError(t *testing.T) {
+	backend := NewBackend("test", newUnreachableDB(t), newUnreachableDB(t))
+	backend.handleQuery = func(ctx context.Context, query string, db *sql.DB, opts ...QueryOption) ([]map[string]interface{}, error) {
+		return nil, fmt.Errorf("can't scan into dest[0]: %w", strconv.ErrRange)
+	}
+	_, err := backend.Query(context.Background(), "SELECT 1")
+	assert.ErrorIs(t, err, strconv.ErrRange)
+	// the replica is not marked unhealthy
+	assert.True(t, backend.replicas[0].healthy.Load())
+}
@@ -0,0 +1,523 @@
+package tapsync
+
+import (
+	"bytes"
+	"container/list"
+	"crypto/sha256"
+	"encoding/gob"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"io/fs"
+	"log/slog"
+	"math"
+	"net/http"
+	"os"
+	"path/filepath"
+	"slices"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/gin-gonic/gin"
+)
+
+// uploadSchema is the schema of the tables uploaded with a query,
+// whose results can not be cached because the tables change
+const uploadSchema = "tap_upload"
+
+// CacheConfig holds the settings of the query result cache.
+// The cache is disabled when TTL is zero.
+type CacheConfig struct {
+	// TTL is how long a result is served from the cache
+	TTL time.Duration `yaml:"ttl"`
+	// MaxBytes is the total size of the cached responses
+	MaxBytes int64 `yaml:"max_bytes"`
+	// MaxEntryBytes is the size of the largest response that is cached
+	MaxEntryBytes int64 `yaml:"max_entry_bytes"`
+	// Dir stores the responses in files of this directory,
+	// instead of in memory
+	Dir string `yaml:"dir"`
+}
+
+// Enabled reports whether the cache is configured
+func (c CacheConfig) Enabled() bool {
+	return c.TTL > 0
+}
+
+// CachedResponse is a successful response kept in the cache
+type CachedResponse struct {
+	Header  http.Header
+	Body    []byte
+	Rows    int
+	ETag    string
+	Expires time.Time
+}
+
+// CacheStore keeps the cached responses by key.
+// Implementations evict responses to stay within their size limit,
+// and must be safe for concurrent use.
+type CacheStore interface {
+	Get(key string) (*CachedResponse, bool)
+	Set(key string, response *CachedResponse)
+}
+
+// ResultCache serves the responses of repeated queries
+// from a CacheStore instead of running them again
+type ResultCache struct {
+	store         CacheStore
+	ttl           time.Duration
+	maxEntryBytes int64
+	now           func() time.Time
+}
+
+// NewResultCache creates the cache of the configuration,
+// stored on disk when a directory is configured,
+// and in memory otherwise
+func NewResultCache(config CacheConfig) (*ResultCache, error) {
+	var store CacheStore = NewMemoryCache(config.MaxBytes)
+	if config.Dir != "" {
+		diskCache, err := NewDiskCache(config.Dir, config.MaxBytes)
+		if err != nil {
+			return nil, err
+		}
+		store = diskCache
+	}
+	maxEntryBytes := config.MaxEntryBytes
+	if maxEntryBytes <= 0 || (config.MaxBytes > 0 && maxEntryBytes > config.MaxBytes) {
+		maxEntryBytes = config.MaxBytes
+	}
+	return &ResultCache{
+		store:         store,
+		ttl:           config.TTL,
+		maxEntryBytes: maxEntryBytes,
+		now:           time.Now,
+	}, nil
+}
+
+// Serve writes the cached response of the key, if any, and reports
+// whether it did. GET and HEAD requests with an If-None-Match header
+// matching the ETag of the response get a 304 Not Modified without
+// a body, and other requests, as the POSTs of /sync, a 412
+// Precondition Failed, as RFC 9110 asks.
+func (rc *ResultCache) Serve(c *gin.Context, key string) bool {
+	response, ok := rc.store.Get(key)
+	if !ok {
+		return false
+	}
+	now := rc.now()
+	if !now.Before(response.Expires) {
+		return false
+	}
+	matches := etagMatches(c.GetHeader("If-None-Match"), response.ETag)
+	if matches && c.Request.Method != http.MethodGet && c.Request.Method != http.MethodHead {
+		code := http.StatusPreconditionFailed
+		c.XML(code, getErrorVOTable(fmt.Errorf("The result matches If-None-Match"), code))
+		return true
+	}
+	for name, values := range response.Header {
+		c.Writer.Header()[name] = slices.Clone(values)
+	}
+	rc.setCacheHeaders(c, response.ETag, response.Expires.Sub(now))
+	c.Set(rowCountKey, response.Rows)
+	if matches {
+		c.Writer.Header().Del("Content-Length")
+		c.Status(http.StatusNotModified)
+		return true
+	}
+	c.Data(http.StatusOK, response.Header.Get("Content-Type"), response.Body)
+	return true
+}
+
+// Record captures the response written by the rest of the handler,
+// and returns the function that stores it under the key once written.
+// Only complete successful responses are stored, and they are sent
+// with the same ETag and Cache-Control headers a cache hit would have.
+func (rc *ResultCache) Record(c *gin.Context, key string) func() {
+	expires := rc.now().Add(rc.ttl)
+	etag := newETag(key, expires)
+	w := &cacheWriter{
+		ResponseWriter: c.Writer,
+		maxBytes:       rc.maxEntryBytes,
+		onHeader: func() {
+			rc.setCacheHeaders(c, etag, rc.ttl)
+		},
+	}
+	c.Writer = w
+	return func() {
+		c.Writer = w.ResponseWriter
+		if w.discarded || w.Status() != http.StatusOK || len(c.Errors) > 0 {
+			return
+		}
+		header := w.Header().Clone()
+		header.Del("Cache-Control")
+		header.Del("ETag")
+		rc.store.Set(key, &CachedResponse{
+			Header:  header,
+			Body:    w.body.Bytes(),
+			Rows:    c.GetInt(rowCountKey),
+			ETag:    etag,
+			Expires: expires,
+		})
+	}
+}
+
+// setCacheHeaders tells clients they can reuse the response for
+// maxAge. Responses to authenticated requests are private, so
+// shared caches do not serve them to other clients.
+func (rc *ResultCache) setCacheHeaders(c *gin.Context, etag string, maxAge time.Duration) {
+	visibility := "public"
+	if getIdentity(c) != nil {
+		visibility = "private"
+	}
+	seconds := int64(math.Ceil(maxAge.Seconds()))
+	c.Header("Cache-Control", fmt.Sprintf("%s, max-age=%d", visibility, seconds))
+	c.Header("ETag", etag)
+}
+
+// cacheWriter keeps a copy of the body of successful responses,
+// up to maxBytes, while writing them to the client
+type cacheWriter struct {
+	gin.ResponseWriter
+	body      bytes.Buffer
+	maxBytes  int64
+	discarded bool
+	onHeader  func()
+}
+
+func (w *cacheWriter) WriteHeaderNow() {
+	w.beforeWrite()
+	w.ResponseWriter.WriteHeaderNow()
+}
+
+func (w *cacheWriter) Write(data []byte) (int, error) {
+	w.beforeWrite()
+	w.capture(data)
+	return w.ResponseWriter.Write(data)
+}
+
+func (w *cacheWriter) WriteString(s string) (int, error) {
+	w.beforeWrite()
+	w.capture([]byte(s))
+	return w.ResponseWriter.WriteString(s)
+}
+
+// beforeWrite adds the cache headers to successful responses
+// before the headers are sent, and discards any other response
+func (w *cacheWriter) beforeWrite() {
+	if w.Written() {
+		return
+	}
+	if w.Status() != http.StatusOK {
+		w.discarded = true
+		return
+	}
+	w.onHeader()
+}
+
+func (w *cacheWriter) capture(data []byte) {
+	if w.discarded {
+		return
+	}
+	if w.maxBytes > 0 && int64(w.body.Len()+len(data)) > w.maxBytes {
+		w.discarded = true
+		w.body = bytes.Buffer{}
+		return
+	}
+	w.body.Write(data)
+}
+
+// cacheKey identifies the response of a query, so queries that only
+// differ in whitespace, comments or the case of keywords share it
+func cacheKey(query string, lang string, maxRec int, format string) string {
+	hash := sha256.New()
+	for _, part := range []string{canonicalQuery(query), lang, strconv.Itoa(maxRec), format} {
+		hash.Write([]byte(part))
+		hash.Write([]byte{0})
+	}
+	return hex.EncodeToString(hash.Sum(nil))
+}
+
+// readsUploadTables reports whether the query uses uploaded tables
+func readsUploadTables(query string) bool {
+	return slices.Contains(querySchemas(query), uploadSchema)
+}
+
+// canonicalQuery collapses whitespace and comments into single spaces,
+// lowercases unquoted identifiers and keywords, which PostgreSQL
+// does not tell apart, and removes the trailing semicolons.
+// String literals and quoted identifiers are kept as they are.
+func canonicalQuery(query string) string {
+	var b strings.Builder
+	space := false
+	writeSpace := func() {
+		if space && b.Len() > 0 {
+			b.WriteByte(' ')
+		}
+		space = false
+	}
+	for i := 0; i < len(query); {
+		c := query[i]
+		switch {
+		case c == '-' && strings.HasPrefix(query[i:], "--"):
+			end := strings.IndexByte(query[i:], '\n')
+			if end < 0 {
+				end = len(query) - i
+			}
+			i += end
+			space = true
+		case c == '/' && strings.HasPrefix(query[i:], "/*"):
+			end := strings.Index(query[i+2:], "*/")
+			if end < 0 {
+				end = len(query) - i - 4
+			}
+			i += end + 4
+			space = true
+		case c == ' ' || c == '\t' || c == '\n' || c == '\r':
+			i++
+			space = true
+		case c == '\'' || c == '"':
+			writeSpace()
+			end := skipQuoted(query, i, c)
+			b.WriteString(query[i:end])
+			i = end
+		case c == '$':
+			writeSpace()
+			end := skipDollarQuoted(query, i)
+			b.WriteString(query[i:end])
+			i = end
+		case isIdentifierStart(c):
+			writeSpace()
+			end := i + 1
+			for end < len(query) && isIdentifierPart(query[end]) {
+				end++
+			}
+			b.WriteString(strings.ToLower(query[i:end]))
+			// E'...' strings escape quotes with backslashes
+			if end-i == 1 && (c == 'e' || c == 'E') && end < len(query) && query[end] == '\'' {
+				start := end
+				end = skipEscaped(query, end)
+				b.WriteString(query[start:end])
+			}
+			i = end
+		default:
+			writeSpace()
+			b.WriteByte(c)
+			i++
+		}
+	}
+	return strings.TrimRight(b.String(), "; ")
+}
+
+// skipEscaped returns the position after the string literal starting
+// at i, where quotes can also be escaped by a backslash
+func skipEscaped(query string, i int) int {
+	for i++; i < len(query); i++ {
+		switch {
+		case query[i] == '\\':
+			i++
+		case query[i] == '\'' && i+1 < len(query) && query[i+1] == '\'':
+			i++
+		case query[i] == '\'':
+			return i + 1
+		}
+	}
+	return len(query)
+}
+
+// newETag returns a strong ETag for the response of the key cached
+// until expires, which changes every time the query is run again
+func newETag(key string, expires time.Time) string {
+	return `"` + key[:16] + "-" + strconv.FormatInt(expires.UnixNano(), 36) + `"`
+}
+
+// etagMatches reports whether the If-None-Match header
+// lists the ETag, comparing them weakly as RFC 9110 requires
+func etagMatches(ifNoneMatch string, etag string) bool {
+	for _, candidate := range strings.Split(ifNoneMatch, ",") {
+		candidate = strings.TrimPrefix(strings.TrimSpace(candidate), "W/")
+		if candidate == "*" || candidate == etag {
+			return true
+		}
+	}
+	return false
+}
+
+// MemoryCache is a CacheStore that keeps the responses in memory,
+// evicting the least recently used ones beyond maxBytes
+type MemoryCache struct {
+	mu       sync.Mutex
+	maxBytes int64
+	size     int64
+	order    *list.List
+	items    map[string]*list.Element
+}
+
+type memoryCacheItem struct {
+	key      string
+	response *CachedResponse
+}
+
+// NewMemoryCache creates an in-memory store of up to maxBytes
+// of response bodies. A zero maxBytes means no limit.
+func NewMemoryCache(maxBytes int64) *MemoryCache {
+	return &MemoryCache{
+		maxBytes: maxBytes,
+		order:    list.New(),
+		items:    make(map[string]*list.Element),
+	}
+}
+
+func (m *MemoryCache) Get(key string) (*CachedResponse, bool) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	element, ok := m.items[key]
+	if !ok {
+		return nil, false
+	}
+	item := element.Value.(*memoryCacheItem)
+	if !time.Now().Before(item.response.Expires) {
+		m.remove(element)
+		return nil, false
+	}
+	m.order.MoveToFront(element)
+	return item.response, true
+}
+
+func (m *MemoryCache) Set(key string, response *CachedResponse) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	if element, ok := m.items[key]; ok {
+		m.remove(element)
+	}
+	m.items[key] = m.order.PushFront(&memoryCacheItem{key: key, response: response})
+	m.size += int64(len(response.Body))
+	for m.maxBytes > 0 && m.size > m.maxBytes {
+		m.remove(m.order.Back())
+	}
+}
+
+func (m *MemoryCache) remove(element *list.Element) {
+	item := m.order.Remove(element).(*memoryCacheItem)
+	delete(m.items, item.key)
+	m.size -= int64(len(item.response.Body))
+}
+
+// DiskCache is a CacheStore that keeps each response in a file of
+// a directory, evicting the least recently used ones beyond maxBytes.
+// The directory can be shared by several instances of the service.
+type DiskCache struct {
+	mu       sync.Mutex
+	dir      string
+	maxBytes int64
+}
+
+// NewDiskCache creates a store in the directory, creating it if needed.
+// A zero maxBytes means no limit.
+func NewDiskCache(dir string, maxBytes int64) (*DiskCache, error) {
+	if err := os.MkdirAll(dir, 0o700); err != nil {
+		return nil, err
+	}
+	return &DiskCache{dir: dir, maxBytes: maxBytes}, nil
+}
+
+func (d *DiskCache) path(key string) string {
+	return filepath.Join(d.dir, key+".cache")
+}
+
+func (d *DiskCache) Get(key string) (*CachedResponse, bool) {
+	path := d.path(key)
+	file, err := os.Open(path)
+	if err != nil {
+		return nil, false
+	}
+	defer file.Close()
+	var response CachedResponse
+	if err := gob.NewDecoder(file).Decode(&response); err != nil {
+		slog.Warn("Error reading cached response", "file", path, "error", err)
+		os.Remove(path)
+		return nil, false
+	}
+	now := time.Now()
+	if !now.Before(response.Expires) {
+		os.Remove(path)
+		return nil, false
+	}
+	// the modification time tells which responses were used last
+	os.Chtimes(path, now, now)
+	return &response, true
+}
+
+func (d *DiskCache) Set(key string, response *CachedResponse) {
+	if err := d.write(key, response); err != nil {
+		slog.Warn("Error writing cached response", "key", key, "error", err)
+		return
+	}
+	if err := d.evict(); err != nil {
+		slog.Warn("Error evicting cached responses", "dir", d.dir, "error", err)
+	}
+}
+
+// write writes the response to a temporary file that is then
+// renamed, so readers never see a partially written response
+func (d *DiskCache) write(key string, response *CachedResponse) error {
+	file, err := os.CreateTemp(d.dir, "tmp-*")
+	if err != nil {
+		return err
+	}
+	defer os.Remove(file.Name())
+	err = gob.NewEncoder(file).Encode(response)
+	if closeErr := file.Close(); err == nil {
+		err = closeErr
+	}
+	if err != nil {
+		return err
+	}
+	return os.Rename(file.Name(), d.path(key))
+}
+
+// evict removes the least recently used responses
+// while the directory is larger than maxBytes.
+// Expired responses are removed when they are read.
+func (d *DiskCache) evict() error {
+	if d.maxBytes <= 0 {
+		return nil
+	}
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	entries, err := os.ReadDir(d.dir)
+	if err != nil {
+		return err
+	}
+	var files []fs.FileInfo
+	var size int64
+	for _, entry := range entries {
+		if !strings.HasSuffix(entry.Name(), ".cache") {
+			continue
+		}
+		info, err := entry.Info()
+		if errors.Is(err, fs.ErrNotExist) {
+			continue
+		}
+		if err != nil {
+			return err
+		}
+		files = append(files, info)
+		size += info.Size()
+	}
+	slices.SortFunc(files, func(a, b fs.FileInfo) int {
+		return a.ModTime().Compare(b.ModTime())
+	})
+	for _, info := range files {
+		if size <= d.maxBytes {
+			break
+		}
+		err := os.Remove(filepath.Join(d.dir, info.Name()))
+		if err != nil && !errors.Is(err, fs.ErrNotExist) {
+			return err
+		}
+		size -= info.Size()
+	}
+	return nil
+}
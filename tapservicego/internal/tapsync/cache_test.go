@@ -0,0 +1,264 @@
+package tapsync
+
+import (
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"os"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// newCachedRouter serves the body of the "q" query parameter through
+// the cache, counting the requests that reach the handler.
+// Bodies starting with "error" are answered with a 500.
+func newCachedRouter(cache *ResultCache, runs *int) *gin.Engine {
+	gin.SetMode(gin.TestMode)
+	router := gin.New()
+	router.Match([]string{http.MethodGet, http.MethodPost}, "/sync", func(c *gin.Context) {
+		body := c.Query("q")
+		key := cacheKey(body, "PSQL", -1, "csv")
+		if cache.Serve(c, key) {
+			return
+		}
+		defer cache.Record(c, key)()
+		*runs++
+		if strings.HasPrefix(body, "error") {
+			c.String(http.StatusInternalServerError, body)
+			return
+		}
+		c.Set(rowCountKey, 1)
+		c.Header("Content-Type", "text/csv")
+		c.String(http.StatusOK, body)
+	})
+	return router
+}
+
+func sendCachedRequest(router *gin.Engine, body string, ifNoneMatch string) *httptest.ResponseRecorder {
+	return sendCachedRequestMethod(router, "GET", body, ifNoneMatch)
+}
+
+func sendCachedRequestMethod(router *gin.Engine, method string, body string, ifNoneMatch string) *httptest.ResponseRecorder {
+	w := httptest.NewRecorder()
+	req, _ := http.NewRequest(method, "/sync?q="+body, nil)
+	if ifNoneMatch != "" {
+		req.Header.Set("If-None-Match", ifNoneMatch)
+	}
+	router.ServeHTTP(w, req)
+	return w
+}
+
+func TestResultCache(t *testing.T) {
+	for _, dir := range []string{"", t.TempDir()} {
+		t.Run(fmt.Sprintf("dir=%q", dir), func(t *testing.T) {
+			cache, err := NewResultCache(CacheConfig{TTL: time.Minute, MaxBytes: 1024, Dir: dir})
+			require.NoError(t, err)
+			runs := 0
+			router := newCachedRouter(cache, &runs)
+			first := sendCachedRequest(router, "a,b", "")
+			assert.Equal(t, http.StatusOK, first.Code)
+			assert.Equal(t, "public, max-age=60", first.Header().Get("Cache-Control"))
+			etag := first.Header().Get("ETag")
+			assert.NotEmpty(t, etag)
+			second := sendCachedRequest(router, "a,b", "")
+			assert.Equal(t, http.StatusOK, second.Code)
+			assert.Equal(t, "a,b", second.Body.String())
+			assert.Equal(t, "text/csv", second.Header().Get("Content-Type"))
+			assert.Equal(t, etag, second.Header().Get("ETag"))
+			assert.Equal(t, 1, runs)
+			notModified := sendCachedRequest(router, "a,b", `"other", W/`+etag)
+			assert.Equal(t, http.StatusNotModified, notModified.Code)
+			assert.Empty(t, notModified.Body.String())
+			assert.Equal(t, 1, runs)
+			// 304 only answers GET and HEAD
+			failed := sendCachedRequestMethod(router, "POST", "a,b", etag)
+			assert.Equal(t, http.StatusPreconditionFailed, failed.Code)
+			assert.Empty(t, failed.Header().Get("ETag"))
+			assert.Equal(t, 1, runs)
+			posted := sendCachedRequestMethod(router, "POST", "a,b", `"other"`)
+			assert.Equal(t, http.StatusOK, posted.Code)
+			assert.Equal(t, "a,b", posted.Body.String())
+			// errors are not cached
+			sendCachedRequest(router, "error", "")
+			w := sendCachedRequest(router, "error", "")
+			assert.Equal(t, http.StatusInternalServerError, w.Code)
+			assert.Empty(t, w.Header().Get("ETag"))
+			assert.Equal(t, 3, runs)
+		})
+	}
+}
+
+func TestResultCacheExpires(t *testing.T) {
+	cache, err := NewResultCache(CacheConfig{TTL: time.Minute})
+	require.NoError(t, err)
+	now := time.Now()
+	cache.now = func() time.Time { return now }
+	runs := 0
+	router := newCachedRouter(cache, &runs)
+	sendCachedRequest(router, "a", "")
+	now = now.Add(45 * time.Second)
+	w := sendCachedRequest(router, "a", "")
+	assert.Equal(t, "public, max-age=15", w.Header().Get("Cache-Control"))
+	assert.Equal(t, 1, runs)
+	now = now.Add(15 * time.Second)
+	sendCachedRequest(router, "a", "")
+	assert.Equal(t, 2, runs)
+}
+
+func TestResultCacheMaxEntryBytes(t *testing.T) {
+	cache, err := NewResultCache(CacheConfig{TTL: time.Minute, MaxEntryBytes: 4})
+	require.NoError(t, err)
+	runs := 0
+	router := newCachedRouter(cache, &runs)
+	sendCachedRequest(router, "large", "")
+	w := sendCachedRequest(router, "large", "")
+	assert.Equal(t, "large", w.Body.String())
+	assert.Equal(t, 2, runs)
+	sendCachedRequest(router, "tiny", "")
+	sendCachedRequest(router, "tiny", "")
+	assert.Equal(t, 3, runs)
+}
+
+func TestMemoryCacheEviction(t *testing.T) {
+	cache := NewMemoryCache(10)
+	expires := time.Now().Add(time.Minute)
+	cache.Set("a", &CachedResponse{Body: []byte("aaaa"), Expires: expires})
+	cache.Set("b", &CachedResponse{Body: []byte("bbbb"), Expires: expires})
+	// reading a makes b the least recently used
+	_, ok := cache.Get("a")
+	require.True(t, ok)
+	cache.Set("c", &CachedResponse{Body: []byte("cccc"), Expires: expires})
+	_, ok = cache.Get("b")
+	assert.False(t, ok)
+	_, ok = cache.Get("a")
+	assert.True(t, ok)
+	assert.Equal(t, int64(8), cache.size)
+	cache.Set("d", &CachedResponse{Body: []byte("d"), Expires: time.Now().Add(-time.Second)})
+	_, ok = cache.Get("d")
+	assert.False(t, ok)
+}
+
+func TestDiskCacheEviction(t *testing.T) {
+	dir := t.TempDir()
+	cache, err := NewDiskCache(dir, 700)
+	require.NoError(t, err)
+	expires := time.Now().Add(time.Minute)
+	body := []byte(strings.Repeat("x", 200))
+	cache.Set("a", &CachedResponse{Body: body, Expires: expires})
+	// modification times are used to find the least recently used file
+	past := time.Now().Add(-time.Hour)
+	require.NoError(t, os.Chtimes(cache.path("a"), past, past))
+	cache.Set("b", &CachedResponse{Body: body, Expires: expires})
+	cache.Set("c", &CachedResponse{Body: body, Expires: expires})
+	_, ok := cache.Get("a")
+	assert.False(t, ok)
+	response, ok := cache.Get("c")
+	require.True(t, ok)
+	assert.Equal(t, body, response.Body)
+	require.NoError(t, os.WriteFile(cache.path("broken"), []byte("not gob"), 0o600))
+	_, ok = cache.Get("broken")
+	assert.False(t, ok)
+	assert.NoFileExists(t, cache.path("broken"))
+}
+
+func TestCacheKey(t *testing.T) {
+	key := cacheKey("SELECT * FROM object WHERE oid = 'ZTF1'", "PSQL", 10, "csv")
+	same := []string{
+		"select *\n  from   object\twhere oid = 'ZTF1';",
+		"SELECT * FROM object -- latest\nWHERE oid = 'ZTF1' ;;",
+		"SELECT * /* all */ FROM Object WHERE OID = 'ZTF1'",
+	}
+	for _, query := range same {
+		assert.Equal(t, key, cacheKey(query, "PSQL", 10, "csv"), query)
+	}
+	different := []string{
+		"SELECT * FROM object WHERE oid = 'ztf1'",
+		"SELECT * FROM object WHERE oid = 'ZTF1 '",
+		`SELECT * FROM "Object" WHERE oid = 'ZTF1'`,
+	}
+	for _, query := range different {
+		assert.NotEqual(t, key, cacheKey(query, "PSQL", 10, "csv"), query)
+	}
+	assert.NotEqual(t, key, cacheKey("SELECT * FROM object WHERE oid = 'ZTF1'", "PSQL", 11, "csv"))
+	assert.NotEqual(t, key, cacheKey("SELECT * FROM object WHERE oid = 'ZTF1'", "PSQL", 10, "votable"))
+}
+
+func TestCanonicalQuery(t *testing.T) {
+	testCases := []struct {
+		query    string
+		expected string
+	}{
+		{"  SELECT\n\t1 ; ", "select 1"},
+		{"SELECT 'A  B', \"Col  A\" FROM T", "select 'A  B', \"Col  A\" from t"},
+		{"SELECT $$A  B$$, $x$ C $x$", "select $$A  B$$, $x$ C $x$"},
+		{`SELECT E'it\'s  A', e'B'`, `select e'it\'s  A', e'B'`},
+		{"SELECT 1 -- comment", "select 1"},
+		{"SELECT/* comment */1", "select 1"},
+	}
+	for _, tc := range testCases {
+		assert.Equal(t, tc.expected, canonicalQuery(tc.query), tc.query)
+	}
+}
+
+func TestReadsUploadTables(t *testing.T) {
+	assert.True(t, readsUploadTables("SELECT * FROM TAP_UPLOAD.mine JOIN object USING (oid)"))
+	assert.False(t, readsUploadTables("SELECT 'tap_upload.mine' FROM object"))
+}
+
+func TestEtagMatches(t *testing.T) {
+	assert.True(t, etagMatches(`"a"`, `"a"`))
+	assert.True(t, etagMatches(`"b", W/"a"`, `"a"`))
+	assert.True(t, etagMatches("*", `"a"`))
+	assert.False(t, etagMatches(`"b"`, `"a"`))
+	assert.False(t, etagMatches("", `"a"`))
+}
+
+func (suite *TapSyncTestSuite) TestSyncPostHandlerCache() {
+	service := NewTapSyncService(NewConfig(WithDatabaseURL(suite.ConnUrl), WithCache(CacheConfig{TTL: time.Minute})))
+	defer service.Close()
+	send := func(query string) *httptest.ResponseRecorder {
+		w := httptest.NewRecorder()
+		body := url.Values{"LANG": {"PSQL"}, "FORMAT": {"csv"}, "QUERY": {query}}.Encode()
+		req, _ := http.NewRequest("POST", "/sync", strings.NewReader(body))
+		req.Header.Add("Content-Type", "application/x-www-form-urlencoded")
+		service.Router.ServeHTTP(w, req)
+		return w
+	}
+	first := send("SELECT random() AS r")
+	suite.Equal(http.StatusOK, first.Code)
+	suite.NotEmpty(first.Header().Get("ETag"))
+	// a random value repeated tells the query did not run again
+	second := send("select random()  AS r;")
+	suite.Equal(first.Body.String(), second.Body.String())
+	suite.Equal(first.Header().Get("ETag"), second.Header().Get("ETag"))
+	failed := send("SELECT * FROM tap_upload.mine")
+	suite.Equal(http.StatusInternalServerError, failed.Code)
+	suite.Empty(failed.Header().Get("ETag"))
+}
+
+func (suite *TapSyncTestSuite) TestSyncPostHandlerCacheHTML() {
+	service := NewTapSyncService(NewConfig(WithDatabaseURL(suite.ConnUrl), WithCache(CacheConfig{TTL: time.Minute})))
+	defer service.Close()
+	send := func(query string, downloads string) *httptest.ResponseRecorder {
+		w := httptest.NewRecorder()
+		body := url.Values{"LANG": {"PSQL"}, "FORMAT": {"html"}, "QUERY": {query}, "DOWNLOADS": {downloads}}.Encode()
+		req, _ := http.NewRequest("POST", "/sync", strings.NewReader(body))
+		req.Header.Add("Content-Type", "application/x-www-form-urlencoded")
+		service.Router.ServeHTTP(w, req)
+		return w
+	}
+	preview := send("SELECT 1 AS n", "false")
+	suite.Equal(http.StatusOK, preview.Code)
+	suite.NotContains(preview.Body.String(), "<form")
+	report := send("select 1 AS n -- mine", "")
+	suite.Equal(http.StatusOK, report.Code)
+	suite.Contains(report.Body.String(), "<form")
+	suite.Contains(report.Body.String(), "-- mine")
+	suite.Empty(report.Header().Get("ETag"))
+}
@@ -1,36 +1,651 @@
 package tapsync
 
-import "os"
+import (
+	"bytes"
+	"database/sql"
+	"errors"
+	"fmt"
+	"io"
+	"log/slog"
+	"maps"
+	"os"
+	"path/filepath"
+	"slices"
+	"strconv"
+	"strings"
+	"time"
 
-// Config is the configuration for the application
+	"github.com/pelletier/go-toml/v2"
+	"gopkg.in/yaml.v3"
+)
+
+// SupportedFormats are the response formats the service can write
+var SupportedFormats = []string{"votable", "csv", "tsv", "fits", "text", "html", "votable-mivot", "ecsv", "ipac"}
+
+// drivers used to run the queries, see Config.Driver
+const (
+	DriverSQL = "sql"
+	DriverPgx = "pgx"
+)
+
+// SupportedLanguages are the query languages the service can run
+var SupportedLanguages = []string{"PSQL"}
+
+// Config is the configuration for the application.
+// It is built in layers: defaults, then an optional YAML or TOML
+// file (see LoadConfig), then environment variables, then options.
 type Config struct {
-	DatabaseURL string
-	Port        int
+	DatabaseURL string          `yaml:"database_url"`
+	Port        int             `yaml:"port"`
+	TLS         TLSConfig       `yaml:"tls"`
+	Pool        PoolConfig      `yaml:"pool"`
+	Timeouts    TimeoutsConfig  `yaml:"timeouts"`
+	Limits      LimitsConfig    `yaml:"limits"`
+	RateLimit   RateLimitConfig `yaml:"rate_limit"`
+	Auth        AuthConfig      `yaml:"auth"`
+	Cache       CacheConfig     `yaml:"cache"`
+	LogLevel    string          `yaml:"log_level"`
+	// DatabaseReplicas are read replicas of DatabaseURL
+	DatabaseReplicas []string `yaml:"database_replicas,omitempty"`
+	// Backends are other databases serving some of the schemas
+	Backends []BackendConfig `yaml:"backends,omitempty"`
+	// HealthCheckInterval is how often unreachable replicas are checked
+	HealthCheckInterval time.Duration `yaml:"health_check_interval"`
+	// Driver runs the queries through database/sql with "sql",
+	// or reads the rows with pgx directly with "pgx"
+	Driver string `yaml:"driver"`
+	// CopyCSV streams CSV results with COPY TO STDOUT
+	// when the request has no MAXREC limit
+	CopyCSV bool `yaml:"copy_csv"`
+	// Formats are the response formats enabled, a subset of SupportedFormats
+	Formats []string `yaml:"formats"`
+	// Languages are the query languages enabled, a subset of SupportedLanguages
+	Languages []string `yaml:"languages"`
+	// DrainTimeout is how long in-flight requests
+	// are given to finish when shutting down
+	DrainTimeout time.Duration `yaml:"drain_timeout"`
+	// Console serves the query console at /console/, disabled by default
+	Console bool `yaml:"console"`
+	// envErrors are the errors of the invalid environment
+	// variables, reported by Validate
+	envErrors []error
+}
+
+// BackendConfig is a database with optional read replicas
+// that serves the tables of some schemas
+type BackendConfig struct {
+	Name     string   `yaml:"name"`
+	URL      string   `yaml:"url"`
+	Replicas []string `yaml:"replicas"`
+	Schemas  []string `yaml:"schemas"`
+}
+
+// TLSConfig holds the certificate used to serve HTTPS.
+// When both files are empty the service serves plain HTTP.
+type TLSConfig struct {
+	CertFile string `yaml:"cert_file"`
+	KeyFile  string `yaml:"key_file"`
+}
+
+// Enabled reports whether HTTPS is configured
+func (t TLSConfig) Enabled() bool {
+	return t.CertFile != "" || t.KeyFile != ""
+}
+
+// PoolConfig holds the settings of the database connection pool.
+// A zero value keeps the database/sql default.
+type PoolConfig struct {
+	MaxOpenConns    int           `yaml:"max_open_conns"`
+	MaxIdleConns    int           `yaml:"max_idle_conns"`
+	ConnMaxLifetime time.Duration `yaml:"conn_max_lifetime"`
+	ConnMaxIdleTime time.Duration `yaml:"conn_max_idle_time"`
+}
+
+// Apply sets the pool settings on the database
+func (p PoolConfig) Apply(db *sql.DB) {
+	if p.MaxOpenConns > 0 {
+		db.SetMaxOpenConns(p.MaxOpenConns)
+	}
+	if p.MaxIdleConns > 0 {
+		db.SetMaxIdleConns(p.MaxIdleConns)
+	}
+	if p.ConnMaxLifetime > 0 {
+		db.SetConnMaxLifetime(p.ConnMaxLifetime)
+	}
+	if p.ConnMaxIdleTime > 0 {
+		db.SetConnMaxIdleTime(p.ConnMaxIdleTime)
+	}
+}
+
+// TimeoutsConfig holds the timeouts of the HTTP server and the queries.
+// A zero value disables the corresponding timeout.
+type TimeoutsConfig struct {
+	// Query is how long a query can run before it is cancelled
+	Query time.Duration `yaml:"query"`
+	// Read is how long the server waits to read a whole request
+	Read time.Duration `yaml:"read"`
+	// Write is how long the server waits to write a whole response
+	Write time.Duration `yaml:"write"`
+	// Idle is how long keep-alive connections are kept open
+	Idle time.Duration `yaml:"idle"`
+}
+
+// LimitsConfig holds the limits on the size of the results.
+// A zero value means no limit.
+type LimitsConfig struct {
+	// DefaultMaxRec is the number of rows returned when
+	// the request does not have a MAXREC parameter
+	DefaultMaxRec int `yaml:"default_maxrec"`
+	// MaxRec is the largest MAXREC a request can ask for
+	MaxRec int `yaml:"maxrec"`
+	// MaxCost rejects queries whose plan has a larger estimated
+	// total cost, in the units of the PostgreSQL planner
+	MaxCost float64 `yaml:"max_cost"`
+	// MaxEstimatedRows rejects queries whose plan
+	// estimates a larger number of rows
+	MaxEstimatedRows float64 `yaml:"max_estimated_rows"`
+}
+
+// CheckPlan returns an error if the estimates of
+// the plan exceed the cost or the number of rows allowed
+func (l LimitsConfig) CheckPlan(plan *QueryPlan) error {
+	if l.MaxCost > 0 && plan.TotalCost > l.MaxCost {
+		return fmt.Errorf("Query estimated cost %.0f exceeds the limit of %.0f", plan.TotalCost, l.MaxCost)
+	}
+	if l.MaxEstimatedRows > 0 && plan.Rows > l.MaxEstimatedRows {
+		return fmt.Errorf("Query estimated rows %.0f exceed the limit of %.0f", plan.Rows, l.MaxEstimatedRows)
+	}
+	return nil
+}
+
+// PlanLimited reports whether queries must be explained to check their plan
+func (l LimitsConfig) PlanLimited() bool {
+	return l.MaxCost > 0 || l.MaxEstimatedRows > 0
 }
 
 type ConfigOption func(*Config)
 
+// NewConfig creates a configuration from the defaults and
+// the environment variables, and then applies the options
 func NewConfig(opts ...ConfigOption) *Config {
-	defaultDatabaseUrl := os.Getenv("DATABASE_URL")
-	defaultPort := 8080
-	config := &Config{
-		DatabaseURL: defaultDatabaseUrl,
-		Port:        defaultPort,
-	}
+	config := defaultConfig()
+	applyEnv(config)
 	for _, opt := range opts {
 		opt(config)
 	}
 	return config
 }
 
+// LoadConfig is like NewConfig, but the values of the file are applied
+// before the environment variables. The file can be YAML or TOML,
+// as told by its extension, and is skipped when the path is empty.
+// The resulting configuration is validated.
+func LoadConfig(path string, opts ...ConfigOption) (*Config, error) {
+	config := defaultConfig()
+	if path != "" {
+		if err := loadConfigFile(path, config); err != nil {
+			return nil, fmt.Errorf("Error loading config file %s: %w", path, err)
+		}
+	}
+	applyEnv(config)
+	for _, opt := range opts {
+		opt(config)
+	}
+	if err := config.Validate(); err != nil {
+		return nil, err
+	}
+	return config, nil
+}
+
+func defaultConfig() *Config {
+	return &Config{
+		Port:                8080,
+		HealthCheckInterval: 10 * time.Second,
+		Driver:              DriverSQL,
+		Formats:             slices.Clone(SupportedFormats),
+		Languages:           slices.Clone(SupportedLanguages),
+		DrainTimeout:        30 * time.Second,
+		Cache: CacheConfig{
+			MaxBytes:      64 << 20,
+			MaxEntryBytes: 4 << 20,
+		},
+	}
+}
+
+// loadConfigFile decodes the file on top of the config.
+// TOML files are converted to YAML first, so both formats
+// share the field names and the duration syntax, such as "30s".
+// Unknown fields are reported as errors.
+func loadConfigFile(path string, config *Config) error {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return err
+	}
+	switch strings.ToLower(filepath.Ext(path)) {
+	case ".yaml", ".yml":
+	case ".toml":
+		var values map[string]interface{}
+		if err := toml.Unmarshal(data, &values); err != nil {
+			return err
+		}
+		data, err = yaml.Marshal(values)
+		if err != nil {
+			return err
+		}
+	default:
+		return fmt.Errorf("unknown config file extension, use .yaml, .yml or .toml")
+	}
+	decoder := yaml.NewDecoder(bytes.NewReader(data))
+	decoder.KnownFields(true)
+	if err := decoder.Decode(config); err != nil && !errors.Is(err, io.EOF) {
+		return err
+	}
+	return nil
+}
+
+// applyEnv overrides the config with the environment variables that are set.
+// The invalid ones are kept in the config for Validate to report them.
+func applyEnv(config *Config) {
+	env := &envReader{}
+	env.string("DATABASE_URL", &config.DatabaseURL)
+	env.list("DATABASE_REPLICAS", &config.DatabaseReplicas)
+	env.duration("HEALTH_CHECK_INTERVAL", &config.HealthCheckInterval)
+	env.string("DB_DRIVER", &config.Driver)
+	env.bool("COPY_CSV", &config.CopyCSV)
+	env.int("PORT", &config.Port)
+	env.string("LOG_LEVEL", &config.LogLevel)
+	env.list("FORMATS", &config.Formats)
+	env.list("LANGUAGES", &config.Languages)
+	env.duration("DRAIN_TIMEOUT", &config.DrainTimeout)
+	env.bool("CONSOLE", &config.Console)
+	env.string("TLS_CERT_FILE", &config.TLS.CertFile)
+	env.string("TLS_KEY_FILE", &config.TLS.KeyFile)
+	env.int("DB_MAX_OPEN_CONNS", &config.Pool.MaxOpenConns)
+	env.int("DB_MAX_IDLE_CONNS", &config.Pool.MaxIdleConns)
+	env.duration("DB_CONN_MAX_LIFETIME", &config.Pool.ConnMaxLifetime)
+	env.duration("DB_CONN_MAX_IDLE_TIME", &config.Pool.ConnMaxIdleTime)
+	env.duration("QUERY_TIMEOUT", &config.Timeouts.Query)
+	env.duration("READ_TIMEOUT", &config.Timeouts.Read)
+	env.duration("WRITE_TIMEOUT", &config.Timeouts.Write)
+	env.duration("IDLE_TIMEOUT", &config.Timeouts.Idle)
+	env.int("DEFAULT_MAXREC", &config.Limits.DefaultMaxRec)
+	env.int("MAXREC_LIMIT", &config.Limits.MaxRec)
+	env.float("MAX_QUERY_COST", &config.Limits.MaxCost)
+	env.float("MAX_ESTIMATED_ROWS", &config.Limits.MaxEstimatedRows)
+	env.int("RATE_LIMIT_REQUESTS_PER_MINUTE", &config.RateLimit.RequestsPerMinute)
+	env.int("RATE_LIMIT_CONCURRENT_QUERIES", &config.RateLimit.MaxConcurrentQueries)
+	var rowsPerDay int
+	if env.int("RATE_LIMIT_ROWS_PER_DAY", &rowsPerDay) {
+		config.RateLimit.RowsPerDay = int64(rowsPerDay)
+	}
+	env.duration("CACHE_TTL", &config.Cache.TTL)
+	var cacheMaxBytes, cacheMaxEntryBytes int
+	if env.int("CACHE_MAX_BYTES", &cacheMaxBytes) {
+		config.Cache.MaxBytes = int64(cacheMaxBytes)
+	}
+	if env.int("CACHE_MAX_ENTRY_BYTES", &cacheMaxEntryBytes) {
+		config.Cache.MaxEntryBytes = int64(cacheMaxEntryBytes)
+	}
+	env.string("CACHE_DIR", &config.Cache.Dir)
+	env.string("AUTH_TOKENS_FILE", &config.Auth.TokensFile)
+	env.string("AUTH_HTPASSWD_FILE", &config.Auth.HtpasswdFile)
+	env.string("AUTH_JWKS_FILE", &config.Auth.JWKSFile)
+	env.string("AUTH_JWT_ISSUER", &config.Auth.JWTIssuer)
+	env.string("AUTH_JWT_AUDIENCE", &config.Auth.JWTAudience)
+	env.string("AUTH_JWT_GROUPS_CLAIM", &config.Auth.JWTGroupsClaim)
+	env.string("AUTH_POLICY_FILE", &config.Auth.PolicyFile)
+	config.envErrors = env.errs
+}
+
+// Validate checks that the values of the configuration are usable,
+// returning an error that describes every invalid value
+func (c *Config) Validate() error {
+	errs := slices.Clone(c.envErrors)
+	if c.DatabaseURL == "" {
+		errs = append(errs, fmt.Errorf("database_url is required"))
+	}
+	errs = append(errs, c.validateBackends()...)
+	if c.Port < 1 || c.Port > 65535 {
+		errs = append(errs, fmt.Errorf("port must be between 1 and 65535, got %d", c.Port))
+	}
+	if c.Driver != DriverSQL && c.Driver != DriverPgx {
+		errs = append(errs, fmt.Errorf("driver must be %s or %s, got %s", DriverSQL, DriverPgx, c.Driver))
+	}
+	if c.TLS.Enabled() && (c.TLS.CertFile == "" || c.TLS.KeyFile == "") {
+		errs = append(errs, fmt.Errorf("tls requires both cert_file and key_file"))
+	}
+	if c.LogLevel != "" {
+		var level slog.Level
+		if err := level.UnmarshalText([]byte(c.LogLevel)); err != nil {
+			errs = append(errs, fmt.Errorf("invalid log_level %s", c.LogLevel))
+		}
+	}
+	if c.Pool.MaxOpenConns < 0 || c.Pool.MaxIdleConns < 0 {
+		errs = append(errs, fmt.Errorf("pool sizes can not be negative"))
+	}
+	if c.Pool.MaxOpenConns > 0 && c.Pool.MaxIdleConns > c.Pool.MaxOpenConns {
+		errs = append(errs, fmt.Errorf("pool max_idle_conns can not be greater than max_open_conns"))
+	}
+	durations := map[string]time.Duration{
+		"pool conn_max_lifetime":  c.Pool.ConnMaxLifetime,
+		"pool conn_max_idle_time": c.Pool.ConnMaxIdleTime,
+		"timeouts query":          c.Timeouts.Query,
+		"timeouts read":           c.Timeouts.Read,
+		"timeouts write":          c.Timeouts.Write,
+		"timeouts idle":           c.Timeouts.Idle,
+		"drain_timeout":           c.DrainTimeout,
+		"health_check_interval":   c.HealthCheckInterval,
+		"cache ttl":               c.Cache.TTL,
+	}
+	for _, name := range slices.Sorted(maps.Keys(durations)) {
+		if durations[name] < 0 {
+			errs = append(errs, fmt.Errorf("%s can not be negative", name))
+		}
+	}
+	if c.Limits.DefaultMaxRec < 0 || c.Limits.MaxRec < 0 || c.Limits.MaxCost < 0 || c.Limits.MaxEstimatedRows < 0 {
+		errs = append(errs, fmt.Errorf("limits can not be negative"))
+	}
+	if c.Limits.MaxRec > 0 && c.Limits.DefaultMaxRec > c.Limits.MaxRec {
+		errs = append(errs, fmt.Errorf("limits default_maxrec can not be greater than maxrec"))
+	}
+	if c.RateLimit.RequestsPerMinute < 0 || c.RateLimit.MaxConcurrentQueries < 0 || c.RateLimit.RowsPerDay < 0 {
+		errs = append(errs, fmt.Errorf("rate_limit quotas can not be negative"))
+	}
+	if c.Cache.MaxBytes < 0 || c.Cache.MaxEntryBytes < 0 {
+		errs = append(errs, fmt.Errorf("cache sizes can not be negative"))
+	}
+	errs = append(errs, validateSubset("formats", c.Formats, SupportedFormats))
+	errs = append(errs, validateSubset("languages", c.Languages, SupportedLanguages))
+	return errors.Join(errs...)
+}
+
+func (c *Config) validateBackends() []error {
+	var errs []error
+	names := map[string]bool{DefaultBackend: true}
+	schemas := map[string]string{}
+	for i, backend := range c.Backends {
+		if backend.Name == "" {
+			errs = append(errs, fmt.Errorf("backends[%d] name is required", i))
+		} else if names[backend.Name] {
+			errs = append(errs, fmt.Errorf("backend name %s is used more than once", backend.Name))
+		}
+		names[backend.Name] = true
+		if backend.URL == "" {
+			errs = append(errs, fmt.Errorf("backend %s url is required", backend.Name))
+		}
+		if len(backend.Schemas) == 0 {
+			errs = append(errs, fmt.Errorf("backend %s must serve at least one schema", backend.Name))
+		}
+		for _, schema := range backend.Schemas {
+			schema = strings.ToLower(schema)
+			if other, ok := schemas[schema]; ok {
+				errs = append(errs, fmt.Errorf("schema %s is served by backends %s and %s", schema, other, backend.Name))
+			}
+			schemas[schema] = backend.Name
+		}
+	}
+	return errs
+}
+
+func validateSubset(name string, values []string, supported []string) error {
+	if len(values) == 0 {
+		return fmt.Errorf("at least one of %s must be enabled", name)
+	}
+	for _, value := range values {
+		if !slices.Contains(supported, value) {
+			return fmt.Errorf("unsupported %s %s, valid values are %s", name, value, strings.Join(supported, ", "))
+		}
+	}
+	return nil
+}
+
+// Redacted returns a copy of the configuration without secrets,
+// suitable to be printed or logged
+func (c *Config) Redacted() *Config {
+	redacted := *c
+	redacted.DatabaseURL = redactDatabaseURL(c.DatabaseURL)
+	redacted.DatabaseReplicas = redactDatabaseURLs(c.DatabaseReplicas)
+	redacted.Backends = slices.Clone(c.Backends)
+	for i, backend := range redacted.Backends {
+		redacted.Backends[i].URL = redactDatabaseURL(backend.URL)
+		redacted.Backends[i].Replicas = redactDatabaseURLs(backend.Replicas)
+	}
+	return &redacted
+}
+
+// YAML returns the configuration in the format of the config file
+func (c *Config) YAML() (string, error) {
+	data, err := yaml.Marshal(c)
+	if err != nil {
+		return "", err
+	}
+	return string(data), nil
+}
+
+func redactDatabaseURLs(databaseUrls []string) []string {
+	if databaseUrls == nil {
+		return nil
+	}
+	redacted := make([]string, len(databaseUrls))
+	for i, databaseUrl := range databaseUrls {
+		redacted[i] = redactDatabaseURL(databaseUrl)
+	}
+	return redacted
+}
+
+func redactDatabaseURL(databaseUrl string) string {
+	dsn, err := ParseDSN(databaseUrl)
+	if err != nil {
+		return "xxxxx"
+	}
+	return dsn.Redacted()
+}
+
 func WithDatabaseURL(databaseUrl string) ConfigOption {
 	return func(c *Config) {
 		c.DatabaseURL = databaseUrl
 	}
 }
 
+func WithDatabaseReplicas(replicaUrls ...string) ConfigOption {
+	return func(c *Config) {
+		c.DatabaseReplicas = replicaUrls
+	}
+}
+
+func WithBackends(backends ...BackendConfig) ConfigOption {
+	return func(c *Config) {
+		c.Backends = backends
+	}
+}
+
+func WithDriver(driver string) ConfigOption {
+	return func(c *Config) {
+		c.Driver = driver
+	}
+}
+
+func WithCopyCSV(copyCSV bool) ConfigOption {
+	return func(c *Config) {
+		c.CopyCSV = copyCSV
+	}
+}
+
+func WithConsole(console bool) ConfigOption {
+	return func(c *Config) {
+		c.Console = console
+	}
+}
+
 func WithPort(port int) ConfigOption {
 	return func(c *Config) {
 		c.Port = port
 	}
 }
+
+func WithTLS(tls TLSConfig) ConfigOption {
+	return func(c *Config) {
+		c.TLS = tls
+	}
+}
+
+func WithPool(pool PoolConfig) ConfigOption {
+	return func(c *Config) {
+		c.Pool = pool
+	}
+}
+
+func WithTimeouts(timeouts TimeoutsConfig) ConfigOption {
+	return func(c *Config) {
+		c.Timeouts = timeouts
+	}
+}
+
+func WithLimits(limits LimitsConfig) ConfigOption {
+	return func(c *Config) {
+		c.Limits = limits
+	}
+}
+
+func WithRateLimit(rateLimit RateLimitConfig) ConfigOption {
+	return func(c *Config) {
+		c.RateLimit = rateLimit
+	}
+}
+
+func WithAuth(auth AuthConfig) ConfigOption {
+	return func(c *Config) {
+		c.Auth = auth
+	}
+}
+
+func WithCache(cache CacheConfig) ConfigOption {
+	return func(c *Config) {
+		c.Cache = cache
+	}
+}
+
+func WithLogLevel(level string) ConfigOption {
+	return func(c *Config) {
+		c.LogLevel = level
+	}
+}
+
+func WithFormats(formats ...string) ConfigOption {
+	return func(c *Config) {
+		c.Formats = formats
+	}
+}
+
+func WithLanguages(languages ...string) ConfigOption {
+	return func(c *Config) {
+		c.Languages = languages
+	}
+}
+
+func WithDrainTimeout(timeout time.Duration) ConfigOption {
+	return func(c *Config) {
+		c.DrainTimeout = timeout
+	}
+}
+
+// envReader reads the environment variables,
+// collecting the errors of the invalid ones
+type envReader struct {
+	errs []error
+}
+
+// invalid records the error of an invalid variable
+func (e *envReader) invalid(name string, err error) {
+	e.errs = append(e.errs, fmt.Errorf("invalid environment variable %s: %w", name, err))
+}
+
+// string sets the value from the environment if the variable is not empty
+func (e *envReader) string(name string, value *string) {
+	if env := os.Getenv(name); env != "" {
+		*value = env
+	}
+}
+
+// int sets an integer from the environment, keeping
+// the current value if the variable is not set or invalid.
+// It reports whether the value was set.
+func (e *envReader) int(name string, value *int) bool {
+	env := os.Getenv(name)
+	if env == "" {
+		return false
+	}
+	parsed, err := strconv.Atoi(env)
+	if err != nil {
+		e.invalid(name, err)
+		return false
+	}
+	*value = parsed
+	return true
+}
+
+// float sets a number such as "1e6" from the environment,
+// keeping the current value if the variable is not set or invalid
+func (e *envReader) float(name string, value *float64) {
+	env := os.Getenv(name)
+	if env == "" {
+		return
+	}
+	parsed, err := strconv.ParseFloat(env, 64)
+	if err != nil {
+		e.invalid(name, err)
+		return
+	}
+	*value = parsed
+}
+
+// bool sets a boolean such as "true" or "1" from the environment,
+// keeping the current value if the variable is not set or invalid
+func (e *envReader) bool(name string, value *bool) {
+	env := os.Getenv(name)
+	if env == "" {
+		return
+	}
+	parsed, err := strconv.ParseBool(env)
+	if err != nil {
+		e.invalid(name, err)
+		return
+	}
+	*value = parsed
+}
+
+// duration sets a duration such as "30s" from the environment,
+// keeping the current value if the variable is not set or invalid
+func (e *envReader) duration(name string, value *time.Duration) {
+	env := os.Getenv(name)
+	if env == "" {
+		return
+	}
+	parsed, err := time.ParseDuration(env)
+	if err != nil {
+		e.invalid(name, err)
+		return
+	}
+	*value = parsed
+}
+
+// list sets a comma separated list from the environment
+func (e *envReader) list(name string, value *[]string) {
+	env := os.Getenv(name)
+	if env == "" {
+		return
+	}
+	*value = SplitList(env)
+}
+
+// SplitList splits a comma separated list, trimming spaces
+// and dropping empty items
+func SplitList(list string) []string {
+	items := []string{}
+	for _, item := range strings.Split(list, ",") {
+		if item = strings.TrimSpace(item); item != "" {
+			items = append(items, item)
+		}
+	}
+	return items
+}
@@ -0,0 +1,118 @@
+package tapsync
+
+import (
+	"ataps/pkg/alercedb"
+	"cmp"
+	"embed"
+	"html/template"
+	"io/fs"
+	"net/http"
+	"slices"
+
+	"github.com/gin-gonic/gin"
+)
+
+// consoleFiles are the page of the query console
+// and the scripts and styles it loads
+//
+//go:embed console
+var consoleFiles embed.FS
+
+// consoleTemplate is the page of the query console
+var consoleTemplate = template.Must(template.ParseFS(consoleFiles, "console/index.html"))
+
+// consoleExample is an example query of the console
+type consoleExample struct {
+	Title string `json:"title"`
+	Query string `json:"query"`
+}
+
+// consoleExamples are the example queries of the console
+var consoleExamples = []consoleExample{
+	{"Objects with many detections", "SELECT oid, meanra, meandec, ndet, firstmjd, lastmjd\nFROM object\nWHERE ndet > 20\nORDER BY ndet DESC\nLIMIT 100"},
+	{"Light curve of an object", "SELECT oid, mjd, fid, magpsf, sigmapsf\nFROM detection\nWHERE oid = 'ZTF18abbuksn'\nORDER BY mjd"},
+	{"Objects in a region of the sky", "SELECT oid, meanra, meandec, ndet\nFROM object\nWHERE meanra BETWEEN 150 AND 151\n  AND meandec BETWEEN 2 AND 3"},
+	{"Most probable classes", "SELECT oid, class_name, probability\nFROM probability\nWHERE classifier_name = 'lc_classifier' AND ranking = 1\nLIMIT 100"},
+}
+
+// consoleColumn describes a column of a table for the autocompletion
+type consoleColumn struct {
+	Name        string `json:"name"`
+	Unit        string `json:"unit,omitempty"`
+	UCD         string `json:"ucd,omitempty"`
+	Description string `json:"description,omitempty"`
+}
+
+// consoleTable describes a table for the autocompletion
+type consoleTable struct {
+	Name    string          `json:"name"`
+	Columns []consoleColumn `json:"columns"`
+}
+
+// consoleMetadata is what the console knows of the service: the
+// languages and formats enabled, the limits of MAXREC, the tables
+// and their columns, and the example queries
+type consoleMetadata struct {
+	Languages     []string         `json:"languages"`
+	Formats       []string         `json:"formats"`
+	DefaultMaxRec int              `json:"defaultMaxRec"`
+	MaxRec        int              `json:"maxRec"`
+	Tables        []consoleTable   `json:"tables"`
+	Examples      []consoleExample `json:"examples"`
+}
+
+// consoleTables returns the ALeRCE tables with their columns,
+// sorted by name
+func consoleTables() []consoleTable {
+	tables := make([]consoleTable, 0, len(alercedb.TableNames))
+	for _, name := range alercedb.TableNames {
+		table := consoleTable{Name: name}
+		for column, metadata := range alercedb.Columns[name] {
+			table.Columns = append(table.Columns, consoleColumn{
+				Name:        column,
+				Unit:        metadata.Unit,
+				UCD:         metadata.UCD,
+				Description: metadata.Description,
+			})
+		}
+		slices.SortFunc(table.Columns, func(a, b consoleColumn) int {
+			return cmp.Compare(a.Name, b.Name)
+		})
+		tables = append(tables, table)
+	}
+	return tables
+}
+
+// ConsoleHandler handles the GET request to /console/, the page of
+// the query console. It posts the queries to /sync, showing their
+// results as the HTML reports of the service, and downloads them in
+// the other formats enabled.
+func (service *TapSyncService) ConsoleHandler(c *gin.Context) {
+	metadata := consoleMetadata{
+		Languages:     service.config.Languages,
+		Formats:       service.config.Formats,
+		DefaultMaxRec: service.config.Limits.DefaultMaxRec,
+		MaxRec:        service.config.Limits.MaxRec,
+		Tables:        consoleTables(),
+		Examples:      consoleExamples,
+	}
+	c.Header("Content-Type", "text/html; charset=utf-8")
+	c.Status(http.StatusOK)
+	if err := consoleTemplate.Execute(c.Writer, metadata); err != nil {
+		c.Error(err)
+	}
+}
+
+// registerConsole serves the query console at /console/,
+// the root redirecting to it
+func (service *TapSyncService) registerConsole() {
+	static, err := fs.Sub(consoleFiles, "console/static")
+	if err != nil {
+		panic(err)
+	}
+	service.Router.GET("/", func(c *gin.Context) {
+		c.Redirect(http.StatusFound, "/console/")
+	})
+	service.Router.GET("/console/", service.ConsoleHandler)
+	service.Router.StaticFS("/console/static", http.FS(static))
+}
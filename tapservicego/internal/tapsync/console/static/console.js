@@ -0,0 +1,303 @@
+(function () {
+	"use strict";
+	var historyKey = "ataps.history";
+	var historySize = 50;
+	var keywords = ["SELECT", "DISTINCT", "FROM", "WHERE", "AND", "OR", "NOT", "IN", "IS", "NULL", "LIKE", "ILIKE",
+		"BETWEEN", "JOIN", "LEFT", "INNER", "ON", "USING", "GROUP", "BY", "ORDER", "ASC", "DESC", "HAVING",
+		"LIMIT", "OFFSET", "AS", "COUNT", "AVG", "MIN", "MAX", "SUM", "WITH", "UNION", "ALL", "CASE", "WHEN",
+		"THEN", "ELSE", "END"];
+	// the extensions of the files downloaded in each format
+	var extensions = {
+		"votable": "xml", "votable-mivot": "xml", "csv": "csv", "tsv": "tsv", "fits": "fits",
+		"text": "txt", "ecsv": "ecsv", "ipac": "tbl"
+	};
+
+	var form = document.getElementById("query");
+	var editor = form.elements.QUERY;
+	var suggestions = document.getElementById("suggestions");
+	var status = document.getElementById("status");
+	var preview = document.getElementById("preview");
+	var token = document.getElementById("token");
+	var selected = -1;
+
+	token.value = sessionStorage.getItem("ataps.token") || "";
+	token.addEventListener("change", function () { sessionStorage.setItem("ataps.token", token.value); });
+
+	// post posts the query in the format, with the extra parameters,
+	// resolving to the response or rejecting with the error of the service
+	function post(format, extra) {
+		var params = new URLSearchParams(extra || {});
+		params.set("LANG", form.elements.LANG.value);
+		params.set("QUERY", editor.value);
+		params.set("FORMAT", format);
+		if (form.elements.MAXREC.value !== "") {
+			params.set("MAXREC", form.elements.MAXREC.value);
+		}
+		var headers = {};
+		if (token.value !== "") {
+			headers.Authorization = "Bearer " + token.value;
+		}
+		return fetch("../sync", { method: "POST", body: params, headers: headers }).then(function (response) {
+			if (response.ok) {
+				return response;
+			}
+			return response.text().then(function (text) { throw new Error(errorMessage(response, text)); });
+		});
+	}
+
+	// errorMessage returns the ERROR_DETAIL of the VOTable of an error
+	function errorMessage(response, text) {
+		var doc = new DOMParser().parseFromString(text, "application/xml");
+		var infos = doc.getElementsByTagName("INFO");
+		for (var i = 0; i < infos.length; i++) {
+			if (infos[i].getAttribute("name") === "ERROR_DETAIL") {
+				return infos[i].textContent.trim();
+			}
+		}
+		return response.status + " " + response.statusText;
+	}
+
+	function setStatus(message, error) {
+		status.textContent = message;
+		status.classList.toggle("error", !!error);
+	}
+
+	function run() {
+		if (editor.value.trim() === "") {
+			return;
+		}
+		setStatus("Running the query…");
+		var start = performance.now();
+		addHistory();
+		// the sandboxed preview can not post the download forms
+		// of the report, the buttons of the console download instead
+		post("html", { DOWNLOADS: "false" }).then(function (response) {
+			return response.text();
+		}).then(function (html) {
+			setStatus("Query done in " + ((performance.now() - start) / 1000).toFixed(2) + " s");
+			preview.srcdoc = html;
+		}).catch(function (err) {
+			setStatus(err.message, true);
+			preview.srcdoc = "";
+		});
+	}
+
+	function download(format) {
+		setStatus("Downloading the results as " + format + "…");
+		post(format).then(function (response) {
+			return response.blob();
+		}).then(function (blob) {
+			var link = document.createElement("a");
+			link.href = URL.createObjectURL(blob);
+			link.download = "results." + (extensions[format] || format);
+			document.body.appendChild(link);
+			link.click();
+			link.remove();
+			setTimeout(function () { URL.revokeObjectURL(link.href); }, 1000);
+			setStatus("Downloaded the results as " + format);
+		}).catch(function (err) {
+			setStatus(err.message, true);
+		});
+	}
+
+	form.addEventListener("submit", function (event) {
+		event.preventDefault();
+		hideSuggestions();
+		run();
+	});
+	Array.prototype.forEach.call(form.querySelectorAll("button.download"), function (button) {
+		button.addEventListener("click", function () { download(button.value); });
+	});
+	document.getElementById("examples").addEventListener("change", function (event) {
+		var example = metadata.examples[event.target.value];
+		if (example) {
+			editor.value = example.query;
+			editor.focus();
+		}
+		event.target.value = "";
+	});
+
+	// history
+
+	function loadHistory() {
+		try {
+			return JSON.parse(localStorage.getItem(historyKey)) || [];
+		} catch (err) {
+			return [];
+		}
+	}
+
+	function addHistory() {
+		var entry = { lang: form.elements.LANG.value, query: editor.value, date: new Date().toISOString() };
+		var entries = loadHistory().filter(function (e) { return e.query !== entry.query || e.lang !== entry.lang; });
+		entries.unshift(entry);
+		localStorage.setItem(historyKey, JSON.stringify(entries.slice(0, historySize)));
+		renderHistory();
+	}
+
+	function renderHistory() {
+		var list = document.getElementById("history");
+		list.textContent = "";
+		loadHistory().forEach(function (entry) {
+			var item = document.createElement("li");
+			var code = document.createElement("code");
+			code.textContent = entry.query;
+			var time = document.createElement("time");
+			time.dateTime = entry.date;
+			time.textContent = new Date(entry.date).toLocaleString();
+			item.appendChild(code);
+			item.appendChild(time);
+			item.title = "Load the query";
+			item.addEventListener("click", function () {
+				form.elements.LANG.value = entry.lang;
+				editor.value = entry.query;
+				editor.focus();
+			});
+			list.appendChild(item);
+		});
+	}
+
+	document.getElementById("clear-history").addEventListener("click", function () {
+		localStorage.removeItem(historyKey);
+		renderHistory();
+	});
+	renderHistory();
+
+	// autocompletion
+
+	// currentWord returns the word before the caret and where it starts
+	function currentWord() {
+		var end = editor.selectionStart;
+		var start = end;
+		while (start > 0 && /[\w.]/.test(editor.value.charAt(start - 1))) {
+			start--;
+		}
+		return { start: start, end: end, text: editor.value.slice(start, end) };
+	}
+
+	// candidates returns the completions of the word: the columns of
+	// a table before a dot, or the tables, the columns of the tables
+	// in the query, and the keywords
+	function candidates(word) {
+		var dot = word.lastIndexOf(".");
+		if (dot >= 0) {
+			var tableName = word.slice(0, dot).toLowerCase();
+			var prefix = word.slice(dot + 1).toLowerCase();
+			var table = metadata.tables.find(function (t) { return t.name === tableName; });
+			if (!table) {
+				return [];
+			}
+			return table.columns.filter(function (c) { return c.name.indexOf(prefix) === 0; }).map(function (c) {
+				return { text: word.slice(0, dot + 1) + c.name, detail: columnDetail(c) };
+			});
+		}
+		var lower = word.toLowerCase();
+		var query = editor.value.toLowerCase();
+		var results = [];
+		var seen = {};
+		metadata.tables.forEach(function (t) {
+			if (t.name.indexOf(lower) === 0) {
+				results.push({ text: t.name, detail: "table" });
+			}
+		});
+		metadata.tables.forEach(function (t) {
+			if (!new RegExp("\\b" + t.name + "\\b").test(query)) {
+				return;
+			}
+			t.columns.forEach(function (c) {
+				if (c.name.indexOf(lower) === 0 && !seen[c.name]) {
+					seen[c.name] = true;
+					results.push({ text: c.name, detail: columnDetail(c) });
+				}
+			});
+		});
+		keywords.forEach(function (k) {
+			if (k.toLowerCase().indexOf(lower) === 0) {
+				results.push({ text: k, detail: "" });
+			}
+		});
+		return results;
+	}
+
+	function columnDetail(column) {
+		return column.description ? column.description + (column.unit ? " [" + column.unit + "]" : "") : "";
+	}
+
+	function showSuggestions() {
+		var word = currentWord();
+		var items = word.text.length > 0 ? candidates(word.text) : [];
+		suggestions.textContent = "";
+		selected = items.length > 0 ? 0 : -1;
+		items.slice(0, 50).forEach(function (item, i) {
+			var li = document.createElement("li");
+			li.setAttribute("role", "option");
+			li.textContent = item.text;
+			if (item.detail) {
+				var detail = document.createElement("small");
+				detail.textContent = item.detail;
+				li.appendChild(detail);
+			}
+			li.dataset.text = item.text;
+			li.addEventListener("mousedown", function (event) {
+				event.preventDefault();
+				complete(item.text);
+			});
+			li.setAttribute("aria-selected", i === selected ? "true" : "false");
+			suggestions.appendChild(li);
+		});
+		suggestions.hidden = selected < 0;
+	}
+
+	function hideSuggestions() {
+		suggestions.hidden = true;
+		selected = -1;
+	}
+
+	function select(index) {
+		var items = suggestions.children;
+		selected = (index + items.length) % items.length;
+		Array.prototype.forEach.call(items, function (li, i) {
+			li.setAttribute("aria-selected", i === selected ? "true" : "false");
+		});
+		items[selected].scrollIntoView({ block: "nearest" });
+	}
+
+	function complete(text) {
+		var word = currentWord();
+		editor.setRangeText(text, word.start, word.end, "end");
+		hideSuggestions();
+		editor.focus();
+	}
+
+	editor.addEventListener("input", showSuggestions);
+	editor.addEventListener("blur", hideSuggestions);
+	editor.addEventListener("keydown", function (event) {
+		if (event.key === "Enter" && (event.ctrlKey || event.metaKey)) {
+			event.preventDefault();
+			hideSuggestions();
+			run();
+			return;
+		}
+		if (suggestions.hidden) {
+			return;
+		}
+		switch (event.key) {
+		case "ArrowDown":
+			event.preventDefault();
+			select(selected + 1);
+			break;
+		case "ArrowUp":
+			event.preventDefault();
+			select(selected - 1);
+			break;
+		case "Tab":
+		case "Enter":
+			event.preventDefault();
+			complete(suggestions.children[selected].dataset.text);
+			break;
+		case "Escape":
+			hideSuggestions();
+			break;
+		}
+	});
+})();
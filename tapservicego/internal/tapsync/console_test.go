@@ -0,0 +1,66 @@
+package tapsync
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/gin-gonic/gin"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func newTestConsoleService(config *Config) *TapSyncService {
+	gin.SetMode(gin.TestMode)
+	service := &TapSyncService{Router: gin.New(), config: config}
+	service.registerConsole()
+	return service
+}
+
+func TestConsoleHandler(t *testing.T) {
+	service := newTestConsoleService(NewConfig(WithFormats("votable", "csv", "html"), WithLimits(LimitsConfig{DefaultMaxRec: 100, MaxRec: 1000})))
+	w := httptest.NewRecorder()
+	req, _ := http.NewRequest("GET", "/console/", nil)
+	service.Router.ServeHTTP(w, req)
+	require.Equal(t, http.StatusOK, w.Code)
+	assert.Equal(t, "text/html; charset=utf-8", w.Header().Get("Content-Type"))
+	body := w.Body.String()
+	assert.Contains(t, body, "<option>PSQL</option>")
+	assert.Contains(t, body, `<input type="number" name="MAXREC" min="0" max="1000" placeholder="100">`)
+	assert.Contains(t, body, `<button type="button" class="download" value="csv">csv</button>`)
+	assert.NotContains(t, body, `class="download" value="html"`)
+	assert.Contains(t, body, `<option value="0">Objects with many detections</option>`)
+	// the metadata of the tables is given to the script for the autocompletion
+	assert.Contains(t, body, `{"name":"meanra","unit":"deg","ucd":"pos.eq.ra;meta.main","description":"Mean right ascension of the detections"}`)
+	assert.Contains(t, body, `"formats":["votable","csv","html"]`)
+}
+
+func TestConsoleStatic(t *testing.T) {
+	service := newTestConsoleService(NewConfig())
+	testCases := []struct {
+		path     string
+		code     int
+		location string
+	}{
+		{"/", http.StatusFound, "/console/"},
+		{"/console", http.StatusMovedPermanently, "/console/"},
+		{"/console/static/console.js", http.StatusOK, ""},
+		{"/console/static/console.css", http.StatusOK, ""},
+		{"/console/static/missing.js", http.StatusNotFound, ""},
+	}
+	for _, tc := range testCases {
+		w := httptest.NewRecorder()
+		req, _ := http.NewRequest("GET", tc.path, nil)
+		service.Router.ServeHTTP(w, req)
+		assert.Equal(t, tc.code, w.Code, tc.path)
+		assert.Equal(t, tc.location, w.Header().Get("Location"), tc.path)
+	}
+}
+
+func TestConsoleTables(t *testing.T) {
+	tables := consoleTables()
+	require.Len(t, tables, 6)
+	assert.Equal(t, "object", tables[0].Name)
+	assert.Equal(t, "corrected", tables[0].Columns[0].Name)
+	assert.Equal(t, consoleColumn{Name: "oid", UCD: "meta.id;meta.main", Description: "ALeRCE object identifier"}, tables[0].Columns[6])
+}
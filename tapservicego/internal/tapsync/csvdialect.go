@@ -0,0 +1,170 @@
+package tapsync
+
+import (
+	"ataps/internal/parsers"
+	"fmt"
+	"mime"
+	"net/http"
+	"sort"
+	"strconv"
+	"strings"
+	"unicode/utf8"
+
+	"github.com/gin-gonic/gin"
+)
+
+// csvDialectKey is the gin context key of the dialect of CSV and TSV
+// responses, set by the handler once the format is known
+const csvDialectKey = "ataps.csvDialect"
+
+// formatMediaTypes maps the media types accepted as FORMAT
+// and RESPONSEFORMAT to the formats of the service
+var formatMediaTypes = map[string]string{
+	"application/x-votable+xml": "votable",
+	"text/xml":                  "votable",
+	"text/csv":                  "csv",
+	"text/tab-separated-values": "tsv",
+	"text/x-ecsv":               "ecsv",
+	"application/fits":          "fits",
+	"text/plain":                "text",
+	"text/html":                 "html",
+}
+
+// csvParameters are the parameters of the dialect of CSV and TSV,
+// given as request parameters or, lowercased, as parameters of
+// the media type of the format, such as text/csv;header=absent
+var csvParameters = []string{"NULL", "FLOATFORMAT", "PRECISION", "HEADER", "DELIMITER", "QUOTING", "BOM", "COMMENTS"}
+
+// csvDialect is the dialect requested for CSV and TSV responses
+type csvDialect struct {
+	// params are the parameters given, as sorted name=value pairs,
+	// which identify the dialect
+	params []string
+	// options are the options of the parsers for the parameters
+	options []parsers.CSVOption
+	// comments tells if the provenance of the results is written
+	// as comments before them
+	comments bool
+	// floatFormat and precision format the floats, as in
+	// strconv.FormatFloat, when one of them is given
+	floatFormat byte
+	precision   int
+}
+
+// parseFormat returns the format of a FORMAT or RESPONSEFORMAT value
+// and the parameters of its media type, if it is one of formatMediaTypes.
+// Other values are returned as they are.
+func parseFormat(value string) (string, map[string]string) {
+	mediaType, params, err := mime.ParseMediaType(value)
+	if err != nil {
+		return value, nil
+	}
+	if format, ok := formatMediaTypes[mediaType]; ok {
+		return format, params
+	}
+	return value, nil
+}
+
+// getCSVDialect returns the dialect of the CSV and TSV formats
+// from the request parameters and the parameters of the media type
+// of the format, the request parameters taking precedence.
+// If a parameter is invalid, the error is added to the response
+// and false is returned.
+func getCSVDialect(c *gin.Context, format string) (csvDialect, bool) {
+	dialect := csvDialect{floatFormat: 'g', precision: -1}
+	if format != "csv" && format != "tsv" {
+		return dialect, true
+	}
+	_, mediaParams := parseFormat(c.PostForm("FORMAT") + c.PostForm("RESPONSEFORMAT"))
+	for _, name := range csvParameters {
+		value, ok := c.GetPostForm(name)
+		if !ok {
+			value, ok = mediaParams[strings.ToLower(name)]
+		}
+		if !ok {
+			continue
+		}
+		option, err := dialect.parseParameter(name, value)
+		if err != nil {
+			code := http.StatusBadRequest
+			c.XML(code, getErrorVOTable(err, code))
+			return dialect, false
+		}
+		if option != nil {
+			dialect.options = append(dialect.options, option)
+		}
+		dialect.params = append(dialect.params, name+"="+value)
+	}
+	if dialect.floatFormat != 'g' || dialect.precision >= 0 {
+		dialect.options = append(dialect.options, parsers.WithFloatFormat(dialect.floatFormat, dialect.precision))
+	}
+	sort.Strings(dialect.params)
+	return dialect, true
+}
+
+// parseParameter returns the option for a parameter of the dialect
+func (dialect *csvDialect) parseParameter(name string, value string) (parsers.CSVOption, error) {
+	invalid := fmt.Errorf("Invalid %s %s", name, value)
+	switch name {
+	case "NULL":
+		return parsers.WithNull(value), nil
+	case "FLOATFORMAT":
+		if len(value) != 1 || !strings.Contains("eEfgG", value) {
+			return nil, invalid
+		}
+		dialect.floatFormat = value[0]
+		return nil, nil
+	case "PRECISION":
+		precision, err := strconv.Atoi(value)
+		if err != nil || precision < 0 {
+			return nil, invalid
+		}
+		dialect.precision = precision
+		return nil, nil
+	case "HEADER":
+		if value != "present" && value != "absent" {
+			return nil, invalid
+		}
+		return parsers.WithHeader(value == "present"), nil
+	case "DELIMITER":
+		if value == "tab" {
+			return parsers.WithDelimiter('\t'), nil
+		}
+		delimiter, size := utf8.DecodeRuneInString(value)
+		if size != len(value) || size == 0 || strings.ContainsRune("\"\r\n", delimiter) || delimiter == utf8.RuneError {
+			return nil, invalid
+		}
+		return parsers.WithDelimiter(delimiter), nil
+	case "QUOTING":
+		if value != "minimal" && value != "all" {
+			return nil, invalid
+		}
+		return parsers.WithQuoteAll(value == "all"), nil
+	case "BOM":
+		bom, err := strconv.ParseBool(value)
+		if err != nil {
+			return nil, invalid
+		}
+		return parsers.WithBOM(bom), nil
+	case "COMMENTS":
+		comments, err := strconv.ParseBool(value)
+		if err != nil {
+			return nil, invalid
+		}
+		dialect.comments = comments
+		return nil, nil
+	}
+	return nil, invalid
+}
+
+// csvOptions returns the options of the parsers for the dialect
+// of the request, describing the columns and, when the comments
+// are requested, the provenance of the results
+func csvOptions(c *gin.Context, columns []parsers.Column, overflow bool) []parsers.CSVOption {
+	dialect, _ := c.Value(csvDialectKey).(csvDialect)
+	options := append([]parsers.CSVOption{parsers.WithColumns(columns...)}, dialect.options...)
+	if dialect.comments {
+		options = append(options, parsers.WithComments(provenance(c, overflow)...))
+	}
+	return options
+}
@@ -0,0 +1,116 @@
+package tapsync
+
+import (
+	"ataps/internal/parsers"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strings"
+	"testing"
+
+	"github.com/gin-gonic/gin"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestParseFormat(t *testing.T) {
+	format, params := parseFormat("text/csv; header=absent")
+	assert.Equal(t, "csv", format)
+	assert.Equal(t, map[string]string{"header": "absent"}, params)
+	format, params = parseFormat("application/x-votable+xml")
+	assert.Equal(t, "votable", format)
+	assert.Empty(t, params)
+	format, params = parseFormat("votable")
+	assert.Equal(t, "votable", format)
+	assert.Nil(t, params)
+	format, _ = parseFormat("application/json")
+	assert.Equal(t, "application/json", format)
+}
+
+func TestGetCSVDialect(t *testing.T) {
+	data := []map[string]interface{}{{"n": int64(1), "x": 0.123456, "s": nil}}
+	columns := []parsers.Column{{Name: "n", Type: "INT8"}, {Name: "x", Type: "FLOAT8"}, {Name: "s", Type: "TEXT"}}
+	testCases := []struct {
+		name     string
+		form     url.Values
+		params   []string
+		expected string
+	}{
+		{"default", url.Values{"FORMAT": {"csv"}}, nil, "n,s,x\n1,,0.123456\n"},
+		{
+			"media type",
+			url.Values{"FORMAT": {"text/csv;header=absent;null=NULL"}},
+			[]string{"HEADER=absent", "NULL=NULL"},
+			"1,NULL,0.123456\n",
+		},
+		{
+			"request parameters",
+			url.Values{"RESPONSEFORMAT": {"text/csv;delimiter=|"}, "DELIMITER": {"tab"}, "FLOATFORMAT": {"f"}, "PRECISION": {"2"}, "QUOTING": {"all"}},
+			[]string{"DELIMITER=tab", "FLOATFORMAT=f", "PRECISION=2", "QUOTING=all"},
+			"\"n\"\t\"s\"\t\"x\"\n\"1\"\t\"\"\t\"0.12\"\n",
+		},
+		{"tsv", url.Values{"FORMAT": {"tsv"}, "NULL": {"-"}}, []string{"NULL=-"}, "n\ts\tx\n1\t-\t0.123456\n"},
+	}
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			w := httptest.NewRecorder()
+			c, _ := gin.CreateTestContext(w)
+			c.Request, _ = http.NewRequest("POST", "/sync", strings.NewReader(tc.form.Encode()))
+			c.Request.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+			format := getFormatOrResponseFormat(c, SupportedFormats)
+			dialect, ok := getCSVDialect(c, format)
+			require.True(t, ok)
+			assert.Equal(t, tc.params, dialect.params)
+			c.Set(csvDialectKey, dialect)
+			require.NoError(t, setResponse(c, data, columns, format, false))
+			assert.Equal(t, tc.expected, w.Body.String())
+		})
+	}
+}
+
+func TestGetCSVDialectComments(t *testing.T) {
+	w := httptest.NewRecorder()
+	c, _ := gin.CreateTestContext(w)
+	form := url.Values{"LANG": {"PSQL"}, "QUERY": {"SELECT 1 AS n"}, "FORMAT": {"csv"}, "COMMENTS": {"true"}, "BOM": {"1"}}
+	c.Request, _ = http.NewRequest("POST", "/sync", strings.NewReader(form.Encode()))
+	c.Request.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	dialect, ok := getCSVDialect(c, "csv")
+	require.True(t, ok)
+	c.Set(csvDialectKey, dialect)
+	require.NoError(t, setResponse(c, []map[string]interface{}{{"n": int64(1)}}, nil, "csv", true))
+	assert.True(t, strings.HasPrefix(w.Body.String(), "\uFEFF# LANG: PSQL\n# QUERY: SELECT 1 AS n\n# DATE: "))
+	assert.True(t, strings.HasSuffix(w.Body.String(), "# QUERY_STATUS: OVERFLOW\nn\n1\n"))
+}
+
+func TestGetCSVDialectInvalid(t *testing.T) {
+	testCases := []struct {
+		form url.Values
+		err  string
+	}{
+		{url.Values{"HEADER": {"yes"}}, "Invalid HEADER yes"},
+		{url.Values{"FORMAT": {"text/csv;floatformat=x"}}, "Invalid FLOATFORMAT x"},
+		{url.Values{"PRECISION": {"-1"}}, "Invalid PRECISION -1"},
+		{url.Values{"DELIMITER": {"::"}}, "Invalid DELIMITER ::"},
+		{url.Values{"DELIMITER": {`"`}}, "Invalid DELIMITER"},
+		{url.Values{"QUOTING": {"none"}}, "Invalid QUOTING none"},
+		{url.Values{"BOM": {"maybe"}}, "Invalid BOM maybe"},
+		{url.Values{"COMMENTS": {"maybe"}}, "Invalid COMMENTS maybe"},
+	}
+	for _, tc := range testCases {
+		w := httptest.NewRecorder()
+		c, _ := gin.CreateTestContext(w)
+		c.Request, _ = http.NewRequest("POST", "/sync", strings.NewReader(tc.form.Encode()))
+		c.Request.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+		_, ok := getCSVDialect(c, "csv")
+		assert.False(t, ok)
+		assert.Equal(t, http.StatusBadRequest, w.Code)
+		assert.Contains(t, w.Body.String(), tc.err)
+	}
+	// the parameters are ignored by the other formats
+	w := httptest.NewRecorder()
+	c, _ := gin.CreateTestContext(w)
+	c.Request, _ = http.NewRequest("POST", "/sync", strings.NewReader("HEADER=yes"))
+	c.Request.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	_, ok := getCSVDialect(c, "votable")
+	assert.True(t, ok)
+}
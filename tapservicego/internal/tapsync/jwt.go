@@ -0,0 +1,279 @@
+package tapsync
+
+import (
+	"crypto"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rsa"
+	_ "crypto/sha256"
+	_ "crypto/sha512"
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"math/big"
+	"net/http"
+	"os"
+	"slices"
+	"strings"
+	"time"
+)
+
+// jwtLeeway is the clock skew tolerated when checking exp and nbf
+const jwtLeeway = time.Minute
+
+// JWTAuthenticator authenticates requests with JWT bearer tokens
+// signed by one of the keys of a local JSON Web Key Set.
+// Supported algorithms are RS256, RS384, RS512, ES256, ES384 and ES512.
+type JWTAuthenticator struct {
+	keys        map[string]crypto.PublicKey
+	issuer      string
+	audience    string
+	groupsClaim string
+	now         func() time.Time
+}
+
+type jsonWebKey struct {
+	Kty string `json:"kty"`
+	Kid string `json:"kid"`
+	Use string `json:"use"`
+	N   string `json:"n"`
+	E   string `json:"e"`
+	Crv string `json:"crv"`
+	X   string `json:"x"`
+	Y   string `json:"y"`
+}
+
+type jwtHeader struct {
+	Alg string `json:"alg"`
+	Kid string `json:"kid"`
+}
+
+// NewJWTAuthenticator reads the JWKS file and creates an authenticator
+// that checks the issuer and audience of the tokens when they are not empty.
+// The groups of the identity are read from groupsClaim, "groups" by default.
+func NewJWTAuthenticator(jwksFile, issuer, audience, groupsClaim string) (*JWTAuthenticator, error) {
+	content, err := os.ReadFile(jwksFile)
+	if err != nil {
+		return nil, err
+	}
+	var jwks struct {
+		Keys []jsonWebKey `json:"keys"`
+	}
+	if err := json.Unmarshal(content, &jwks); err != nil {
+		return nil, fmt.Errorf("Error reading JWKS file %s: %w", jwksFile, err)
+	}
+	keys := make(map[string]crypto.PublicKey, len(jwks.Keys))
+	for _, jwk := range jwks.Keys {
+		if jwk.Use != "" && jwk.Use != "sig" {
+			continue
+		}
+		key, err := parseJSONWebKey(jwk)
+		if err != nil {
+			return nil, fmt.Errorf("Error reading key %q from JWKS file %s: %w", jwk.Kid, jwksFile, err)
+		}
+		keys[jwk.Kid] = key
+	}
+	if len(keys) == 0 {
+		return nil, fmt.Errorf("JWKS file %s has no signing keys", jwksFile)
+	}
+	if groupsClaim == "" {
+		groupsClaim = "groups"
+	}
+	return &JWTAuthenticator{
+		keys:        keys,
+		issuer:      issuer,
+		audience:    audience,
+		groupsClaim: groupsClaim,
+		now:         time.Now,
+	}, nil
+}
+
+func (a *JWTAuthenticator) Authenticate(r *http.Request) (*Identity, error) {
+	token, ok := bearerToken(r)
+	if !ok || !looksLikeJWT(token) {
+		return nil, errNoCredentials
+	}
+	claims, err := a.verify(token)
+	if err != nil {
+		return nil, fmt.Errorf("Invalid token: %w", err)
+	}
+	subject, _ := claims["sub"].(string)
+	if subject == "" {
+		return nil, fmt.Errorf("Invalid token: missing sub claim")
+	}
+	return &Identity{Name: subject, Groups: stringList(claims[a.groupsClaim]), Provider: "jwt"}, nil
+}
+
+// verify checks the signature and the registered claims of the token
+// and returns its claims
+func (a *JWTAuthenticator) verify(token string) (map[string]interface{}, error) {
+	parts := strings.Split(token, ".")
+	var header jwtHeader
+	if err := decodeSegment(parts[0], &header); err != nil {
+		return nil, err
+	}
+	key, err := a.findKey(header.Kid)
+	if err != nil {
+		return nil, err
+	}
+	signature, err := base64.RawURLEncoding.DecodeString(parts[2])
+	if err != nil {
+		return nil, err
+	}
+	if err := verifySignature(header.Alg, key, parts[0]+"."+parts[1], signature); err != nil {
+		return nil, err
+	}
+	claims := map[string]interface{}{}
+	if err := decodeSegment(parts[1], &claims); err != nil {
+		return nil, err
+	}
+	now := a.now()
+	exp, ok := claims["exp"].(float64)
+	if !ok {
+		return nil, fmt.Errorf("missing exp claim")
+	}
+	if now.After(time.Unix(int64(exp), 0).Add(jwtLeeway)) {
+		return nil, fmt.Errorf("token expired")
+	}
+	if nbf, ok := claims["nbf"].(float64); ok && now.Add(jwtLeeway).Before(time.Unix(int64(nbf), 0)) {
+		return nil, fmt.Errorf("token not valid yet")
+	}
+	if a.issuer != "" && claims["iss"] != a.issuer {
+		return nil, fmt.Errorf("unexpected issuer")
+	}
+	if a.audience != "" && !slices.Contains(stringList(claims["aud"]), a.audience) {
+		return nil, fmt.Errorf("unexpected audience")
+	}
+	return claims, nil
+}
+
+func (a *JWTAuthenticator) findKey(kid string) (crypto.PublicKey, error) {
+	if key, ok := a.keys[kid]; ok {
+		return key, nil
+	}
+	if kid == "" && len(a.keys) == 1 {
+		for _, key := range a.keys {
+			return key, nil
+		}
+	}
+	return nil, fmt.Errorf("unknown key %q", kid)
+}
+
+func verifySignature(alg string, key crypto.PublicKey, signed string, signature []byte) error {
+	if len(alg) != 5 {
+		return fmt.Errorf("unsupported algorithm %s", alg)
+	}
+	var hash crypto.Hash
+	switch alg[2:] {
+	case "256":
+		hash = crypto.SHA256
+	case "384":
+		hash = crypto.SHA384
+	case "512":
+		hash = crypto.SHA512
+	default:
+		return fmt.Errorf("unsupported algorithm %s", alg)
+	}
+	h := hash.New()
+	h.Write([]byte(signed))
+	digest := h.Sum(nil)
+	switch k := key.(type) {
+	case *rsa.PublicKey:
+		if !strings.HasPrefix(alg, "RS") {
+			return fmt.Errorf("algorithm %s does not match RSA key", alg)
+		}
+		return rsa.VerifyPKCS1v15(k, hash, digest, signature)
+	case *ecdsa.PublicKey:
+		if !strings.HasPrefix(alg, "ES") {
+			return fmt.Errorf("algorithm %s does not match EC key", alg)
+		}
+		size := (k.Curve.Params().BitSize + 7) / 8
+		if len(signature) != 2*size {
+			return fmt.Errorf("invalid signature length")
+		}
+		r := new(big.Int).SetBytes(signature[:size])
+		s := new(big.Int).SetBytes(signature[size:])
+		if !ecdsa.Verify(k, digest, r, s) {
+			return fmt.Errorf("invalid signature")
+		}
+		return nil
+	default:
+		return fmt.Errorf("unsupported key type %T", key)
+	}
+}
+
+func parseJSONWebKey(jwk jsonWebKey) (crypto.PublicKey, error) {
+	switch jwk.Kty {
+	case "RSA":
+		n, err := decodeBigInt(jwk.N)
+		if err != nil {
+			return nil, err
+		}
+		e, err := decodeBigInt(jwk.E)
+		if err != nil {
+			return nil, err
+		}
+		return &rsa.PublicKey{N: n, E: int(e.Int64())}, nil
+	case "EC":
+		var curve elliptic.Curve
+		switch jwk.Crv {
+		case "P-256":
+			curve = elliptic.P256()
+		case "P-384":
+			curve = elliptic.P384()
+		case "P-521":
+			curve = elliptic.P521()
+		default:
+			return nil, fmt.Errorf("unsupported curve %s", jwk.Crv)
+		}
+		x, err := decodeBigInt(jwk.X)
+		if err != nil {
+			return nil, err
+		}
+		y, err := decodeBigInt(jwk.Y)
+		if err != nil {
+			return nil, err
+		}
+		if !curve.IsOnCurve(x, y) {
+			return nil, fmt.Errorf("point is not on curve %s", jwk.Crv)
+		}
+		return &ecdsa.PublicKey{Curve: curve, X: x, Y: y}, nil
+	default:
+		return nil, fmt.Errorf("unsupported key type %s", jwk.Kty)
+	}
+}
+
+func decodeBigInt(value string) (*big.Int, error) {
+	decoded, err := base64.RawURLEncoding.DecodeString(value)
+	if err != nil {
+		return nil, err
+	}
+	return new(big.Int).SetBytes(decoded), nil
+}
+
+func decodeSegment(segment string, v interface{}) error {
+	decoded, err := base64.RawURLEncoding.DecodeString(segment)
+	if err != nil {
+		return err
+	}
+	return json.Unmarshal(decoded, v)
+}
+
+// stringList converts a claim that can be either
+// a string or a list of strings into a slice
+func stringList(claim interface{}) []string {
+	switch v := claim.(type) {
+	case string:
+		return []string{v}
+	case []interface{}:
+		values := []string{}
+		for _, item := range v {
+			if s, ok := item.(string); ok {
+				values = append(values, s)
+			}
+		}
+		return values
+	default:
+		return nil
+	}
+}
@@ -0,0 +1,133 @@
+package tapsync
+
+import (
+	"crypto"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"math/big"
+	"net/http"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func encodeSegment(t *testing.T, v interface{}) string {
+	content, err := json.Marshal(v)
+	require.NoError(t, err)
+	return base64.RawURLEncoding.EncodeToString(content)
+}
+
+func signRS256(t *testing.T, key *rsa.PrivateKey, kid string, claims map[string]interface{}) string {
+	signed := encodeSegment(t, map[string]string{"alg": "RS256", "kid": kid}) + "." + encodeSegment(t, claims)
+	digest := sha256.Sum256([]byte(signed))
+	signature, err := rsa.SignPKCS1v15(rand.Reader, key, crypto.SHA256, digest[:])
+	require.NoError(t, err)
+	return signed + "." + base64.RawURLEncoding.EncodeToString(signature)
+}
+
+func signES256(t *testing.T, key *ecdsa.PrivateKey, kid string, claims map[string]interface{}) string {
+	signed := encodeSegment(t, map[string]string{"alg": "ES256", "kid": kid}) + "." + encodeSegment(t, claims)
+	digest := sha256.Sum256([]byte(signed))
+	r, s, err := ecdsa.Sign(rand.Reader, key, digest[:])
+	require.NoError(t, err)
+	signature := make([]byte, 64)
+	r.FillBytes(signature[:32])
+	s.FillBytes(signature[32:])
+	return signed + "." + base64.RawURLEncoding.EncodeToString(signature)
+}
+
+func newTestJWTAuthenticator(t *testing.T, rsaKey *rsa.PrivateKey, ecKey *ecdsa.PrivateKey) *JWTAuthenticator {
+	encode := func(i *big.Int) string { return base64.RawURLEncoding.EncodeToString(i.Bytes()) }
+	jwks := fmt.Sprintf(`{"keys": [
+		{"kty": "RSA", "kid": "rsa", "use": "sig", "n": "%s", "e": "%s"},
+		{"kty": "EC", "kid": "ec", "crv": "P-256", "x": "%s", "y": "%s"}
+	]}`,
+		encode(rsaKey.N), encode(big.NewInt(int64(rsaKey.E))),
+		encode(ecKey.X), encode(ecKey.Y),
+	)
+	fname := writeTestFile(t, "jwks.json", jwks)
+	authenticator, err := NewJWTAuthenticator(fname, "https://auth.alerce.online", "ataps", "")
+	require.NoError(t, err)
+	return authenticator
+}
+
+func authenticateToken(authenticator *JWTAuthenticator, token string) (*Identity, error) {
+	req, _ := http.NewRequest("POST", "/sync", nil)
+	req.Header.Add("Authorization", "Bearer "+token)
+	return authenticator.Authenticate(req)
+}
+
+func TestJWTAuthenticator(t *testing.T) {
+	rsaKey, err := rsa.GenerateKey(rand.Reader, 2048)
+	require.NoError(t, err)
+	ecKey, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	require.NoError(t, err)
+	authenticator := newTestJWTAuthenticator(t, rsaKey, ecKey)
+	now := time.Now()
+	claims := func() map[string]interface{} {
+		return map[string]interface{}{
+			"sub":    "alice",
+			"iss":    "https://auth.alerce.online",
+			"aud":    []string{"ataps", "other"},
+			"exp":    now.Add(time.Hour).Unix(),
+			"groups": []string{"collaboration"},
+		}
+	}
+
+	t.Run("RS256", func(t *testing.T) {
+		identity, err := authenticateToken(authenticator, signRS256(t, rsaKey, "rsa", claims()))
+		require.NoError(t, err)
+		assert.Equal(t, "jwt:alice", identity.Key())
+		assert.Equal(t, []string{"collaboration"}, identity.Groups)
+	})
+	t.Run("ES256", func(t *testing.T) {
+		identity, err := authenticateToken(authenticator, signES256(t, ecKey, "ec", claims()))
+		require.NoError(t, err)
+		assert.Equal(t, "alice", identity.Name)
+	})
+	t.Run("WrongKey", func(t *testing.T) {
+		otherKey, err := rsa.GenerateKey(rand.Reader, 2048)
+		require.NoError(t, err)
+		_, err = authenticateToken(authenticator, signRS256(t, otherKey, "rsa", claims()))
+		assert.Error(t, err)
+	})
+	t.Run("UnknownKey", func(t *testing.T) {
+		_, err := authenticateToken(authenticator, signRS256(t, rsaKey, "unknown", claims()))
+		assert.ErrorContains(t, err, "unknown key")
+	})
+	t.Run("Expired", func(t *testing.T) {
+		expired := claims()
+		expired["exp"] = now.Add(-time.Hour).Unix()
+		_, err := authenticateToken(authenticator, signRS256(t, rsaKey, "rsa", expired))
+		assert.ErrorContains(t, err, "token expired")
+	})
+	t.Run("WrongAudience", func(t *testing.T) {
+		wrong := claims()
+		wrong["aud"] = "other"
+		_, err := authenticateToken(authenticator, signRS256(t, rsaKey, "rsa", wrong))
+		assert.ErrorContains(t, err, "unexpected audience")
+	})
+	t.Run("WrongIssuer", func(t *testing.T) {
+		wrong := claims()
+		wrong["iss"] = "https://example.com"
+		_, err := authenticateToken(authenticator, signRS256(t, rsaKey, "rsa", wrong))
+		assert.ErrorContains(t, err, "unexpected issuer")
+	})
+	t.Run("AlgorithmMismatch", func(t *testing.T) {
+		// a token signed with the EC key but pointing to the RSA key
+		_, err := authenticateToken(authenticator, signES256(t, ecKey, "rsa", claims()))
+		assert.Error(t, err)
+	})
+	t.Run("NotAJWT", func(t *testing.T) {
+		_, err := authenticateToken(authenticator, "static-token")
+		assert.ErrorIs(t, err, errNoCredentials)
+	})
+}
@@ -0,0 +1,172 @@
+package tapsync
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"io"
+	"log/slog"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/gin-gonic/gin"
+)
+
+// RequestIDHeader is the header used to receive and return
+// the identifier of a request
+const RequestIDHeader = "X-Request-ID"
+
+// maxRequestIDLength is the longest request ID accepted from clients
+const maxRequestIDLength = 128
+
+type requestIDContextKey struct{}
+
+// WithRequestID returns a copy of the context carrying the request ID
+func WithRequestID(ctx context.Context, requestID string) context.Context {
+	return context.WithValue(ctx, requestIDContextKey{}, requestID)
+}
+
+// RequestID returns the request ID carried by the context, if any
+func RequestID(ctx context.Context) string {
+	requestID, _ := ctx.Value(requestIDContextKey{}).(string)
+	return requestID
+}
+
+// contextHandler is a slog.Handler that adds
+// the request ID of the context to every record
+type contextHandler struct {
+	slog.Handler
+}
+
+func (h contextHandler) Handle(ctx context.Context, record slog.Record) error {
+	if requestID := RequestID(ctx); requestID != "" {
+		record.AddAttrs(slog.String("request_id", requestID))
+	}
+	return h.Handler.Handle(ctx, record)
+}
+
+func (h contextHandler) WithAttrs(attrs []slog.Attr) slog.Handler {
+	return contextHandler{h.Handler.WithAttrs(attrs)}
+}
+
+func (h contextHandler) WithGroup(name string) slog.Handler {
+	return contextHandler{h.Handler.WithGroup(name)}
+}
+
+// NewLogger creates a JSON logger that writes to w and adds
+// the request ID of the context to the records logged with it.
+// The level can be debug, info, warn or error, and defaults to info.
+func NewLogger(w io.Writer, level string) *slog.Logger {
+	var logLevel slog.Level
+	if err := logLevel.UnmarshalText([]byte(level)); err != nil {
+		logLevel = slog.LevelInfo
+	}
+	handler := slog.NewJSONHandler(w, &slog.HandlerOptions{Level: logLevel})
+	return slog.New(contextHandler{handler})
+}
+
+// RequestIDMiddleware assigns an ID to each request, reusing the one sent
+// by the client in the X-Request-ID header when it is valid.
+// The ID is returned in the response headers and stored in the
+// request context, so it is included in every log record of the request.
+func RequestIDMiddleware() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		requestID := c.GetHeader(RequestIDHeader)
+		if !validRequestID(requestID) {
+			requestID = newRequestID()
+		}
+		c.Header(RequestIDHeader, requestID)
+		c.Request = c.Request.WithContext(WithRequestID(c.Request.Context(), requestID))
+		c.Next()
+	}
+}
+
+// LoggerMiddleware logs a record for each request served,
+// replacing the default gin logger
+func LoggerMiddleware(logger *slog.Logger) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		start := time.Now()
+		path := c.Request.URL.Path
+		c.Next()
+		status := c.Writer.Status()
+		level := slog.LevelInfo
+		if status >= http.StatusInternalServerError {
+			level = slog.LevelError
+		}
+		logger.LogAttrs(c.Request.Context(), level, "request",
+			slog.String("method", c.Request.Method),
+			slog.String("path", path),
+			slog.Int("status", status),
+			slog.Duration("latency", time.Since(start)),
+			slog.Int("bytes", c.Writer.Size()),
+			slog.String("client_ip", c.ClientIP()),
+		)
+	}
+}
+
+// RecoveryMiddleware recovers from panics in the handlers,
+// logging them and answering with 500 Internal Server Error
+func RecoveryMiddleware(logger *slog.Logger) gin.HandlerFunc {
+	return gin.CustomRecoveryWithWriter(io.Discard, func(c *gin.Context, recovered any) {
+		logger.ErrorContext(c.Request.Context(), "panic recovered", slog.Any("error", recovered))
+		c.AbortWithStatus(http.StatusInternalServerError)
+	})
+}
+
+// AuditMiddleware logs an audit record for each query,
+// with the user, language, normalized query, format,
+// number of rows, duration and outcome of the request
+func AuditMiddleware(logger *slog.Logger) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		start := time.Now()
+		c.Next()
+		user := "anonymous"
+		if identity := getIdentity(c); identity != nil {
+			user = identity.Name
+		}
+		status := c.Writer.Status()
+		attrs := []slog.Attr{slog.Bool("audit", true)}
+		outcome := "ok"
+		if status >= http.StatusBadRequest {
+			outcome = errorCategory(c, status)
+			if err := c.Errors.Last(); err != nil {
+				attrs = append(attrs, slog.String("error", err.Error()))
+			}
+		}
+		logger.LogAttrs(c.Request.Context(), slog.LevelInfo, "query", append(attrs,
+			slog.String("user", user),
+			slog.String("lang", c.PostForm("LANG")),
+			slog.String("query", normalizeQuery(c.PostForm("QUERY"))),
+			slog.String("format", c.GetString(formatKey)),
+			slog.Int("rows", c.GetInt(rowCountKey)),
+			slog.Duration("duration", time.Since(start)),
+			slog.Int("status", status),
+			slog.String("outcome", outcome),
+		)...)
+	}
+}
+
+// normalizeQuery collapses the whitespace of a query
+// so it fits in a single log line
+func normalizeQuery(query string) string {
+	return strings.Join(strings.Fields(query), " ")
+}
+
+func validRequestID(requestID string) bool {
+	if requestID == "" || len(requestID) > maxRequestIDLength {
+		return false
+	}
+	for _, r := range requestID {
+		if r < '!' || r > '~' {
+			return false
+		}
+	}
+	return true
+}
+
+func newRequestID() string {
+	b := make([]byte, 16)
+	rand.Read(b)
+	return hex.EncodeToString(b)
+}
@@ -0,0 +1,161 @@
+package tapsync
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"log/slog"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/gin-gonic/gin"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func decodeLogRecords(t *testing.T, buffer *bytes.Buffer) []map[string]interface{} {
+	records := []map[string]interface{}{}
+	for _, line := range strings.Split(strings.TrimSpace(buffer.String()), "\n") {
+		record := map[string]interface{}{}
+		require.NoError(t, json.Unmarshal([]byte(line), &record))
+		records = append(records, record)
+	}
+	return records
+}
+
+func newLoggedRouter(logger *slog.Logger, handler gin.HandlerFunc) *gin.Engine {
+	gin.SetMode(gin.TestMode)
+	router := gin.New()
+	router.Use(RequestIDMiddleware(), LoggerMiddleware(logger), RecoveryMiddleware(logger))
+	router.POST("/sync", AuditMiddleware(logger), handler)
+	return router
+}
+
+func TestRequestIDMiddleware(t *testing.T) {
+	var buffer bytes.Buffer
+	logger := NewLogger(&buffer, "info")
+	router := newLoggedRouter(logger, func(c *gin.Context) {
+		c.String(http.StatusOK, RequestID(c.Request.Context()))
+	})
+
+	w := httptest.NewRecorder()
+	req, _ := http.NewRequest("POST", "/sync", nil)
+	req.Header.Add(RequestIDHeader, "my-request")
+	router.ServeHTTP(w, req)
+	assert.Equal(t, "my-request", w.Body.String())
+	assert.Equal(t, "my-request", w.Header().Get(RequestIDHeader))
+
+	w = httptest.NewRecorder()
+	req, _ = http.NewRequest("POST", "/sync", nil)
+	req.Header.Add(RequestIDHeader, "invalid request id")
+	router.ServeHTTP(w, req)
+	assert.Len(t, w.Body.String(), 32)
+	assert.Equal(t, w.Body.String(), w.Header().Get(RequestIDHeader))
+}
+
+func TestAuditMiddleware(t *testing.T) {
+	var buffer bytes.Buffer
+	logger := NewLogger(&buffer, "info")
+	router := newLoggedRouter(logger, func(c *gin.Context) {
+		c.Set(identityKey, &Identity{Name: "alice"})
+		c.Set(formatKey, "csv")
+		c.Set(rowCountKey, 3)
+		c.String(http.StatusOK, "ok")
+	})
+	w := httptest.NewRecorder()
+	req, _ := http.NewRequest("POST", "/sync", strings.NewReader("LANG=PSQL&QUERY=SELECT *\n  FROM object"))
+	req.Header.Add("Content-Type", "application/x-www-form-urlencoded")
+	req.Header.Add(RequestIDHeader, "audited")
+	router.ServeHTTP(w, req)
+
+	records := decodeLogRecords(t, &buffer)
+	require.Len(t, records, 2)
+	audit := records[0]
+	assert.Equal(t, "query", audit["msg"])
+	assert.Equal(t, true, audit["audit"])
+	assert.Equal(t, "audited", audit["request_id"])
+	assert.Equal(t, "alice", audit["user"])
+	assert.Equal(t, "PSQL", audit["lang"])
+	assert.Equal(t, "SELECT * FROM object", audit["query"])
+	assert.Equal(t, "csv", audit["format"])
+	assert.Equal(t, 3.0, audit["rows"])
+	assert.Equal(t, "ok", audit["outcome"])
+	request := records[1]
+	assert.Equal(t, "request", request["msg"])
+	assert.Equal(t, "audited", request["request_id"])
+	assert.Equal(t, 200.0, request["status"])
+}
+
+func TestAuditMiddlewareError(t *testing.T) {
+	var buffer bytes.Buffer
+	logger := NewLogger(&buffer, "info")
+	router := newLoggedRouter(logger, func(c *gin.Context) {
+		c.Error(errTestQuery)
+		c.Set(errorCategoryKey, errorCategoryQuery)
+		c.String(http.StatusInternalServerError, "error")
+	})
+	w := httptest.NewRecorder()
+	req, _ := http.NewRequest("POST", "/sync", strings.NewReader("LANG=PSQL&QUERY=SELECT * FROM dontexist"))
+	req.Header.Add("Content-Type", "application/x-www-form-urlencoded")
+	router.ServeHTTP(w, req)
+
+	records := decodeLogRecords(t, &buffer)
+	require.Len(t, records, 2)
+	assert.Equal(t, "anonymous", records[0]["user"])
+	assert.Equal(t, "query", records[0]["outcome"])
+	assert.Equal(t, errTestQuery.Error(), records[0]["error"])
+	assert.Equal(t, "ERROR", records[1]["level"])
+}
+
+func TestRecoveryMiddleware(t *testing.T) {
+	var buffer bytes.Buffer
+	logger := NewLogger(&buffer, "info")
+	router := newLoggedRouter(logger, func(c *gin.Context) {
+		panic("boom")
+	})
+	w := httptest.NewRecorder()
+	req, _ := http.NewRequest("POST", "/sync", nil)
+	router.ServeHTTP(w, req)
+	assert.Equal(t, http.StatusInternalServerError, w.Code)
+	assert.Contains(t, buffer.String(), `"msg":"panic recovered"`)
+}
+
+func TestNewLoggerLevel(t *testing.T) {
+	var buffer bytes.Buffer
+	logger := NewLogger(&buffer, "warn")
+	logger.Info("hidden")
+	logger.Warn("shown")
+	assert.NotContains(t, buffer.String(), "hidden")
+	assert.Contains(t, buffer.String(), "shown")
+}
+
+var errTestQuery = errors.New(`relation "dontexist" does not exist`)
+
+func TestAuditMiddlewareUnauthorized(t *testing.T) {
+	fname := writeTestFile(t, "tokens.json", `{"`+tokenDigest("secret")+`": {"name": "alice"}}`)
+	authenticators, err := NewAuthenticators(AuthConfig{TokensFile: fname})
+	require.NoError(t, err)
+	var buffer bytes.Buffer
+	logger := NewLogger(&buffer, "info")
+	router := gin.New()
+	router.Use(RequestIDMiddleware(), LoggerMiddleware(logger))
+	router.POST("/sync", AuditMiddleware(logger), AuthenticationMiddleware(authenticators), func(c *gin.Context) {
+		c.String(http.StatusOK, "ok")
+	})
+	w := httptest.NewRecorder()
+	req, _ := http.NewRequest("POST", "/sync", strings.NewReader("LANG=PSQL&QUERY=SELECT 1"))
+	req.Header.Add("Content-Type", "application/x-www-form-urlencoded")
+	req.Header.Add("Authorization", "Bearer wrong")
+	router.ServeHTTP(w, req)
+
+	assert.Equal(t, http.StatusUnauthorized, w.Code)
+	records := decodeLogRecords(t, &buffer)
+	require.Len(t, records, 2)
+	assert.Equal(t, true, records[0]["audit"])
+	assert.Equal(t, "anonymous", records[0]["user"])
+	assert.Equal(t, "SELECT 1", records[0]["query"])
+	assert.Equal(t, "unauthorized", records[0]["outcome"])
+	assert.Equal(t, "Invalid token", records[0]["error"])
+}
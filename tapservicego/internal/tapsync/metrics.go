@@ -0,0 +1,168 @@
+package tapsync
+
+import (
+	"database/sql"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/collectors"
+	"github.com/prometheus/client_golang/prometheus/promhttp"
+)
+
+// gin context keys used by the handlers to describe
+// the query to the metrics middleware
+const (
+	langKey          = "ataps.lang"
+	formatKey        = "ataps.format"
+	errorCategoryKey = "ataps.errorCategory"
+)
+
+// error categories for requests that fail after being validated
+const (
+	errorCategoryQuery  = "query"
+	errorCategoryFormat = "format"
+)
+
+// Metrics holds the Prometheus collectors of the service.
+// Each service has its own registry so several services
+// can live in the same process.
+type Metrics struct {
+	registry      *prometheus.Registry
+	queries       *prometheus.CounterVec
+	queryDuration *prometheus.HistogramVec
+	rowsReturned  *prometheus.HistogramVec
+	bytesWritten  *prometheus.CounterVec
+	errors        *prometheus.CounterVec
+	inFlight      prometheus.Gauge
+	cache         *prometheus.CounterVec
+}
+
+// NewMetrics creates the collectors of the service, including
+// the connection pool statistics of the database
+func NewMetrics(db *sql.DB) *Metrics {
+	m := &Metrics{
+		registry: prometheus.NewRegistry(),
+		queries: prometheus.NewCounterVec(prometheus.CounterOpts{
+			Name: "ataps_queries_total",
+			Help: "Number of queries by language, format and HTTP status code.",
+		}, []string{"lang", "format", "code"}),
+		queryDuration: prometheus.NewHistogramVec(prometheus.HistogramOpts{
+			Name:    "ataps_query_duration_seconds",
+			Help:    "Time to execute a query and write its response.",
+			Buckets: prometheus.ExponentialBuckets(0.005, 4, 10),
+		}, []string{"lang", "format"}),
+		rowsReturned: prometheus.NewHistogramVec(prometheus.HistogramOpts{
+			Name:    "ataps_rows_returned",
+			Help:    "Number of rows returned by successful queries.",
+			Buckets: prometheus.ExponentialBuckets(1, 10, 8),
+		}, []string{"format"}),
+		bytesWritten: prometheus.NewCounterVec(prometheus.CounterOpts{
+			Name: "ataps_response_bytes_total",
+			Help: "Number of bytes written in query responses.",
+		}, []string{"format"}),
+		errors: prometheus.NewCounterVec(prometheus.CounterOpts{
+			Name: "ataps_errors_total",
+			Help: "Number of failed requests by error category.",
+		}, []string{"category"}),
+		inFlight: prometheus.NewGauge(prometheus.GaugeOpts{
+			Name: "ataps_requests_in_flight",
+			Help: "Number of requests being served.",
+		}),
+		cache: prometheus.NewCounterVec(prometheus.CounterOpts{
+			Name: "ataps_cache_requests_total",
+			Help: "Number of queries by result cache outcome: hit, miss or bypass.",
+		}, []string{"result"}),
+	}
+	m.registry.MustRegister(
+		m.queries,
+		m.queryDuration,
+		m.rowsReturned,
+		m.bytesWritten,
+		m.errors,
+		m.inFlight,
+		m.cache,
+		collectors.NewDBStatsCollector(db, "ataps"),
+		collectors.NewGoCollector(),
+		collectors.NewProcessCollector(collectors.ProcessCollectorOpts{}),
+	)
+	return m
+}
+
+// RegisterBackends adds the connection pool statistics of the
+// databases of the backends, other than the default primary,
+// which is registered by NewMetrics
+func (m *Metrics) RegisterBackends(backends *Backends) {
+	for _, backend := range backends.All() {
+		if backend != backends.Default() {
+			m.registry.MustRegister(collectors.NewDBStatsCollector(backend.Primary, backend.Name))
+		}
+		for _, r := range backend.replicas {
+			m.registry.MustRegister(collectors.NewDBStatsCollector(r.db, r.name))
+		}
+	}
+}
+
+// CacheResult counts a query served by the result cache ("hit"),
+// stored in it ("miss"), or that can not be cached ("bypass")
+func (m *Metrics) CacheResult(result string) {
+	m.cache.WithLabelValues(result).Inc()
+}
+
+// Handler serves the metrics in the Prometheus exposition format
+func (m *Metrics) Handler() http.Handler {
+	return promhttp.HandlerFor(m.registry, promhttp.HandlerOpts{Registry: m.registry})
+}
+
+// Middleware records the metrics of each request.
+// The language and format are read from the gin context, where
+// the handlers set them once validated, so the labels can only take
+// known values. Requests that fail before validation use "unknown".
+func (m *Metrics) Middleware() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		m.inFlight.Inc()
+		defer m.inFlight.Dec()
+		start := time.Now()
+		c.Next()
+		lang := c.GetString(langKey)
+		if lang == "" {
+			lang = "unknown"
+		}
+		format := c.GetString(formatKey)
+		if format == "" {
+			format = "unknown"
+		}
+		code := c.Writer.Status()
+		m.queries.WithLabelValues(lang, format, strconv.Itoa(code)).Inc()
+		m.queryDuration.WithLabelValues(lang, format).Observe(time.Since(start).Seconds())
+		if c.Writer.Size() > 0 {
+			m.bytesWritten.WithLabelValues(format).Add(float64(c.Writer.Size()))
+		}
+		if code < http.StatusBadRequest {
+			m.rowsReturned.WithLabelValues(format).Observe(float64(c.GetInt(rowCountKey)))
+			return
+		}
+		m.errors.WithLabelValues(errorCategory(c, code)).Inc()
+	}
+}
+
+// errorCategory classifies a failed request by its status code,
+// or by the category set by the handler for internal errors
+func errorCategory(c *gin.Context, code int) string {
+	switch code {
+	case http.StatusBadRequest:
+		return "bad_request"
+	case http.StatusUnauthorized:
+		return "unauthorized"
+	case http.StatusForbidden:
+		return "forbidden"
+	case http.StatusTooManyRequests:
+		return "rate_limited"
+	}
+	if category := c.GetString(errorCategoryKey); category != "" {
+		return category
+	}
+	return "internal"
+}
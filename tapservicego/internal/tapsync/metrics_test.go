@@ -0,0 +1,83 @@
+package tapsync
+
+import (
+	"database/sql"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/gin-gonic/gin"
+	"github.com/prometheus/client_golang/prometheus/testutil"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func newTestMetrics(t *testing.T) *Metrics {
+	// the connection is never used, only its pool statistics
+	db, err := sql.Open("pgx", "host=localhost")
+	require.NoError(t, err)
+	t.Cleanup(func() { db.Close() })
+	return NewMetrics(db)
+}
+
+func TestMetricsMiddleware(t *testing.T) {
+	metrics := newTestMetrics(t)
+	gin.SetMode(gin.TestMode)
+	router := gin.New()
+	router.POST("/sync", metrics.Middleware(), func(c *gin.Context) {
+		if c.Query("fail") != "" {
+			c.Set(langKey, "PSQL")
+			c.Set(formatKey, "csv")
+			c.Set(errorCategoryKey, errorCategoryQuery)
+			c.String(http.StatusInternalServerError, "error")
+			return
+		}
+		if c.Query("invalid") != "" {
+			c.String(http.StatusBadRequest, "invalid")
+			return
+		}
+		c.Set(langKey, "PSQL")
+		c.Set(formatKey, "csv")
+		c.Set(rowCountKey, 2)
+		c.String(http.StatusOK, "a\n1\n2\n")
+	})
+	router.GET("/metrics", gin.WrapH(metrics.Handler()))
+	send := func(url string) {
+		w := httptest.NewRecorder()
+		req, _ := http.NewRequest("POST", url, nil)
+		router.ServeHTTP(w, req)
+	}
+
+	send("/sync")
+	send("/sync")
+	send("/sync?fail=true")
+	send("/sync?invalid=true")
+
+	assert.Equal(t, 2.0, testutil.ToFloat64(metrics.queries.WithLabelValues("PSQL", "csv", "200")))
+	assert.Equal(t, 1.0, testutil.ToFloat64(metrics.queries.WithLabelValues("PSQL", "csv", "500")))
+	assert.Equal(t, 1.0, testutil.ToFloat64(metrics.queries.WithLabelValues("unknown", "unknown", "400")))
+	assert.Equal(t, 12.0+5.0, testutil.ToFloat64(metrics.bytesWritten.WithLabelValues("csv")))
+	assert.Equal(t, 1.0, testutil.ToFloat64(metrics.errors.WithLabelValues("query")))
+	assert.Equal(t, 1.0, testutil.ToFloat64(metrics.errors.WithLabelValues("bad_request")))
+	assert.Equal(t, 0.0, testutil.ToFloat64(metrics.inFlight))
+
+	w := httptest.NewRecorder()
+	req, _ := http.NewRequest("GET", "/metrics", nil)
+	router.ServeHTTP(w, req)
+	assert.Equal(t, http.StatusOK, w.Code)
+	body := w.Body.String()
+	assert.Contains(t, body, `ataps_rows_returned_sum{format="csv"} 4`)
+	assert.Contains(t, body, `ataps_query_duration_seconds_count{format="csv",lang="PSQL"} 3`)
+	assert.Contains(t, body, `go_sql_max_open_connections{db_name="ataps"}`)
+	assert.Contains(t, body, "ataps_requests_in_flight 0")
+}
+
+func TestErrorCategory(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+	c, _ := gin.CreateTestContext(httptest.NewRecorder())
+	assert.Equal(t, "rate_limited", errorCategory(c, http.StatusTooManyRequests))
+	assert.Equal(t, "forbidden", errorCategory(c, http.StatusForbidden))
+	assert.Equal(t, "internal", errorCategory(c, http.StatusInternalServerError))
+	c.Set(errorCategoryKey, errorCategoryFormat)
+	assert.Equal(t, "format", errorCategory(c, http.StatusInternalServerError))
+}
@@ -0,0 +1,276 @@
+package tapsync
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+	"io"
+	"log/slog"
+	"slices"
+	"strconv"
+	"strings"
+
+	"github.com/jackc/pgx/v5"
+	"github.com/jackc/pgx/v5/pgconn"
+	"github.com/jackc/pgx/v5/pgtype"
+	"github.com/jackc/pgx/v5/stdlib"
+)
+
+// resultFormats asks for the types with a typed scanner in the
+// binary format, and for every other type in the text format
+var resultFormats = pgx.QueryResultFormatsByOID{
+	pgtype.BoolOID:        pgx.BinaryFormatCode,
+	pgtype.ByteaOID:       pgx.BinaryFormatCode,
+	pgtype.Float4OID:      pgx.BinaryFormatCode,
+	pgtype.Float8OID:      pgx.BinaryFormatCode,
+	pgtype.Int2OID:        pgx.BinaryFormatCode,
+	pgtype.Int4OID:        pgx.BinaryFormatCode,
+	pgtype.Int8OID:        pgx.BinaryFormatCode,
+	pgtype.DateOID:        pgx.BinaryFormatCode,
+	pgtype.TimestampOID:   pgx.BinaryFormatCode,
+	pgtype.TimestamptzOID: pgx.BinaryFormatCode,
+}
+
+// valueFunc decodes a non NULL value of a column
+type valueFunc func(src []byte) (interface{}, error)
+
+// HandlePgxQuery is like HandleSQLQueryContext, but the rows are read
+// with pgx directly, on a connection of the database/sql pool.
+// Each column is decoded by a scanner for its type, avoiding the
+// conversions and copies of database/sql, and the values have the
+// same Go types HandleSQLQueryContext returns, so both can be used
+// with the parsers.
+func HandlePgxQuery(ctx context.Context, query string, db *sql.DB, opts ...QueryOption) ([]map[string]interface{}, error) {
+	options := queryOptions{rowLimit: -1}
+	for _, opt := range opts {
+		opt(&options)
+	}
+	var results []map[string]interface{}
+	var fields []pgconn.FieldDescription
+	err := withPgxConn(ctx, db, func(conn *pgx.Conn) error {
+		// the query is cancelled when the rows are limited,
+		// so closing them does not read the rows left
+		queryCtx, cancel := context.WithCancel(ctx)
+		defer cancel()
+		rows, err := conn.Query(queryCtx, query, resultFormats)
+		if err != nil {
+			return err
+		}
+		defer rows.Close()
+		// the descriptions of the fields are reused by the connection
+		fields = slices.Clone(rows.FieldDescriptions())
+		valueFuncs := make([]valueFunc, len(fields))
+		for i, field := range fields {
+			valueFuncs[i] = newValueFunc(conn.TypeMap(), field)
+			if options.columnTypes != nil {
+				options.columnTypes[field.Name] = databaseTypeName(conn.TypeMap(), field.DataTypeOID)
+			}
+		}
+		for (options.rowLimit < 0 || len(results) < options.rowLimit) && rows.Next() {
+			rowMap := make(map[string]interface{}, len(fields))
+			for i, src := range rows.RawValues() {
+				if src == nil {
+					rowMap[fields[i].Name] = nil
+					continue
+				}
+				value, err := valueFuncs[i](src)
+				if err != nil {
+					slog.ErrorContext(ctx, "Error scanning row", "column", fields[i].Name, "error", err)
+					return err
+				}
+				rowMap[fields[i].Name] = value
+			}
+			results = append(results, rowMap)
+		}
+		if options.limited(len(results)) {
+			cancel()
+			return nil
+		}
+		rows.Close()
+		return rows.Err()
+	})
+	if err != nil {
+		return nil, err
+	}
+	// the connection of a cancelled query is closed,
+	// so the origins are looked up on another one
+	if options.columnOrigins != nil {
+		err := withPgxConn(ctx, db, func(conn *pgx.Conn) error {
+			return resolveColumnOrigins(ctx, conn, fields, options.columnOrigins)
+		})
+		if err != nil {
+			return nil, err
+		}
+	}
+	return results, nil
+}
+
+// describeColumnOrigins stores in origins the columns of the tables
+// the columns of the query are read from, describing the query as
+// an unnamed prepared statement without running it
+func describeColumnOrigins(ctx context.Context, query string, db *sql.DB, origins map[string]ColumnOrigin) error {
+	return withPgxConn(ctx, db, func(conn *pgx.Conn) error {
+		description, err := conn.PgConn().Prepare(ctx, "", query, nil)
+		if err != nil {
+			return err
+		}
+		return resolveColumnOrigins(ctx, conn, description.Fields, origins)
+	})
+}
+
+// resolveColumnOrigins stores in origins the names of the table and the
+// column of each field read from a table, looking them up in the catalog
+func resolveColumnOrigins(ctx context.Context, conn *pgx.Conn, fields []pgconn.FieldDescription, origins map[string]ColumnOrigin) error {
+	var tables []uint32
+	var columns []int16
+	for _, field := range fields {
+		if field.TableOID != 0 && field.TableAttributeNumber > 0 {
+			tables = append(tables, field.TableOID)
+			columns = append(columns, int16(field.TableAttributeNumber))
+		}
+	}
+	if len(tables) == 0 {
+		return nil
+	}
+	rows, err := conn.Query(ctx, `SELECT a.attrelid, a.attnum, n.nspname, c.relname, a.attname
+		FROM unnest($1::oid[], $2::int2[]) AS f(relid, attnum)
+		JOIN pg_attribute a ON a.attrelid = f.relid AND a.attnum = f.attnum
+		JOIN pg_class c ON c.oid = a.attrelid
+		JOIN pg_namespace n ON n.oid = c.relnamespace`, tables, columns)
+	if err != nil {
+		return err
+	}
+	defer rows.Close()
+	type attribute struct {
+		table  uint32
+		column int16
+	}
+	found := map[attribute]ColumnOrigin{}
+	for rows.Next() {
+		var key attribute
+		var schema, table, column string
+		if err := rows.Scan(&key.table, &key.column, &schema, &table, &column); err != nil {
+			return err
+		}
+		found[key] = ColumnOrigin{Table: schema + "." + table, Column: column}
+	}
+	if err := rows.Err(); err != nil {
+		return err
+	}
+	for _, field := range fields {
+		if origin, ok := found[attribute{field.TableOID, int16(field.TableAttributeNumber)}]; ok {
+			origins[field.Name] = origin
+		}
+	}
+	return nil
+}
+
+// errNotSingleStatement is returned by CopyCSV for the queries that
+// are not a single statement, which can not be wrapped by COPY
+var errNotSingleStatement = errors.New("Query must be a single statement")
+
+// CopyCSV writes the result of the query to w as CSV with a header,
+// using COPY (query) TO STDOUT, so the rows are streamed by PostgreSQL
+// without being decoded. It returns the number of rows written.
+// Unlike parsers.ParseCSV the columns keep the order of the query,
+// and values are formatted by PostgreSQL.
+//
+// COPY is sent with the simple query protocol, which runs every
+// statement of its text, so the query is first prepared to check that
+// it is a single statement, and copied in a read only transaction.
+func CopyCSV(ctx context.Context, query string, db *sql.DB, w io.Writer) (int64, error) {
+	query = strings.TrimRight(strings.TrimSpace(query), "; \t\n\r")
+	var rows int64
+	err := withPgxConn(ctx, db, func(conn *pgx.Conn) error {
+		if _, err := conn.PgConn().Prepare(ctx, "", query, nil); err != nil {
+			var pgErr *pgconn.PgError
+			// several statements are a syntax error of a prepared statement
+			if errors.As(err, &pgErr) && pgErr.Code == "42601" {
+				return fmt.Errorf("%w: %s", errNotSingleStatement, pgErr.Message)
+			}
+			return err
+		}
+		tx, err := conn.BeginTx(ctx, pgx.TxOptions{AccessMode: pgx.ReadOnly})
+		if err != nil {
+			return err
+		}
+		defer tx.Rollback(ctx)
+		tag, err := tx.Conn().PgConn().CopyTo(ctx, w, "COPY ("+query+") TO STDOUT WITH (FORMAT csv, HEADER)")
+		rows = tag.RowsAffected()
+		return err
+	})
+	return rows, err
+}
+
+// withPgxConn runs f with the pgx connection
+// of a connection taken from the database/sql pool
+func withPgxConn(ctx context.Context, db *sql.DB, f func(conn *pgx.Conn) error) error {
+	conn, err := db.Conn(ctx)
+	if err != nil {
+		return err
+	}
+	defer conn.Close()
+	return conn.Raw(func(driverConn any) error {
+		stdlibConn, ok := driverConn.(*stdlib.Conn)
+		if !ok {
+			return fmt.Errorf("Database driver is not pgx")
+		}
+		return f(stdlibConn.Conn())
+	})
+}
+
+// newValueFunc returns the scanner of a column. It decodes values
+// into the same types as the pgx database/sql driver: integers
+// into int64, floats into float64, dates and timestamps into time.Time,
+// bytea and json into []byte, and other types into strings.
+func newValueFunc(m *pgtype.Map, field pgconn.FieldDescription) valueFunc {
+	switch field.DataTypeOID {
+	case pgtype.BoolOID:
+		return typedValueFunc(m, field, func(d bool) (interface{}, error) { return d, nil })
+	case pgtype.ByteaOID, pgtype.JSONOID, pgtype.JSONBOID:
+		return typedValueFunc(m, field, func(d []byte) (interface{}, error) { return append([]byte(nil), d...), nil })
+	case pgtype.Float4OID:
+		return typedValueFunc(m, field, func(d float32) (interface{}, error) { return float64(d), nil })
+	case pgtype.Float8OID:
+		return typedValueFunc(m, field, func(d float64) (interface{}, error) { return d, nil })
+	case pgtype.Int2OID:
+		return typedValueFunc(m, field, func(d int16) (interface{}, error) { return int64(d), nil })
+	case pgtype.Int4OID:
+		return typedValueFunc(m, field, func(d int32) (interface{}, error) { return int64(d), nil })
+	case pgtype.Int8OID:
+		return typedValueFunc(m, field, func(d int64) (interface{}, error) { return d, nil })
+	case pgtype.CIDOID, pgtype.OIDOID, pgtype.XIDOID:
+		return typedValueFunc(m, field, func(d pgtype.Uint32) (interface{}, error) { return d.Value() })
+	case pgtype.DateOID:
+		return typedValueFunc(m, field, func(d pgtype.Date) (interface{}, error) { return d.Value() })
+	case pgtype.TimestampOID:
+		return typedValueFunc(m, field, func(d pgtype.Timestamp) (interface{}, error) { return d.Value() })
+	case pgtype.TimestamptzOID:
+		return typedValueFunc(m, field, func(d pgtype.Timestamptz) (interface{}, error) { return d.Value() })
+	default:
+		return typedValueFunc(m, field, func(d string) (interface{}, error) { return d, nil })
+	}
+}
+
+// databaseTypeName returns the name of a type
+// like sql.ColumnType.DatabaseTypeName does with pgx
+func databaseTypeName(m *pgtype.Map, oid uint32) string {
+	if t, ok := m.TypeForOID(oid); ok {
+		return strings.ToUpper(t.Name)
+	}
+	return strconv.FormatInt(int64(oid), 10)
+}
+
+// typedValueFunc plans the scan of a column into T once,
+// and then converts each scanned value
+func typedValueFunc[T any](m *pgtype.Map, field pgconn.FieldDescription, convert func(T) (interface{}, error)) valueFunc {
+	var d T
+	plan := m.PlanScan(field.DataTypeOID, field.Format, &d)
+	return func(src []byte) (interface{}, error) {
+		if err := plan.Scan(src, &d); err != nil {
+			return nil, err
+		}
+		return convert(d)
+	}
+}
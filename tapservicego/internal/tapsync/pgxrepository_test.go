@@ -0,0 +1,199 @@
+package tapsync
+
+import (
+	"ataps/internal/testhelpers"
+	"bytes"
+	"context"
+	"database/sql"
+	"io"
+	"os"
+	"testing"
+	"time"
+
+	"github.com/jackc/pgx/v5/pgconn"
+	"github.com/jackc/pgx/v5/pgtype"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestNewValueFunc(t *testing.T) {
+	m := pgtype.NewMap()
+	date := time.Date(2024, 3, 1, 0, 0, 0, 0, time.UTC)
+	timestamp := time.Date(2024, 3, 1, 12, 30, 0, 0, time.UTC)
+	testCases := []struct {
+		name     string
+		oid      uint32
+		value    any
+		expected interface{}
+	}{
+		{"bool", pgtype.BoolOID, true, true},
+		{"int2", pgtype.Int2OID, int16(7), int64(7)},
+		{"int4", pgtype.Int4OID, int32(-7), int64(-7)},
+		{"int8", pgtype.Int8OID, int64(1 << 40), int64(1 << 40)},
+		{"float4", pgtype.Float4OID, float32(1.5), float64(1.5)},
+		{"float8", pgtype.Float8OID, 123.456, 123.456},
+		{"bytea", pgtype.ByteaOID, []byte{1, 2, 3}, []byte{1, 2, 3}},
+		{"date", pgtype.DateOID, date, date},
+		{"timestamp", pgtype.TimestampOID, timestamp, timestamp},
+		{"timestamptz", pgtype.TimestamptzOID, timestamp, timestamp},
+		{"text", pgtype.TextOID, "ZTF20aaelulu", "ZTF20aaelulu"},
+		{"numeric", pgtype.NumericOID, "3.14159", "3.14159"},
+		{"json", pgtype.JSONBOID, map[string]any{"a": 1}, []byte(`{"a":1}`)},
+	}
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			format, ok := resultFormats[tc.oid]
+			if !ok {
+				format = pgtype.TextFormatCode
+			}
+			src, err := m.Encode(tc.oid, format, tc.value, nil)
+			require.NoError(t, err)
+			valueFunc := newValueFunc(m, pgconn.FieldDescription{Name: tc.name, DataTypeOID: tc.oid, Format: format})
+			value, err := valueFunc(src)
+			require.NoError(t, err)
+			if expected, ok := tc.expected.(time.Time); ok {
+				assert.True(t, expected.Equal(value.(time.Time)), value)
+				return
+			}
+			assert.Equal(t, tc.expected, value)
+		})
+	}
+}
+
+func TestNewValueFuncCopiesBytes(t *testing.T) {
+	m := pgtype.NewMap()
+	valueFunc := newValueFunc(m, pgconn.FieldDescription{DataTypeOID: pgtype.ByteaOID, Format: pgtype.BinaryFormatCode})
+	first, err := valueFunc([]byte{1, 2})
+	require.NoError(t, err)
+	_, err = valueFunc([]byte{3, 4})
+	require.NoError(t, err)
+	assert.Equal(t, []byte{1, 2}, first)
+}
+
+func (suite *TapSyncTestSuite) TestHandlePgxQuery() {
+	testhelpers.PopulateDb(suite.DB)
+	defer testhelpers.ClearDataFromTable(suite.DB)
+	queries := []string{
+		"SELECT * FROM test",
+		"SELECT 1::smallint AS a, 2.5::real AS b, 3.5::float8 AS c, 4.25::numeric AS d, NULL::int AS e, true AS f, now()::date AS g, ARRAY[1,2] AS h",
+	}
+	for _, query := range queries {
+		expectedTypes, types := map[string]string{}, map[string]string{}
+		expected, err := HandleSQLQueryContext(context.Background(), query, suite.DB, WithColumnTypes(expectedTypes))
+		suite.Require().NoError(err)
+		result, err := HandlePgxQuery(context.Background(), query, suite.DB, WithColumnTypes(types))
+		suite.Require().NoError(err)
+		suite.Equal(expected, result, query)
+		suite.Equal(expectedTypes, types, query)
+	}
+	types := map[string]string{}
+	_, err := HandlePgxQuery(context.Background(), queries[1], suite.DB, WithColumnTypes(types))
+	suite.Require().NoError(err)
+	suite.Equal("INT2", types["a"])
+	suite.Equal("_INT4", types["h"])
+	result, err := HandlePgxQuery(context.Background(), "SELECT generate_series(1, 10) AS i", suite.DB, WithRowLimit(3))
+	suite.Require().NoError(err)
+	suite.Len(result, 3)
+	_, err = HandlePgxQuery(context.Background(), "SELECT * FROM dontexist", suite.DB)
+	suite.Error(err)
+}
+
+func (suite *TapSyncTestSuite) TestCopyCSV() {
+	testhelpers.PopulateDb(suite.DB)
+	defer testhelpers.ClearDataFromTable(suite.DB)
+	var buffer bytes.Buffer
+	rows, err := CopyCSV(context.Background(), "SELECT name, number FROM test;", suite.DB, &buffer)
+	suite.Require().NoError(err)
+	suite.Equal(int64(1), rows)
+	suite.Equal("name,number\ntest,1\n", buffer.String())
+	_, err = CopyCSV(context.Background(), "SELECT * FROM dontexist", suite.DB, io.Discard)
+	suite.Error(err)
+	_, err = CopyCSV(context.Background(), "SELECT 1) TO STDOUT; DELETE FROM test; COPY (SELECT 1", suite.DB, io.Discard)
+	suite.ErrorIs(err, errNotSingleStatement)
+	_, err = CopyCSV(context.Background(), "INSERT INTO test (name, number) VALUES ('b', 2) RETURNING *", suite.DB, io.Discard)
+	suite.Error(err)
+	rows, err = CopyCSV(context.Background(), "SELECT name, number FROM test", suite.DB, io.Discard)
+	suite.Require().NoError(err)
+	suite.Equal(int64(1), rows)
+}
+
+// benchmarkDB connects to the database of BENCHMARK_DATABASE_URL,
+// skipping the benchmark when it is not set
+func benchmarkDB(b *testing.B) *sql.DB {
+	databaseUrl := os.Getenv("BENCHMARK_DATABASE_URL")
+	if databaseUrl == "" {
+		b.Skip("BENCHMARK_DATABASE_URL is not set")
+	}
+	db, err := GetDB(databaseUrl)
+	require.NoError(b, err)
+	b.Cleanup(func() { db.Close() })
+	return db
+}
+
+// benchmarkQuery is a float heavy query shaped like a detection query
+const benchmarkQuery = `SELECT 'ZTF' || i AS oid, i AS candid, i % 2 AS fid,
+	random() * 360 AS ra, random() * 180 - 90 AS dec, 58000 + random() AS mjd,
+	random() * 20 AS magpsf, random() AS sigmapsf, random()::real AS rb
+	FROM generate_series(1, 10000) AS i`
+
+func BenchmarkHandleSQLQuery(b *testing.B) {
+	db := benchmarkDB(b)
+	b.ReportAllocs()
+	for i := 0; i < b.N; i++ {
+		if _, err := HandleSQLQueryContext(context.Background(), benchmarkQuery, db); err != nil {
+			b.Fatal(err)
+		}
+	}
+}
+
+func BenchmarkHandlePgxQuery(b *testing.B) {
+	db := benchmarkDB(b)
+	b.ReportAllocs()
+	for i := 0; i < b.N; i++ {
+		if _, err := HandlePgxQuery(context.Background(), benchmarkQuery, db); err != nil {
+			b.Fatal(err)
+		}
+	}
+}
+
+func BenchmarkCopyCSV(b *testing.B) {
+	db := benchmarkDB(b)
+	b.ReportAllocs()
+	for i := 0; i < b.N; i++ {
+		if _, err := CopyCSV(context.Background(), benchmarkQuery, db, io.Discard); err != nil {
+			b.Fatal(err)
+		}
+	}
+}
+
+func (suite *TapSyncTestSuite) TestColumnOrigins() {
+	testhelpers.PopulateDb(suite.DB)
+	defer testhelpers.ClearDataFromTable(suite.DB)
+	query := "SELECT t.name, t.number AS n, t.number + 1 AS computed, 1 AS ra FROM test t"
+	expected := map[string]ColumnOrigin{
+		"name": {Table: "public.test", Column: "name"},
+		"n":    {Table: "public.test", Column: "number"},
+	}
+	for _, handle := range []func(context.Context, string, *sql.DB, ...QueryOption) ([]map[string]interface{}, error){HandleSQLQueryContext, HandlePgxQuery} {
+		origins := map[string]ColumnOrigin{}
+		_, err := handle(context.Background(), query, suite.DB, WithColumnOrigins(origins))
+		suite.Require().NoError(err)
+		suite.Equal(expected, origins)
+	}
+}
+
+func (suite *TapSyncTestSuite) TestRowLimitCancelsQuery() {
+	// reading the rows left of the query would take long
+	query := "SELECT i FROM generate_series(1, 100000000) i"
+	for _, handle := range []func(context.Context, string, *sql.DB, ...QueryOption) ([]map[string]interface{}, error){HandleSQLQueryContext, HandlePgxQuery} {
+		start := time.Now()
+		result, err := handle(context.Background(), query, suite.DB, WithRowLimit(3))
+		suite.Require().NoError(err)
+		suite.Len(result, 3)
+		suite.Less(time.Since(start), 5*time.Second)
+		// the pool replaces the connection of the cancelled query
+		result, err = handle(context.Background(), "SELECT 1 AS n", suite.DB)
+		suite.Require().NoError(err)
+		suite.Equal([]map[string]interface{}{{"n": int64(1)}}, result)
+	}
+}
@@ -0,0 +1,150 @@
+package tapsync
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"slices"
+	"strings"
+)
+
+// Policy maps identities to the tables they are allowed to query.
+// Tables are written as schema.table patterns, where either part
+// can be "*" to match any schema or table, for example
+// "public.object", "public.*" or "*".
+//
+// Anonymous tables can be queried by everyone,
+// Authenticated tables by every authenticated identity,
+// and Users and Groups grant extra tables to identities
+// by name or by group membership. Users are given by their
+// Identity.Key, the name qualified by the kind of credentials,
+// as token:alice, basic:alice or jwt:alice, so the subject of
+// a JWT can not take the grants of a user of htpasswd.
+//
+// As functions can read tables the plan does not show, as
+// query_to_xml or dblink do, queries can only call the functions of
+// DefaultFunctions and those of Functions, given by name, with their
+// schema for those not in pg_catalog, or as schema.* patterns.
+type Policy struct {
+	Anonymous     []string            `json:"anonymous"`
+	Authenticated []string            `json:"authenticated"`
+	Users         map[string][]string `json:"users"`
+	Groups        map[string][]string `json:"groups"`
+	Functions     []string            `json:"functions"`
+}
+
+// DefaultFunctions are the functions every query can call: the
+// operators, aggregates and functions of pg_catalog on numbers,
+// strings, dates and arrays, none of which read tables
+var DefaultFunctions = []string{
+	// conditional expressions and constructors, written as functions
+	"array", "coalesce", "greatest", "least", "nullif", "row",
+	// mathematical functions
+	"abs", "acos", "acosd", "asin", "asind", "atan", "atan2", "atan2d", "atand", "cbrt", "ceil", "ceiling",
+	"cos", "cosd", "cot", "cotd", "degrees", "div", "exp", "floor", "ln", "log", "log10", "mod", "pi",
+	"power", "radians", "round", "sign", "sin", "sind", "sqrt", "tan", "tand", "trunc", "width_bucket",
+	"float4", "float8", "int2", "int4", "int8", "numeric",
+	// string functions
+	"btrim", "char_length", "character_length", "concat", "concat_ws", "initcap", "left", "length",
+	"lower", "lpad", "ltrim", "md5", "octet_length", "overlay", "position", "regexp_like", "regexp_match",
+	"regexp_matches", "regexp_replace", "regexp_split_to_array", "repeat", "replace", "reverse", "right",
+	"rpad", "rtrim", "split_part", "starts_with", "strpos", "substr", "substring", "textcat", "to_char",
+	"to_number", "translate", "trim", "upper",
+	// date and time functions
+	"age", "date_bin", "date_part", "date_trunc", "extract", "isfinite", "make_date", "make_interval",
+	"make_time", "make_timestamp", "make_timestamptz", "now", "to_date", "to_timestamp",
+	// array functions
+	"array_append", "array_cat", "array_dims", "array_length", "array_lower", "array_ndims",
+	"array_position", "array_positions", "array_prepend", "array_remove", "array_replace",
+	"array_to_string", "array_upper", "cardinality", "generate_series", "string_to_array", "unnest",
+	// aggregate and window functions
+	"array_agg", "avg", "bit_and", "bit_or", "bool_and", "bool_or", "corr", "count", "covar_pop",
+	"covar_samp", "cume_dist", "dense_rank", "every", "first_value", "lag", "last_value", "lead", "max",
+	"min", "mode", "nth_value", "ntile", "percent_rank", "percentile_cont", "percentile_disc", "rank",
+	"regr_intercept", "regr_slope", "row_number", "stddev", "stddev_pop", "stddev_samp", "string_agg",
+	"sum", "var_pop", "var_samp", "variance",
+}
+
+// NewPolicyFromFile reads a policy from a JSON file:
+//
+//	{
+//		"anonymous": ["public.*"],
+//		"users": {"basic:alice": ["private.forced_photometry"]},
+//		"groups": {"collaboration": ["private.*"]},
+//		"functions": ["q3c_radial_query", "q3c_dist"]
+//	}
+func NewPolicyFromFile(fname string) (*Policy, error) {
+	content, err := os.ReadFile(fname)
+	if err != nil {
+		return nil, err
+	}
+	var policy Policy
+	if err := json.Unmarshal(content, &policy); err != nil {
+		return nil, fmt.Errorf("Error reading policy file %s: %w", fname, err)
+	}
+	return &policy, nil
+}
+
+// Authorize checks that the identity can query all the relations,
+// given as schema.table names.
+// A nil identity is an anonymous user.
+func (p *Policy) Authorize(identity *Identity, relations []string) error {
+	patterns := p.allowedPatterns(identity)
+	for _, relation := range relations {
+		if !matchesAny(patterns, relation) {
+			return fmt.Errorf("Access to table %s is not allowed", relation)
+		}
+	}
+	return nil
+}
+
+// AuthorizeFunctions checks that the functions, as given by
+// QueryPlan.Functions, can all be called
+func (p *Policy) AuthorizeFunctions(functions []string) error {
+	for _, function := range functions {
+		if !slices.Contains(DefaultFunctions, function) && !matchesFunction(p.Functions, function) {
+			return fmt.Errorf("Function %s is not allowed", function)
+		}
+	}
+	return nil
+}
+
+func matchesFunction(patterns []string, function string) bool {
+	schema, _, qualified := strings.Cut(function, ".")
+	for _, pattern := range patterns {
+		if pattern == function || qualified && pattern == schema+".*" {
+			return true
+		}
+	}
+	return false
+}
+
+func (p *Policy) allowedPatterns(identity *Identity) []string {
+	patterns := append([]string{}, p.Anonymous...)
+	if identity == nil {
+		return patterns
+	}
+	patterns = append(patterns, p.Authenticated...)
+	patterns = append(patterns, p.Users[identity.Key()]...)
+	for _, group := range identity.Groups {
+		patterns = append(patterns, p.Groups[group]...)
+	}
+	return patterns
+}
+
+func matchesAny(patterns []string, relation string) bool {
+	schema, table, _ := strings.Cut(relation, ".")
+	for _, pattern := range patterns {
+		if pattern == "*" {
+			return true
+		}
+		patternSchema, patternTable, ok := strings.Cut(pattern, ".")
+		if !ok {
+			continue
+		}
+		if (patternSchema == "*" || patternSchema == schema) && (patternTable == "*" || patternTable == table) {
+			return true
+		}
+	}
+	return false
+}
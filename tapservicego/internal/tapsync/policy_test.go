@@ -0,0 +1,74 @@
+package tapsync
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/gin-gonic/gin"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestPolicyAuthorize(t *testing.T) {
+	fname := writeTestFile(t, "policy.json", `{
+		"anonymous": ["public.object", "public.detection"],
+		"authenticated": ["public.*"],
+		"users": {"basic:alice": ["private.forced_photometry"]},
+		"groups": {"collaboration": ["private.*"]}
+	}`)
+	policy, err := NewPolicyFromFile(fname)
+	require.NoError(t, err)
+
+	assert.NoError(t, policy.Authorize(nil, []string{"public.object", "public.detection"}))
+	assert.EqualError(t, policy.Authorize(nil, []string{"public.object", "public.feature"}), "Access to table public.feature is not allowed")
+
+	bob := &Identity{Name: "bob"}
+	assert.NoError(t, policy.Authorize(bob, []string{"public.feature"}))
+	assert.Error(t, policy.Authorize(bob, []string{"private.forced_photometry"}))
+
+	alice := &Identity{Name: "alice", Provider: "basic"}
+	assert.NoError(t, policy.Authorize(alice, []string{"public.object", "private.forced_photometry"}))
+	assert.Error(t, policy.Authorize(alice, []string{"private.detection"}))
+
+	// the subject of a JWT is not the user of the same name
+	impostor := &Identity{Name: "alice", Provider: "jwt"}
+	assert.Error(t, policy.Authorize(impostor, []string{"private.forced_photometry"}))
+
+	member := &Identity{Name: "carol", Groups: []string{"collaboration"}}
+	assert.NoError(t, policy.Authorize(member, []string{"private.detection"}))
+
+	assert.NoError(t, policy.Authorize(nil, []string{}))
+}
+
+func TestPolicyWildcard(t *testing.T) {
+	policy := &Policy{Anonymous: []string{"*"}}
+	assert.NoError(t, policy.Authorize(nil, []string{"private.detection"}))
+	policy = &Policy{Anonymous: []string{"*.object"}}
+	assert.NoError(t, policy.Authorize(nil, []string{"archive.object"}))
+	assert.Error(t, policy.Authorize(nil, []string{"archive.detection"}))
+}
+
+func TestPolicyAuthorizeFunctions(t *testing.T) {
+	policy := &Policy{Anonymous: []string{"*"}, Functions: []string{"q3c_dist", "healpix.*"}}
+	assert.NoError(t, policy.AuthorizeFunctions([]string{"count", "q3c_dist", "healpix.nest"}))
+	assert.EqualError(t, policy.AuthorizeFunctions([]string{"abs", "query_to_xml"}), "Function query_to_xml is not allowed")
+	assert.Error(t, policy.AuthorizeFunctions([]string{"public.dblink"}))
+	assert.Error(t, policy.AuthorizeFunctions([]string{"q3c_radial_query"}))
+}
+
+func TestAuthorizeQueryFunctions(t *testing.T) {
+	service := &TapSyncService{policy: &Policy{Anonymous: []string{"*"}}}
+	w := httptest.NewRecorder()
+	c, _ := gin.CreateTestContext(w)
+	// the plan of SELECT query_to_xml('select * from secret.t', true, false, ''),
+	// which reads secret.t without it appearing in the plan
+	plan := &QueryPlan{Plan: map[string]interface{}{
+		"Node Type": "Result",
+		"Output":    []interface{}{"query_to_xml('select * from secret.t'::text, true, false, ''::text)"},
+	}}
+	assert.False(t, service.authorizeQuery(c, plan))
+	assert.Equal(t, http.StatusForbidden, w.Code)
+	assert.Contains(t, w.Body.String(), "Function query_to_xml is not allowed")
+}
@@ -2,6 +2,12 @@ package tapsync
 
 import (
 	"ataps/internal/testhelpers"
+	"context"
+	"encoding/json"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
 )
 
 func (suite *TapSyncTestSuite) TestSimpleSQLQuery() {
@@ -26,3 +32,115 @@ func (suite *TapSyncTestSuite) TestHandleSQLQuery() {
 	suite.Equal("test", result[0]["name"])
 	suite.Equal(int64(1), result[0]["number"])
 }
+
+func (suite *TapSyncTestSuite) TestGetQueryRelations() {
+	testhelpers.PopulateDb(suite.DB)
+	defer testhelpers.ClearDataFromTable(suite.DB)
+	query := "WITH t AS (SELECT * FROM test) SELECT a.name FROM t a JOIN public.test b ON a.id = b.id"
+	relations, err := GetQueryRelations(context.Background(), query, suite.DB)
+	if err != nil {
+		suite.T().Fatal(err)
+	}
+	suite.Equal([]string{"public.test"}, relations)
+	relations, err = GetQueryRelations(context.Background(), "SELECT 'test'", suite.DB)
+	if err != nil {
+		suite.T().Fatal(err)
+	}
+	suite.Equal([]string{}, relations)
+	_, err = GetQueryRelations(context.Background(), "SELECT * FROM dontexist", suite.DB)
+	suite.Error(err)
+}
+
+func (suite *TapSyncTestSuite) TestExplainQuery() {
+	testhelpers.PopulateDb(suite.DB)
+	defer testhelpers.ClearDataFromTable(suite.DB)
+	plan, err := ExplainQuery(context.Background(), "SELECT * FROM test a JOIN test b USING (id)", suite.DB)
+	suite.Require().NoError(err)
+	suite.Greater(plan.TotalCost, 0.0)
+	suite.Greater(plan.Rows, 0.0)
+	suite.Equal([]string{"public.test"}, plan.Relations())
+	nodes := plan.Nodes()
+	suite.Greater(len(nodes), 1)
+	suite.Equal(int64(0), nodes[0]["parent_id"])
+	suite.Equal(plan.TotalCost, nodes[0]["total_cost"])
+	_, err = ExplainQuery(context.Background(), "SELECT * FROM dontexist", suite.DB)
+	suite.Error(err)
+}
+
+func TestQueryPlanNodes(t *testing.T) {
+	var explained []struct {
+		Plan map[string]interface{}
+	}
+	err := json.Unmarshal([]byte(`[{"Plan": {
+		"Node Type": "Hash Join", "Startup Cost": 1.5, "Total Cost": 120.25, "Plan Rows": 1000, "Plan Width": 16,
+		"Plans": [
+			{"Node Type": "Seq Scan", "Relation Name": "detection", "Schema": "alerce", "Total Cost": 80, "Plan Rows": 1000, "Plan Width": 8},
+			{"Node Type": "Hash", "Total Cost": 20, "Plan Rows": 10, "Plan Width": 8, "Plans": [
+				{"Node Type": "Index Scan", "Relation Name": "object", "Schema": "alerce", "Total Cost": 20, "Plan Rows": 10, "Plan Width": 8}
+			]}
+		]}}]`), &explained)
+	require.NoError(t, err)
+	plan := &QueryPlan{Plan: explained[0].Plan}
+	assert.Equal(t, []string{"alerce.detection", "alerce.object"}, plan.Relations())
+	nodes := plan.Nodes()
+	require.Len(t, nodes, 4)
+	assert.Equal(t, map[string]interface{}{
+		"id":           int64(1),
+		"parent_id":    int64(0),
+		"node_type":    "Hash Join",
+		"relation":     "",
+		"startup_cost": 1.5,
+		"total_cost":   120.25,
+		"plan_rows":    int64(1000),
+		"plan_width":   int64(16),
+	}, nodes[0])
+	assert.Equal(t, "alerce.detection", nodes[1]["relation"])
+	assert.Equal(t, int64(1), nodes[2]["parent_id"])
+	assert.Equal(t, int64(3), nodes[3]["parent_id"])
+	assert.Equal(t, "Index Scan", nodes[3]["node_type"])
+}
+
+func (suite *TapSyncTestSuite) TestExplainQueryFunctions() {
+	plan, err := ExplainQuery(context.Background(), "SELECT query_to_xml('select * from secret.t', true, false, '') AS x, abs(-1)", suite.DB)
+	suite.Require().NoError(err)
+	suite.Equal([]string{"abs", "query_to_xml"}, plan.Functions())
+	plan, err = ExplainQuery(context.Background(), "SELECT * FROM generate_series(1, 3) g", suite.DB)
+	suite.Require().NoError(err)
+	suite.Equal([]string{"generate_series"}, plan.Functions())
+}
+
+func TestQueryPlanFunctions(t *testing.T) {
+	var explained []struct {
+		Plan map[string]interface{}
+	}
+	err := json.Unmarshal([]byte(`[{"Plan": {
+		"Node Type": "Nested Loop", "Output": ["query_to_xml('select * from secret.t'::text, true, false, ''::text)"],
+		"Plans": [
+			{"Node Type": "Function Scan", "Function Name": "dblink", "Schema": "public", "Alias": "d",
+				"Function Call": "public.dblink('dbname=x'::text, 'select f(1)'::text)"},
+			{"Node Type": "Seq Scan", "Relation Name": "object", "Schema": "alerce", "Alias": "o",
+				"Output": ["o.oid", "(o.meanra)::numeric(10,2)", "round((o.meandec)::numeric, 2)", "count(*)"],
+				"Filter": "((o.firstmjd)::timestamp(3) without time zone < now()) AND (\"Lower\"(o.oid) = 'a(b'::character varying(10))"}
+		]}}]`), &explained)
+	require.NoError(t, err)
+	plan := &QueryPlan{Plan: explained[0].Plan}
+	assert.Equal(t, []string{"count", "lower", "now", "public.dblink", "query_to_xml", "round"}, plan.Functions())
+}
+
+func TestExpressionFunctions(t *testing.T) {
+	testCases := []struct {
+		expression string
+		expected   []string
+	}{
+		{"(o.ndet > 10)", nil},
+		{"COALESCE(o.x, 1e5)", []string{"coalesce"}},
+		{"pg_catalog.upper('it''s f(x)'::text)", []string{"upper"}},
+		{`"my schema"."f""n"(1)`, []string{`my schema.f"n`}},
+		{"(x)::double precision[] + sqrt(y)", []string{"sqrt"}},
+		{"(x)::text AND secret.f(1)", []string{"secret.f"}},
+		{"'unterminated(", nil},
+	}
+	for _, tc := range testCases {
+		assert.Equal(t, tc.expected, expressionFunctions(tc.expression), tc.expression)
+	}
+}
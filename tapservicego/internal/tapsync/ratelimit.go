@@ -0,0 +1,186 @@
+package tapsync
+
+import (
+	"fmt"
+	"math"
+	"net/http"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/gin-gonic/gin"
+)
+
+// rowCountKey is the gin context key where handlers store
+// the number of rows returned by a query, so middlewares
+// can account for it after the request is served.
+const rowCountKey = "ataps.rowCount"
+
+// staleClientAge is how long a client can stay idle
+// before its quota state is discarded.
+const staleClientAge = 24 * time.Hour
+
+// RateLimitConfig holds the quotas enforced per client.
+// A client is identified by its identity when authenticated,
+// see Identity.Key, or by its IP address otherwise.
+// A zero value disables the corresponding quota.
+type RateLimitConfig struct {
+	// RequestsPerMinute is the sustained number of requests a client can make
+	RequestsPerMinute int `yaml:"requests_per_minute"`
+	// MaxConcurrentQueries is the number of queries a client can run at the same time
+	MaxConcurrentQueries int `yaml:"max_concurrent_queries"`
+	// RowsPerDay is the number of rows a client can retrieve in a 24 hours window
+	RowsPerDay int64 `yaml:"rows_per_day"`
+}
+
+// Enabled reports whether any quota is configured
+func (rl RateLimitConfig) Enabled() bool {
+	return rl.RequestsPerMinute > 0 || rl.MaxConcurrentQueries > 0 || rl.RowsPerDay > 0
+}
+
+type clientQuota struct {
+	tokens     float64
+	lastRefill time.Time
+	inFlight   int
+	rows       int64
+	dayStart   time.Time
+	lastSeen   time.Time
+}
+
+// RateLimiter enforces a RateLimitConfig on the requests
+// that go through its Middleware.
+type RateLimiter struct {
+	config    RateLimitConfig
+	mu        sync.Mutex
+	clients   map[string]*clientQuota
+	lastSweep time.Time
+	now       func() time.Time
+}
+
+// NewRateLimiter creates a RateLimiter for the provided quotas
+func NewRateLimiter(config RateLimitConfig) *RateLimiter {
+	return &RateLimiter{
+		config:  config,
+		clients: make(map[string]*clientQuota),
+		now:     time.Now,
+	}
+}
+
+// Middleware returns a gin middleware that rejects requests
+// exceeding the quotas with 429 Too Many Requests, a Retry-After
+// header and an error VOTable.
+// Rows returned by the handler are read from the gin context
+// after the request is served.
+func (l *RateLimiter) Middleware() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		key := clientKey(c)
+		retryAfter, err := l.acquire(key)
+		if err != nil {
+			code := http.StatusTooManyRequests
+			c.Header("Retry-After", strconv.Itoa(retryAfter))
+			c.XML(code, getErrorVOTable(err, code))
+			c.Abort()
+			return
+		}
+		defer func() {
+			rows := int64(c.GetInt(rowCountKey))
+			l.release(key, rows)
+		}()
+		c.Next()
+	}
+}
+
+// acquire checks every quota for the client and reserves
+// a request and a concurrent query slot.
+// If a quota is exceeded it returns the number of seconds
+// the client should wait and an error describing the quota.
+func (l *RateLimiter) acquire(key string) (int, error) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	now := l.now()
+	l.sweep(now)
+	quota, ok := l.clients[key]
+	if !ok {
+		quota = &clientQuota{
+			tokens:     float64(l.config.RequestsPerMinute),
+			lastRefill: now,
+			dayStart:   now,
+		}
+		l.clients[key] = quota
+	}
+	quota.lastSeen = now
+	if l.config.RowsPerDay > 0 {
+		if now.Sub(quota.dayStart) >= 24*time.Hour {
+			quota.rows = 0
+			quota.dayStart = now
+		}
+		if quota.rows >= l.config.RowsPerDay {
+			wait := quota.dayStart.Add(24 * time.Hour).Sub(now)
+			return ceilSeconds(wait), fmt.Errorf("Daily quota of %d rows exceeded", l.config.RowsPerDay)
+		}
+	}
+	if l.config.MaxConcurrentQueries > 0 && quota.inFlight >= l.config.MaxConcurrentQueries {
+		return 1, fmt.Errorf("Too many concurrent queries, the limit is %d", l.config.MaxConcurrentQueries)
+	}
+	if l.config.RequestsPerMinute > 0 {
+		// token bucket refilled continuously at RequestsPerMinute per minute
+		rate := float64(l.config.RequestsPerMinute) / 60
+		elapsed := now.Sub(quota.lastRefill).Seconds()
+		quota.tokens = math.Min(float64(l.config.RequestsPerMinute), quota.tokens+elapsed*rate)
+		quota.lastRefill = now
+		if quota.tokens < 1 {
+			wait := time.Duration((1 - quota.tokens) / rate * float64(time.Second))
+			return ceilSeconds(wait), fmt.Errorf("Rate limit of %d requests per minute exceeded", l.config.RequestsPerMinute)
+		}
+		quota.tokens--
+	}
+	quota.inFlight++
+	return 0, nil
+}
+
+// release frees the concurrent query slot of the client
+// and adds the rows it retrieved to its daily quota.
+func (l *RateLimiter) release(key string, rows int64) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	quota, ok := l.clients[key]
+	if !ok {
+		return
+	}
+	quota.inFlight--
+	quota.rows += rows
+}
+
+// sweep removes clients that have been idle for a long time
+// so the map does not grow without bounds.
+// It runs at most once per minute.
+func (l *RateLimiter) sweep(now time.Time) {
+	if now.Sub(l.lastSweep) < time.Minute {
+		return
+	}
+	l.lastSweep = now
+	for key, quota := range l.clients {
+		if quota.inFlight == 0 && now.Sub(quota.lastSeen) > staleClientAge {
+			delete(l.clients, key)
+		}
+	}
+}
+
+// clientKey identifies the client of a request.
+// Authenticated requests are keyed by identity, which is only set
+// once their credentials are validated, and the others by IP, so
+// unvalidated tokens can not get a quota of their own.
+func clientKey(c *gin.Context) string {
+	if identity := getIdentity(c); identity != nil {
+		return "user:" + identity.Key()
+	}
+	return "ip:" + c.ClientIP()
+}
+
+func ceilSeconds(d time.Duration) int {
+	seconds := int(math.Ceil(d.Seconds()))
+	if seconds < 1 {
+		return 1
+	}
+	return seconds
+}
@@ -0,0 +1,146 @@
+package tapsync
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/stretchr/testify/assert"
+)
+
+// testTokens are the tokens validated by newRateLimitedRouter
+var testTokens = map[string]Identity{
+	"secret": {Name: "alice", Provider: "token"},
+	"signed": {Name: "alice", Provider: "jwt"},
+}
+
+// newRateLimitedRouter returns a router that authenticates the tokens
+// of testTokens, as the authentication middleware, before the limiter
+func newRateLimitedRouter(limiter *RateLimiter, handler gin.HandlerFunc) *gin.Engine {
+	gin.SetMode(gin.TestMode)
+	router := gin.New()
+	authenticate := func(c *gin.Context) {
+		token, _ := strings.CutPrefix(c.GetHeader("Authorization"), "Bearer ")
+		if identity, ok := testTokens[token]; ok {
+			c.Set(identityKey, &identity)
+		}
+	}
+	router.POST("/sync", authenticate, limiter.Middleware(), handler)
+	return router
+}
+
+func sendRateLimitedRequest(router *gin.Engine, token string, ip string) *httptest.ResponseRecorder {
+	w := httptest.NewRecorder()
+	req, _ := http.NewRequest("POST", "/sync", nil)
+	if token != "" {
+		req.Header.Add("Authorization", "Bearer "+token)
+	}
+	req.RemoteAddr = ip + ":1234"
+	router.ServeHTTP(w, req)
+	return w
+}
+
+func TestRateLimitRequestsPerMinute(t *testing.T) {
+	now := time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC)
+	limiter := NewRateLimiter(RateLimitConfig{RequestsPerMinute: 2})
+	limiter.now = func() time.Time { return now }
+	router := newRateLimitedRouter(limiter, func(c *gin.Context) { c.Status(http.StatusOK) })
+
+	assert.Equal(t, http.StatusOK, sendRateLimitedRequest(router, "", "10.0.0.1").Code)
+	assert.Equal(t, http.StatusOK, sendRateLimitedRequest(router, "", "10.0.0.1").Code)
+	w := sendRateLimitedRequest(router, "", "10.0.0.1")
+	assert.Equal(t, http.StatusTooManyRequests, w.Code)
+	assert.Equal(t, "30", w.Header().Get("Retry-After"))
+	assert.Contains(t, w.Body.String(), "QUERY_STATUS")
+	assert.Contains(t, w.Body.String(), "Rate limit of 2 requests per minute exceeded")
+
+	// other clients have their own quota
+	assert.Equal(t, http.StatusOK, sendRateLimitedRequest(router, "", "10.0.0.2").Code)
+	assert.Equal(t, http.StatusOK, sendRateLimitedRequest(router, "secret", "10.0.0.1").Code)
+
+	// the bucket refills over time
+	now = now.Add(30 * time.Second)
+	assert.Equal(t, http.StatusOK, sendRateLimitedRequest(router, "", "10.0.0.1").Code)
+}
+
+func TestRateLimitTokenSharedAcrossIPs(t *testing.T) {
+	limiter := NewRateLimiter(RateLimitConfig{RequestsPerMinute: 1})
+	router := newRateLimitedRouter(limiter, func(c *gin.Context) { c.Status(http.StatusOK) })
+
+	assert.Equal(t, http.StatusOK, sendRateLimitedRequest(router, "secret", "10.0.0.1").Code)
+	assert.Equal(t, http.StatusTooManyRequests, sendRateLimitedRequest(router, "secret", "10.0.0.2").Code)
+}
+
+func TestRateLimitProviders(t *testing.T) {
+	limiter := NewRateLimiter(RateLimitConfig{RequestsPerMinute: 1})
+	router := newRateLimitedRouter(limiter, func(c *gin.Context) { c.Status(http.StatusOK) })
+
+	assert.Equal(t, http.StatusOK, sendRateLimitedRequest(router, "secret", "10.0.0.1").Code)
+	// users of the same name authenticated differently do not share their quota
+	assert.Equal(t, http.StatusOK, sendRateLimitedRequest(router, "signed", "10.0.0.1").Code)
+}
+
+func TestRateLimitUnvalidatedTokens(t *testing.T) {
+	limiter := NewRateLimiter(RateLimitConfig{RequestsPerMinute: 1})
+	router := newRateLimitedRouter(limiter, func(c *gin.Context) { c.Status(http.StatusOK) })
+
+	assert.Equal(t, http.StatusOK, sendRateLimitedRequest(router, "random1", "10.0.0.1").Code)
+	// tokens that were not validated share the quota of their IP
+	assert.Equal(t, http.StatusTooManyRequests, sendRateLimitedRequest(router, "random2", "10.0.0.1").Code)
+	assert.Equal(t, http.StatusTooManyRequests, sendRateLimitedRequest(router, "", "10.0.0.1").Code)
+}
+
+func TestRateLimitConcurrentQueries(t *testing.T) {
+	limiter := NewRateLimiter(RateLimitConfig{MaxConcurrentQueries: 1})
+	started := make(chan struct{})
+	finish := make(chan struct{})
+	router := newRateLimitedRouter(limiter, func(c *gin.Context) {
+		started <- struct{}{}
+		<-finish
+		c.Status(http.StatusOK)
+	})
+
+	done := make(chan int)
+	go func() {
+		done <- sendRateLimitedRequest(router, "", "10.0.0.1").Code
+	}()
+	<-started
+	w := sendRateLimitedRequest(router, "", "10.0.0.1")
+	assert.Equal(t, http.StatusTooManyRequests, w.Code)
+	assert.Equal(t, "1", w.Header().Get("Retry-After"))
+	close(finish)
+	assert.Equal(t, http.StatusOK, <-done)
+
+	// the slot is released once the first query is done
+	go func() { <-started }()
+	assert.Equal(t, http.StatusOK, sendRateLimitedRequest(router, "", "10.0.0.1").Code)
+}
+
+func TestRateLimitRowsPerDay(t *testing.T) {
+	now := time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC)
+	limiter := NewRateLimiter(RateLimitConfig{RowsPerDay: 10})
+	limiter.now = func() time.Time { return now }
+	router := newRateLimitedRouter(limiter, func(c *gin.Context) {
+		c.Set(rowCountKey, 6)
+		c.Status(http.StatusOK)
+	})
+
+	assert.Equal(t, http.StatusOK, sendRateLimitedRequest(router, "", "10.0.0.1").Code)
+	assert.Equal(t, http.StatusOK, sendRateLimitedRequest(router, "", "10.0.0.1").Code)
+	now = now.Add(time.Hour)
+	w := sendRateLimitedRequest(router, "", "10.0.0.1")
+	assert.Equal(t, http.StatusTooManyRequests, w.Code)
+	assert.Equal(t, "82800", w.Header().Get("Retry-After"))
+	assert.Contains(t, w.Body.String(), "Daily quota of 10 rows exceeded")
+
+	now = now.Add(23 * time.Hour)
+	assert.Equal(t, http.StatusOK, sendRateLimitedRequest(router, "", "10.0.0.1").Code)
+}
+
+func TestRateLimitConfigEnabled(t *testing.T) {
+	assert.False(t, RateLimitConfig{}.Enabled())
+	assert.True(t, RateLimitConfig{RowsPerDay: 1}.Enabled())
+}
@@ -0,0 +1,123 @@
+package tapsync
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net"
+	"net/http"
+	"time"
+
+	"github.com/gin-gonic/gin"
+)
+
+// readinessTimeout bounds the database ping done by /readyz
+const readinessTimeout = 2 * time.Second
+
+// HealthzHandler handles the liveness probe.
+// It only checks that the process is able to serve requests.
+func (service *TapSyncService) HealthzHandler(c *gin.Context) {
+	c.JSON(http.StatusOK, gin.H{"status": "ok"})
+}
+
+// ReadyzHandler handles the readiness probe.
+// The service is ready when it is not shutting down,
+// the database answers and the connection pool is not exhausted.
+func (service *TapSyncService) ReadyzHandler(c *gin.Context) {
+	if err := service.checkReadiness(c.Request.Context()); err != nil {
+		c.JSON(http.StatusServiceUnavailable, gin.H{"status": "unavailable", "error": err.Error()})
+		return
+	}
+	c.JSON(http.StatusOK, gin.H{"status": "ok"})
+}
+
+func (service *TapSyncService) checkReadiness(ctx context.Context) error {
+	if service.draining.Load() {
+		return fmt.Errorf("shutting down")
+	}
+	stats := service.DB.Stats()
+	if stats.MaxOpenConnections > 0 && stats.InUse >= stats.MaxOpenConnections {
+		return fmt.Errorf("connection pool exhausted")
+	}
+	ctx, cancel := context.WithTimeout(ctx, readinessTimeout)
+	defer cancel()
+	if err := service.DB.PingContext(ctx); err != nil {
+		return fmt.Errorf("database unreachable: %w", err)
+	}
+	return nil
+}
+
+// ListenAndServe serves the service on the configured port,
+// using HTTPS when TLS is configured, until the context is done,
+// and then shuts it down gracefully.
+func (service *TapSyncService) ListenAndServe(ctx context.Context) error {
+	ln, err := net.Listen("tcp", fmt.Sprintf(":%d", service.config.Port))
+	if err != nil {
+		return err
+	}
+	return service.Serve(ctx, ln)
+}
+
+// Serve serves the service on the listener until the context is done.
+// Then the service stops accepting requests, reports itself as not ready,
+// and waits up to the drain timeout for in-flight requests to finish.
+// Queries still running after the drain timeout are cancelled,
+// and finally the database connections are closed.
+func (service *TapSyncService) Serve(ctx context.Context, ln net.Listener) error {
+	// queries use this context as base, so they can be
+	// cancelled when the drain timeout is over
+	queriesCtx, cancelQueries := context.WithCancel(context.Background())
+	defer cancelQueries()
+	timeouts := service.config.Timeouts
+	server := &http.Server{
+		Handler:      service.Router,
+		BaseContext:  func(net.Listener) context.Context { return queriesCtx },
+		ReadTimeout:  timeouts.Read,
+		WriteTimeout: timeouts.Write,
+		IdleTimeout:  timeouts.Idle,
+	}
+	if service.Backends != nil {
+		healthCtx, stopHealthChecks := context.WithCancel(ctx)
+		defer stopHealthChecks()
+		go service.Backends.RunHealthChecks(healthCtx, service.config.HealthCheckInterval)
+	}
+	serveErr := make(chan error, 1)
+	go func() {
+		tls := service.config.TLS
+		if tls.Enabled() {
+			serveErr <- server.ServeTLS(ln, tls.CertFile, tls.KeyFile)
+			return
+		}
+		serveErr <- server.Serve(ln)
+	}()
+	service.Logger.Info("Serving", "address", ln.Addr().String(), "tls", service.config.TLS.Enabled())
+	select {
+	case err := <-serveErr:
+		service.Close()
+		return err
+	case <-ctx.Done():
+	}
+	service.Logger.Info("Shutting down", "drain_timeout", service.config.DrainTimeout.String())
+	service.draining.Store(true)
+	shutdownCtx, cancel := context.WithTimeout(context.Background(), service.config.DrainTimeout)
+	defer cancel()
+	err := server.Shutdown(shutdownCtx)
+	if errors.Is(err, context.DeadlineExceeded) {
+		service.Logger.Warn("Drain timeout exceeded, cancelling running queries")
+		cancelQueries()
+		server.Close()
+	}
+	service.Close()
+	return err
+}
+
+// Close releases the resources of the service
+func (service *TapSyncService) Close() error {
+	if service.Backends != nil {
+		return service.Backends.Close()
+	}
+	if service.DB == nil {
+		return nil
+	}
+	return service.DB.Close()
+}
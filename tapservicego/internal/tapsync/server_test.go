@@ -0,0 +1,137 @@
+package tapsync
+
+import (
+	"context"
+	"database/sql"
+	"io"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// newTestServerService creates a service with an unreachable database
+// and a /slow route that waits until released or cancelled
+func newTestServerService(t *testing.T, drainTimeout time.Duration, release chan struct{}) *TapSyncService {
+	db, err := sql.Open("pgx", "host=127.0.0.1 port=1 connect_timeout=1")
+	require.NoError(t, err)
+	gin.SetMode(gin.TestMode)
+	service := &TapSyncService{
+		Router: gin.New(),
+		DB:     db,
+		Logger: NewLogger(io.Discard, "error"),
+		config: NewConfig(WithDrainTimeout(drainTimeout)),
+	}
+	service.Router.GET("/healthz", service.HealthzHandler)
+	service.Router.GET("/readyz", service.ReadyzHandler)
+	service.Router.GET("/slow", func(c *gin.Context) {
+		select {
+		case <-release:
+			c.String(http.StatusOK, "done")
+		case <-c.Request.Context().Done():
+			c.String(http.StatusServiceUnavailable, "cancelled")
+		}
+	})
+	return service
+}
+
+func TestHealthz(t *testing.T) {
+	service := newTestServerService(t, time.Second, nil)
+	defer service.Close()
+	w := httptest.NewRecorder()
+	req, _ := http.NewRequest("GET", "/healthz", nil)
+	service.Router.ServeHTTP(w, req)
+	assert.Equal(t, http.StatusOK, w.Code)
+	assert.JSONEq(t, `{"status": "ok"}`, w.Body.String())
+}
+
+func TestReadyzDatabaseUnreachable(t *testing.T) {
+	service := newTestServerService(t, time.Second, nil)
+	defer service.Close()
+	w := httptest.NewRecorder()
+	req, _ := http.NewRequest("GET", "/readyz", nil)
+	service.Router.ServeHTTP(w, req)
+	assert.Equal(t, http.StatusServiceUnavailable, w.Code)
+	assert.Contains(t, w.Body.String(), "database unreachable")
+}
+
+func TestReadyzDraining(t *testing.T) {
+	service := newTestServerService(t, time.Second, nil)
+	defer service.Close()
+	service.draining.Store(true)
+	w := httptest.NewRecorder()
+	req, _ := http.NewRequest("GET", "/readyz", nil)
+	service.Router.ServeHTTP(w, req)
+	assert.Equal(t, http.StatusServiceUnavailable, w.Code)
+	assert.Contains(t, w.Body.String(), "shutting down")
+}
+
+func serveInBackground(t *testing.T, service *TapSyncService) (string, context.CancelFunc, chan error) {
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	require.NoError(t, err)
+	ctx, cancel := context.WithCancel(context.Background())
+	done := make(chan error, 1)
+	go func() {
+		done <- service.Serve(ctx, ln)
+	}()
+	return "http://" + ln.Addr().String(), cancel, done
+}
+
+func TestServeDrainsInFlightRequests(t *testing.T) {
+	release := make(chan struct{})
+	service := newTestServerService(t, 5*time.Second, release)
+	url, stop, done := serveInBackground(t, service)
+
+	response := make(chan *http.Response, 1)
+	go func() {
+		resp, err := http.Get(url + "/slow")
+		if err == nil {
+			response <- resp
+		}
+		close(response)
+	}()
+	// wait for the request to be in flight before shutting down
+	require.Eventually(t, func() bool {
+		resp, err := http.Get(url + "/healthz")
+		if err != nil {
+			return false
+		}
+		resp.Body.Close()
+		return true
+	}, time.Second, 10*time.Millisecond)
+	time.Sleep(50 * time.Millisecond)
+	stop()
+	require.Eventually(t, service.draining.Load, time.Second, 10*time.Millisecond)
+	close(release)
+
+	resp := <-response
+	require.NotNil(t, resp)
+	defer resp.Body.Close()
+	body, _ := io.ReadAll(resp.Body)
+	assert.Equal(t, http.StatusOK, resp.StatusCode)
+	assert.Equal(t, "done", string(body))
+	assert.NoError(t, <-done)
+}
+
+func TestServeCancelsRequestsAfterDrainTimeout(t *testing.T) {
+	service := newTestServerService(t, 100*time.Millisecond, make(chan struct{}))
+	url, stop, done := serveInBackground(t, service)
+
+	response := make(chan error, 1)
+	go func() {
+		resp, err := http.Get(url + "/slow")
+		if err == nil {
+			resp.Body.Close()
+		}
+		response <- err
+	}()
+	time.Sleep(100 * time.Millisecond)
+	stop()
+	assert.ErrorIs(t, <-done, context.DeadlineExceeded)
+	<-response
+}
@@ -2,23 +2,49 @@ package tapsync
 
 import (
 	"ataps/internal/parsers"
+	"ataps/pkg/alercedb"
+	"context"
 	"database/sql"
+	"errors"
 	"fmt"
-	"log"
+	"log/slog"
 	"net/http"
 	"os"
 	"slices"
+	"strconv"
+	"strings"
+	"sync/atomic"
+	"time"
 
 	"github.com/gin-gonic/gin"
 )
 
-func setResponse(c *gin.Context, sqlResult []map[string]interface{}, format string) error {
+// gin context keys used by the handler to pass the options
+// of the text and HTML formats and the time of the query to setResponse
+const (
+	textOptionsKey = "ataps.textOptions"
+	htmlOptionsKey = "ataps.htmlOptions"
+	queryTimeKey   = "ataps.queryTime"
+)
+
+// setResponse writes the result in the format to the response,
+// describing the columns in the formats that support it.
+// When overflow is set the result was truncated to MAXREC rows,
+// which VOTables report with the QUERY_STATUS INFO set to OVERFLOW.
+func setResponse(c *gin.Context, sqlResult []map[string]interface{}, columns []parsers.Column, format string, overflow bool) error {
 	switch format {
-	case "votable":
-		votable, err := parsers.CreateVOTable(sqlResult)
+	case "votable", "votable-mivot":
+		createVOTable := parsers.CreateVOTable
+		if format == "votable-mivot" {
+			createVOTable = parsers.CreateAnnotatedVOTable
+		}
+		votable, err := createVOTable(sqlResult, columns...)
 		if err != nil {
 			return err
 		}
+		if overflow {
+			votable.Resource.Infos[0].Value = "OVERFLOW"
+		}
 		result, err := parsers.VOTableToXML(votable)
 		if err != nil {
 			return err
@@ -28,7 +54,7 @@ func setResponse(c *gin.Context, sqlResult []map[string]interface{}, format stri
 		c.Header("Content-Length", fmt.Sprintf("%d", len(result)))
 		c.String(http.StatusOK, result)
 	case "csv":
-		result, err := parsers.ParseCSV(sqlResult)
+		result, err := parsers.ParseCSV(sqlResult, csvOptions(c, columns, overflow)...)
 		if err != nil {
 			return err
 		}
@@ -37,7 +63,7 @@ func setResponse(c *gin.Context, sqlResult []map[string]interface{}, format stri
 		c.Header("Content-Length", fmt.Sprintf("%d", len(result)))
 		c.String(http.StatusOK, result)
 	case "tsv":
-		result, err := parsers.ParseTSV(sqlResult)
+		result, err := parsers.ParseTSV(sqlResult, csvOptions(c, columns, overflow)...)
 		if err != nil {
 			return err
 		}
@@ -45,33 +71,48 @@ func setResponse(c *gin.Context, sqlResult []map[string]interface{}, format stri
 		c.Header("Content-Encoding", "UTF-8")
 		c.Header("Content-Length", fmt.Sprintf("%d", len(result)))
 		c.String(http.StatusOK, result)
-	case "fits":
-		fname, err := parsers.ParseFits(sqlResult)
+	case "ecsv":
+		result, err := parsers.ParseECSV(sqlResult, columns...)
 		if err != nil {
 			return err
 		}
-		// Open the file
-		file, err := os.Open(fname)
-		if err != nil {
-			return err
+		c.Header("Content-Type", "text/x-ecsv")
+		c.Header("Content-Encoding", "UTF-8")
+		c.Header("Content-Length", fmt.Sprintf("%d", len(result)))
+		c.String(http.StatusOK, result)
+	case "ipac":
+		result := parsers.ParseIPAC(sqlResult, provenance(c, overflow), columns...)
+		c.Header("Content-Type", "text/plain")
+		c.Header("Content-Encoding", "UTF-8")
+		c.Header("Content-Length", fmt.Sprintf("%d", len(result)))
+		c.String(http.StatusOK, result)
+	case "fits":
+		headers := map[string]string{
+			"Content-Description":       "File Transfer",
+			"Content-Transfer-Encoding": "binary",
+			"Content-Disposition":       "attachment; filename=results.fits",
+			"Content-Type":              "application/fits",
+		}
+		for key, value := range headers {
+			c.Header(key, value)
 		}
-		defer file.Close()
-		// Get file info
-		fileInfo, err := file.Stat()
+		// the file is streamed as it is written
+		err := parsers.WriteFits(sqlResult, c.Writer, columns...)
 		if err != nil {
+			if !c.Writer.Written() {
+				// nothing was sent, so the error can replace the file
+				for key := range headers {
+					c.Writer.Header().Del(key)
+				}
+			}
 			return err
 		}
-		c.Header("Content-Description", "File Transfer")
-		c.Header("Content-Transfer-Encoding", "binary")
-		c.Header("Content-Disposition", "attachment; filename=results.fits")
-		c.Header("Content-Type", "application/fits")
-		c.DataFromReader(http.StatusOK, fileInfo.Size(), "application/fits", file, nil)
-		// remove the file
-		if err := os.Remove(fname); err != nil {
-			log.Printf("Error removing file %s: %v", fname, err)
-		}
 	case "text":
-		result := parsers.ParseText(sqlResult)
+		options, _ := c.Value(textOptionsKey).([]parsers.TextOption)
+		if queryTime := c.GetDuration(queryTimeKey); queryTime > 0 {
+			options = append(options, parsers.WithQueryTime(queryTime))
+		}
+		result := parsers.ParseText(sqlResult, columns, options...)
 		c.Header("Content-Type", "text/plain")
 		c.Header("Content-Encoding", "UTF-8")
 		c.Header("Content-Length", fmt.Sprintf("%d", len(result)))
@@ -79,7 +120,9 @@ func setResponse(c *gin.Context, sqlResult []map[string]interface{}, format stri
 	case "html":
 		c.Header("Content-Type", "text/html")
 		c.Header("Content-Encoding", "UTF-8")
-		err := parsers.ParseHTML(sqlResult, c.Writer)
+		options, _ := c.Value(htmlOptionsKey).([]parsers.HTMLOption)
+		options = append(options, parsers.WithKeywords(provenance(c, overflow)...), parsers.WithOverflow(overflow))
+		err := parsers.ParseHTML(sqlResult, c.Writer, columns, options...)
 		if err != nil {
 			return err
 		}
@@ -90,6 +133,45 @@ func setResponse(c *gin.Context, sqlResult []map[string]interface{}, format stri
 	return nil
 }
 
+// provenance returns the keywords telling how the results were
+// obtained: the language and the query, when they were queried, and
+// whether they overflow, as the QUERY_STATUS INFO of VOTables
+func provenance(c *gin.Context, overflow bool) []parsers.Keyword {
+	status := "OK"
+	if overflow {
+		status = "OVERFLOW"
+	}
+	return []parsers.Keyword{
+		{Name: "LANG", Value: c.PostForm("LANG")},
+		{Name: "QUERY", Value: c.PostForm("QUERY")},
+		{Name: "DATE", Value: time.Now().UTC().Format(time.RFC3339)},
+		{Name: "QUERY_STATUS", Value: status},
+	}
+}
+
+// describeColumns returns the columns of the result with their
+// database types, and the metadata of those read from a column of
+// the ALeRCE tables, as given by their origins. Computed columns,
+// with no origin, have no metadata.
+func describeColumns(types map[string]string, origins map[string]ColumnOrigin) []parsers.Column {
+	var columns []parsers.Column
+	for name, databaseType := range types {
+		column := parsers.Column{Name: name, Type: databaseType}
+		origin, known := origins[name]
+		if metadata, ok := alercedb.LookupColumn(origin.Table, origin.Column); known && ok {
+			column.Unit = metadata.Unit
+			column.UCD = metadata.UCD
+			column.Utype = metadata.Utype
+			column.Xtype = metadata.Xtype
+			column.Description = metadata.Description
+			column.Coosys = metadata.Coosys
+			column.Timesys = metadata.Timesys
+		}
+		columns = append(columns, column)
+	}
+	return columns
+}
+
 func caseInvalidLang(c *gin.Context) {
 	code := http.StatusBadRequest
 	c.XML(code, getErrorVOTable(fmt.Errorf("Invalid LANG %s", c.PostForm("LANG")), code))
@@ -97,13 +179,15 @@ func caseInvalidLang(c *gin.Context) {
 
 // getFormatOrResponseFormat returns the format or response format
 // from the request. If both are provided, it returns an error.
-// If neither are provided, it returns the default format, "votable".
+// If neither are provided, it returns the default format,
+// the first valid format, which is "votable" unless disabled.
 // If one is provided, it returns that format.
+// Media types such as text/csv are accepted for their formats,
+// see formatMediaTypes.
 // If the format is invalid, it returns an error.
-func getFormatOrResponseFormat(c *gin.Context) string {
-	format := c.PostForm("FORMAT")
-	responseFormat := c.PostForm("RESPONSEFORMAT")
-	validFormats := []string{"votable", "csv", "csv", "tsv", "fits", "text", "html"}
+func getFormatOrResponseFormat(c *gin.Context, validFormats []string) string {
+	format, _ := parseFormat(c.PostForm("FORMAT"))
+	responseFormat, _ := parseFormat(c.PostForm("RESPONSEFORMAT"))
 	if format != "" && responseFormat == "" {
 		if slices.Contains(validFormats, format) {
 			return format
@@ -121,7 +205,7 @@ func getFormatOrResponseFormat(c *gin.Context) string {
 		return ""
 	}
 	if responseFormat == "" && format == "" {
-		return "votable" // default format
+		return validFormats[0] // default format
 	}
 	code := http.StatusBadRequest
 	c.XML(code, getErrorVOTable(fmt.Errorf("Both FORMAT and RESPONSEFORMAT provided"), code))
@@ -134,35 +218,168 @@ func getFormatOrResponseFormat(c *gin.Context) string {
 // - QUERY: the query to execute.
 // Optional parameters:
 // - FORMAT: the format of the response. Default is "votable".
+// "votable-mivot" is a VOTable with MIVOT annotations of the
+// positions, times and magnitudes of the results. "ecsv" is the
+// Enhanced CSV of Astropy, declaring the datatypes of the columns.
+// "ipac" is a fixed-width IPAC table, with the query as keywords.
+// "html" is a report whose table can be sorted, filtered and paged,
+// showing the query and buttons to download the results in the other
+// formats enabled.
+// Media types are accepted too, such as "text/csv;header=absent".
+// - NULL, FLOATFORMAT, PRECISION, HEADER, DELIMITER, QUOTING, BOM and
+// COMMENTS: the dialect of the "csv" and "tsv" formats, which can also
+// be given as lowercase parameters of their media types. They are the
+// token of NULLs, the format of floats ("g", "e" or "f") and its
+// precision, "present" or "absent" for the names of the columns, the
+// delimiter, a character or "tab", "minimal" or "all" for the fields
+// quoted, and whether a UTF-8 byte order mark and the provenance of
+// the results as "#" comments come first.
+// - TRUNCATE and WRAP: the widths of the longest values and of the
+// tables of the "text" format, whose values are truncated, and whose
+// columns are split into pages, when they are wider.
 // - RESPONSEFORMAT: the format of the response. Default is "votable".
+// - MAXREC: the maximum number of rows to return.
+// - EXPLAIN: when true, the plan of the query is returned instead
+// of its result, as a VOTable unless another format is requested.
+// Queries whose plan exceeds the configured cost or number of rows
+// are rejected. The service has no asynchronous endpoint yet,
+// so they can not be downgraded to an asynchronous job.
 // If both FORMAT and RESPONSEFORMAT are provided, an error is returned.
+// Languages and formats disabled in the configuration are rejected.
+// When the result cache is enabled, repeated queries are served from it,
+// except those reading uploaded tables.
 func (service *TapSyncService) SyncPostHandler(c *gin.Context) {
 	lang := c.PostForm("LANG")
+	if !slices.Contains(service.config.Languages, lang) {
+		caseInvalidLang(c)
+		return
+	}
 	switch lang {
 	case "PSQL":
+		c.Set(langKey, lang)
 		query := c.PostForm("QUERY")
 		if query == "" {
 			code := http.StatusBadRequest
 			c.XML(code, getErrorVOTable(fmt.Errorf("No query provided"), code))
 			return
 		}
-		format := getFormatOrResponseFormat(c)
+		format := getFormatOrResponseFormat(c, service.config.Formats)
 		if format == "" {
 			// here the error has already been added to the response
 			// so we just return
 			return
 		}
-		sqlResult, err := HandleSQLQuery(query, service.DB)
+		c.Set(formatKey, format)
+		dialect, ok := getCSVDialect(c, format)
+		if !ok {
+			// here the error has already been added to the response
+			return
+		}
+		c.Set(csvDialectKey, dialect)
+		textOptions, textParams, ok := getTextOptions(c, format)
+		if !ok {
+			// here the error has already been added to the response
+			return
+		}
+		c.Set(textOptionsKey, textOptions)
+		c.Set(htmlOptionsKey, htmlDownloads(c, service.config.Formats))
+		maxRec, ok := service.getMaxRec(c)
+		if !ok {
+			// here the error has already been added to the response
+			return
+		}
+		explain, ok := getExplain(c)
+		if !ok {
+			// here the error has already been added to the response
+			return
+		}
+		backend, err := service.Backends.ForQuery(query)
+		if err != nil {
+			code := http.StatusBadRequest
+			c.Error(err)
+			c.XML(code, getErrorVOTable(err, code))
+			return
+		}
+		ctx := c.Request.Context()
+		if service.config.Timeouts.Query > 0 {
+			var cancel context.CancelFunc
+			ctx, cancel = context.WithTimeout(ctx, service.config.Timeouts.Query)
+			defer cancel()
+		}
+		if service.policy != nil || explain || service.config.Limits.PlanLimited() {
+			plan, err := ExplainQuery(ctx, query, backend.Reader())
+			if err != nil {
+				code := http.StatusInternalServerError
+				c.Error(err)
+				c.Set(errorCategoryKey, errorCategoryQuery)
+				c.XML(code, getErrorVOTable(err, code))
+				return
+			}
+			if !service.authorizeQuery(c, plan) {
+				// here the error has already been added to the response
+				return
+			}
+			if explain {
+				service.writePlan(c, plan, format)
+				return
+			}
+			if err := service.config.Limits.CheckPlan(plan); err != nil {
+				code := http.StatusBadRequest
+				c.Error(err)
+				c.XML(code, getErrorVOTable(err, code))
+				return
+			}
+		}
+		if service.cache != nil {
+			// HTML reports repeat the query of the request in their
+			// download forms and vary with DOWNLOADS, so they are not cached
+			if readsUploadTables(query) || format == "html" {
+				service.Metrics.CacheResult("bypass")
+			} else {
+				params := append(append([]string{format}, dialect.params...), textParams...)
+				key := cacheKey(query, lang, maxRec, strings.Join(params, ";"))
+				if service.cache.Serve(c, key) {
+					service.Metrics.CacheResult("hit")
+					return
+				}
+				service.Metrics.CacheResult("miss")
+				defer service.cache.Record(c, key)()
+			}
+		}
+		// COPY writes the default dialect only
+		if format == "csv" && service.config.CopyCSV && maxRec < 0 && len(dialect.params) == 0 {
+			service.copyCSV(ctx, c, backend, query)
+			return
+		}
+		types, origins := map[string]string{}, map[string]ColumnOrigin{}
+		opts := []QueryOption{WithColumnTypes(types), WithColumnOrigins(origins)}
+		if maxRec >= 0 {
+			// one more row is read to know if the result overflows
+			opts = append(opts, WithRowLimit(maxRec+1))
+		}
+		start := time.Now()
+		sqlResult, err := backend.Query(ctx, query, opts...)
+		c.Set(queryTimeKey, time.Since(start))
 		if err != nil {
 			// consider that the default XML render does not show quotes
 			// if the error message contains quotes, it will be replaced by &#34;
 			code := http.StatusInternalServerError
+			c.Error(err)
+			c.Set(errorCategoryKey, errorCategoryQuery)
 			c.XML(code, getErrorVOTable(err, code))
 			return
 		}
-		err = setResponse(c, sqlResult, format)
+		overflow := maxRec >= 0 && len(sqlResult) > maxRec
+		if overflow {
+			sqlResult = sqlResult[:maxRec]
+		}
+		c.Set(rowCountKey, len(sqlResult))
+		err = setResponse(c, sqlResult, describeColumns(types, origins), format, overflow)
 		if err != nil {
 			code := http.StatusInternalServerError
+			c.Error(err)
+			c.Set(errorCategoryKey, errorCategoryFormat)
+			service.Logger.ErrorContext(c.Request.Context(), "Error writing response", "format", format, "error", err)
 			c.XML(code, getErrorVOTable(err, code))
 			return
 		}
@@ -172,23 +389,233 @@ func (service *TapSyncService) SyncPostHandler(c *gin.Context) {
 	}
 }
 
+// copyCSV streams the result of the query as CSV using COPY TO STDOUT.
+// Errors found before writing the first row are reported with an error
+// VOTable, while later errors can only truncate the response.
+func (service *TapSyncService) copyCSV(ctx context.Context, c *gin.Context, backend *Backend, query string) {
+	c.Header("Content-Type", "text/csv")
+	c.Header("Content-Encoding", "UTF-8")
+	c.Status(http.StatusOK)
+	rows, err := backend.CopyCSV(ctx, query, c.Writer)
+	c.Set(rowCountKey, int(rows))
+	if err != nil {
+		c.Error(err)
+		c.Set(errorCategoryKey, errorCategoryQuery)
+		if c.Writer.Written() {
+			service.Logger.ErrorContext(ctx, "Error streaming CSV response", "error", err)
+			return
+		}
+		c.Writer.Header().Del("Content-Type")
+		c.Writer.Header().Del("Content-Encoding")
+		code := http.StatusInternalServerError
+		if errors.Is(err, errNotSingleStatement) {
+			code = http.StatusBadRequest
+		}
+		c.XML(code, getErrorVOTable(err, code))
+	}
+}
+
+// getMaxRec returns the maximum number of rows to return, taken
+// from the MAXREC parameter or the configured default, and capped
+// by the configured limit. It returns -1 when there is no limit.
+// If MAXREC is invalid, the error is added to the response
+// and false is returned.
+func (service *TapSyncService) getMaxRec(c *gin.Context) (int, bool) {
+	limits := service.config.Limits
+	maxRec := -1
+	if limits.DefaultMaxRec > 0 {
+		maxRec = limits.DefaultMaxRec
+	}
+	if value := c.PostForm("MAXREC"); value != "" {
+		parsed, err := strconv.Atoi(value)
+		if err != nil || parsed < 0 {
+			code := http.StatusBadRequest
+			c.XML(code, getErrorVOTable(fmt.Errorf("Invalid MAXREC %s", value), code))
+			return 0, false
+		}
+		maxRec = parsed
+	}
+	if limits.MaxRec > 0 && (maxRec < 0 || maxRec > limits.MaxRec) {
+		maxRec = limits.MaxRec
+	}
+	return maxRec, true
+}
+
+// getExplain reads the EXPLAIN parameter, which asks for the plan
+// of the query instead of its result. If it is invalid, the error
+// is added to the response and false is returned.
+func getExplain(c *gin.Context) (bool, bool) {
+	value := c.PostForm("EXPLAIN")
+	if value == "" {
+		return false, true
+	}
+	explain, err := strconv.ParseBool(value)
+	if err != nil {
+		code := http.StatusBadRequest
+		c.XML(code, getErrorVOTable(fmt.Errorf("Invalid EXPLAIN %s", value), code))
+		return false, false
+	}
+	return explain, true
+}
+
+// getTextOptions returns the options of the text format from the
+// TRUNCATE and WRAP parameters, the widths of the longest values and
+// of the tables, and the parameters given, as name=value pairs.
+// If a parameter is invalid, the error is added to the response
+// and false is returned.
+func getTextOptions(c *gin.Context, format string) ([]parsers.TextOption, []string, bool) {
+	if format != "text" {
+		return nil, nil, true
+	}
+	var options []parsers.TextOption
+	var params []string
+	for _, param := range []struct {
+		name   string
+		option func(int) parsers.TextOption
+	}{{"TRUNCATE", parsers.WithTruncate}, {"WRAP", parsers.WithWrap}} {
+		value := c.PostForm(param.name)
+		if value == "" {
+			continue
+		}
+		width, err := strconv.Atoi(value)
+		if err != nil || width < 1 {
+			code := http.StatusBadRequest
+			c.XML(code, getErrorVOTable(fmt.Errorf("Invalid %s %s", param.name, value), code))
+			return nil, nil, false
+		}
+		options = append(options, param.option(width))
+		params = append(params, param.name+"="+value)
+	}
+	return options, params, true
+}
+
+// htmlDownloads returns the options of the HTML reports to download
+// the results in the other formats enabled, posting the parameters
+// of the query again. DOWNLOADS=false leaves them out, as the console
+// does for its previews, which can not post forms.
+func htmlDownloads(c *gin.Context, formats []string) []parsers.HTMLOption {
+	if downloads, err := strconv.ParseBool(c.PostForm("DOWNLOADS")); err == nil && !downloads {
+		return nil
+	}
+	var params []parsers.Keyword
+	for _, name := range []string{"LANG", "QUERY", "MAXREC", "EXPLAIN"} {
+		if value := c.PostForm(name); value != "" {
+			params = append(params, parsers.Keyword{Name: name, Value: value})
+		}
+	}
+	var downloads []string
+	for _, format := range formats {
+		if format != "html" {
+			downloads = append(downloads, format)
+		}
+	}
+	return []parsers.HTMLOption{parsers.WithDownloads(params, downloads...)}
+}
+
+// writePlan writes a row for each node of the plan in the format,
+// see QueryPlan.Nodes
+func (service *TapSyncService) writePlan(c *gin.Context, plan *QueryPlan, format string) {
+	nodes := plan.Nodes()
+	c.Set(rowCountKey, len(nodes))
+	if err := setResponse(c, nodes, nil, format, false); err != nil {
+		code := http.StatusInternalServerError
+		c.Error(err)
+		c.Set(errorCategoryKey, errorCategoryFormat)
+		c.XML(code, getErrorVOTable(err, code))
+	}
+}
+
+// authorizeQuery checks that the identity of the request can read
+// every table read by the plan of the query, and that the plan only
+// calls the functions allowed. If it can not, the error is added to
+// the response and false is returned.
+// When no policy is configured every query is authorized.
+func (service *TapSyncService) authorizeQuery(c *gin.Context, plan *QueryPlan) bool {
+	if service.policy == nil {
+		return true
+	}
+	err := service.policy.Authorize(getIdentity(c), plan.Relations())
+	if err == nil {
+		err = service.policy.AuthorizeFunctions(plan.Functions())
+	}
+	if err != nil {
+		code := http.StatusForbidden
+		c.Error(err)
+		c.XML(code, getErrorVOTable(err, code))
+		return false
+	}
+	return true
+}
+
 type TapSyncService struct {
 	Router *gin.Engine
-	DB     *sql.DB
-	config *Config
+	// DB is the primary database of the default backend
+	DB       *sql.DB
+	Backends *Backends
+	Metrics  *Metrics
+	Logger   *slog.Logger
+	config   *Config
+	policy   *Policy
+	// cache is nil when the result cache is disabled
+	cache *ResultCache
+	// draining is set when the service starts shutting down
+	draining atomic.Bool
 }
 
 func NewTapSyncService(config *Config) *TapSyncService {
-	db, err := GetDB(config.DatabaseURL)
+	backends, err := NewBackends(config)
+	if err != nil {
+		panic(err)
+	}
+	db := backends.Default().Primary
+	authenticators, err := NewAuthenticators(config.Auth)
 	if err != nil {
 		panic(err)
 	}
-	router := gin.Default()
+	var policy *Policy
+	if config.Auth.PolicyFile != "" {
+		policy, err = NewPolicyFromFile(config.Auth.PolicyFile)
+		if err != nil {
+			panic(err)
+		}
+	}
+	var cache *ResultCache
+	if config.Cache.Enabled() {
+		cache, err = NewResultCache(config.Cache)
+		if err != nil {
+			panic(err)
+		}
+	}
+	logger := NewLogger(os.Stdout, config.LogLevel)
+	router := gin.New()
+	router.Use(RequestIDMiddleware(), LoggerMiddleware(logger), RecoveryMiddleware(logger))
 	service := &TapSyncService{
-		Router: router,
-		DB:     db,
-		config: config,
+		Router:   router,
+		DB:       db,
+		Backends: backends,
+		Metrics:  NewMetrics(db),
+		Logger:   logger,
+		config:   config,
+		policy:   policy,
+		cache:    cache,
+	}
+	service.Metrics.RegisterBackends(backends)
+	// the audit comes first so the requests rejected
+	// by the authentication are audited too
+	handlers := []gin.HandlerFunc{service.Metrics.Middleware(), AuditMiddleware(logger)}
+	if len(authenticators) > 0 {
+		handlers = append(handlers, AuthenticationMiddleware(authenticators))
+	}
+	if config.RateLimit.Enabled() {
+		handlers = append(handlers, NewRateLimiter(config.RateLimit).Middleware())
+	}
+	handlers = append(handlers, service.SyncPostHandler)
+	service.Router.POST("/sync", handlers...)
+	service.Router.GET("/metrics", gin.WrapH(service.Metrics.Handler()))
+	service.Router.GET("/healthz", service.HealthzHandler)
+	service.Router.GET("/readyz", service.ReadyzHandler)
+	if config.Console {
+		service.registerConsole()
 	}
-	service.Router.POST("/sync", service.SyncPostHandler)
 	return service
 }
@@ -1,15 +1,17 @@
 package tapsync
 
 import (
+	"ataps/internal/parsers"
 	"ataps/internal/testhelpers"
 	"net/http"
 	"net/http/httptest"
-	"os"
+	"net/url"
 	"strings"
 	"testing"
 
-	"github.com/astrogo/cfitsio"
+	"github.com/gin-gonic/gin"
 	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
 )
 
 func (suite *TapSyncTestSuite) TestQueryParams() {
@@ -100,6 +102,40 @@ func (suite *TapSyncTestSuite) TestCSVQueries() {
 		assert.Equal(t, "?column?\ntest\n", w.Body.String())
 		assert.Equal(t, "text/tab-separated-values", w.Header().Get("Content-Type"))
 	})
+	t.Run("TestCSVQueryDialect", func(t *testing.T) {
+		w := httptest.NewRecorder()
+		query := url.Values{"LANG": {"PSQL"}, "FORMAT": {"text/csv;header=absent"}, "NULL": {"NULL"}, "QUERY": {"SELECT 1.5::float4 AS x, NULL AS s"}}
+		req, _ := http.NewRequest("POST", "/sync", strings.NewReader(query.Encode()))
+		req.Header.Add("Content-Type", "application/x-www-form-urlencoded")
+		suite.Service.Router.ServeHTTP(w, req)
+		assert.Equal(t, http.StatusOK, w.Code)
+		assert.Equal(t, "NULL,1.5\n", w.Body.String())
+		assert.Equal(t, "text/csv", w.Header().Get("Content-Type"))
+	})
+	t.Run("TestECSVQuerySuccess", func(t *testing.T) {
+		w := httptest.NewRecorder()
+		query := url.Values{"LANG": {"PSQL"}, "FORMAT": {"ecsv"}, "QUERY": {"SELECT true AS b, 1 AS n, 'test' AS s"}}
+		req, _ := http.NewRequest("POST", "/sync", strings.NewReader(query.Encode()))
+		req.Header.Add("Content-Type", "application/x-www-form-urlencoded")
+		suite.Service.Router.ServeHTTP(w, req)
+		assert.Equal(t, http.StatusOK, w.Code)
+		assert.Equal(t, "text/x-ecsv", w.Header().Get("Content-Type"))
+		assert.True(t, strings.HasPrefix(w.Body.String(), "# %ECSV 1.0\n# ---\n"))
+		assert.Contains(t, w.Body.String(), "#   - name: n\n#     datatype: int32\n")
+		assert.True(t, strings.HasSuffix(w.Body.String(), "b,n,s\nTrue,1,test\n"))
+	})
+	t.Run("TestIPACQuerySuccess", func(t *testing.T) {
+		w := httptest.NewRecorder()
+		query := url.Values{"LANG": {"PSQL"}, "RESPONSEFORMAT": {"ipac"}, "MAXREC": {"1"}, "QUERY": {"SELECT 1 AS n, 'test' AS s UNION ALL SELECT 2, 'b'"}}
+		req, _ := http.NewRequest("POST", "/sync", strings.NewReader(query.Encode()))
+		req.Header.Add("Content-Type", "application/x-www-form-urlencoded")
+		suite.Service.Router.ServeHTTP(w, req)
+		assert.Equal(t, http.StatusOK, w.Code)
+		assert.Equal(t, "text/plain", w.Header().Get("Content-Type"))
+		assert.Contains(t, w.Body.String(), "\\QUERY = 'SELECT 1 AS n, 'test' AS s UNION ALL SELECT 2, 'b''\n")
+		assert.Contains(t, w.Body.String(), "\\QUERY_STATUS = 'OVERFLOW'\n")
+		assert.True(t, strings.HasSuffix(w.Body.String(), "|n   |s   |\n|int |char|\n|    |    |\n|null|null|\n    1 test\n"))
+	})
 	t.Run("TestCSVQueryFailure", func(t *testing.T) {
 		w := httptest.NewRecorder()
 		req, _ := http.NewRequest("POST", "/sync", strings.NewReader("LANG=PSQL&&FORMAT=csv&&QUERY=SELECT * from dontexist"))
@@ -110,6 +146,22 @@ func (suite *TapSyncTestSuite) TestCSVQueries() {
 		// the default gin xml render does not show quotes
 		assert.Contains(t, w.Body.String(), "relation &#34;dontexist&#34; does not exist")
 	})
+	t.Run("TestCopyCSVMultipleStatements", func(t *testing.T) {
+		service := NewTapSyncService(NewConfig(WithDatabaseURL(suite.ConnUrl), WithCopyCSV(true)))
+		defer service.Close()
+		for _, query := range []string{
+			"SELECT 1; SELECT 2",
+			"SELECT 1) TO STDOUT; DELETE FROM test; COPY (SELECT 1",
+		} {
+			w := httptest.NewRecorder()
+			form := url.Values{"LANG": {"PSQL"}, "FORMAT": {"csv"}, "QUERY": {query}}
+			req, _ := http.NewRequest("POST", "/sync", strings.NewReader(form.Encode()))
+			req.Header.Add("Content-Type", "application/x-www-form-urlencoded")
+			service.Router.ServeHTTP(w, req)
+			assert.Equal(t, http.StatusBadRequest, w.Code, query)
+			assert.Contains(t, w.Body.String(), "Query must be a single statement", query)
+		}
+	})
 }
 
 func (suite *TapSyncTestSuite) TestVOTableQueries() {
@@ -126,7 +178,7 @@ func (suite *TapSyncTestSuite) TestVOTableQueries() {
 		<INFO name="QUERY_STATUS" value="OK"></INFO>
 		<TABLE name="results">
 			<DESCRIPTION>Results of the query</DESCRIPTION>
-			<FIELD name="?column?" datatype="char" arraysize="100*"></FIELD>
+			<FIELD name="?column?" datatype="char" arraysize="*"></FIELD>
 			<DATA>
 				<TABLEDATA>
 					<TR>
@@ -138,6 +190,36 @@ func (suite *TapSyncTestSuite) TestVOTableQueries() {
 	</RESOURCE>
 </VOTABLE>`, w.Body.String())
 	})
+	t.Run("TestVOTableQueryTypes", func(t *testing.T) {
+		query := url.QueryEscape(`SELECT 1::int4 AS i, NULL::int8 AS n, '2024-03-01 12:30:00'::timestamp AS t, ARRAY[1.5, NULL]::float8[] AS a, '\x0102'::bytea AS b, gen_random_uuid() AS u`)
+		w := httptest.NewRecorder()
+		req, _ := http.NewRequest("POST", "/sync", strings.NewReader("LANG=PSQL&&FORMAT=votable&&QUERY="+query))
+		req.Header.Add("Content-Type", "application/x-www-form-urlencoded")
+		suite.Service.Router.ServeHTTP(w, req)
+		assert.Equal(t, http.StatusOK, w.Code)
+		body := w.Body.String()
+		assert.Contains(t, body, `<FIELD name="a" datatype="double" arraysize="*"></FIELD>`)
+		assert.Contains(t, body, `<FIELD name="b" datatype="unsignedByte" arraysize="*"></FIELD>`)
+		assert.Contains(t, body, `<FIELD name="i" datatype="int"></FIELD>`)
+		assert.Contains(t, body, `<FIELD name="n" datatype="long">
+				<VALUES null="-9223372036854775808"></VALUES>
+			</FIELD>`)
+		assert.Contains(t, body, `<FIELD name="t" datatype="char" xtype="timestamp" arraysize="*"></FIELD>`)
+		assert.Contains(t, body, `<FIELD name="u" datatype="char" arraysize="36"></FIELD>`)
+		assert.Contains(t, body, "<TD>1.5 NaN</TD>")
+		assert.Contains(t, body, "<TD>1 2</TD>")
+		assert.Contains(t, body, "<TD>-9223372036854775808</TD>")
+		assert.Contains(t, body, "<TD>2024-03-01T12:30:00</TD>")
+	})
+	t.Run("TestVOTableQueryEmpty", func(t *testing.T) {
+		w := httptest.NewRecorder()
+		req, _ := http.NewRequest("POST", "/sync", strings.NewReader("LANG=PSQL&&FORMAT=votable&&QUERY=SELECT 1::int2 AS s WHERE false"))
+		req.Header.Add("Content-Type", "application/x-www-form-urlencoded")
+		suite.Service.Router.ServeHTTP(w, req)
+		assert.Equal(t, http.StatusOK, w.Code)
+		// the fields are described even without rows
+		assert.Contains(t, w.Body.String(), `<FIELD name="s" datatype="short"></FIELD>`)
+	})
 }
 
 func (suite *TapSyncTestSuite) TestFitsQueries() {
@@ -155,36 +237,19 @@ func (suite *TapSyncTestSuite) TestFitsQueries() {
 		assert.Equal(t, http.StatusOK, w.Code)
 		assert.Equal(t, "application/fits", w.Header().Get("Content-Type"))
 		// read the fits file and parse it
-		fname := writeFitsFile(t, w)
-		defer os.Remove(fname)
-		f, err := cfitsio.Open(fname, cfitsio.ReadOnly)
-		if err != nil {
-			t.Fatal(err)
-		}
-		defer f.Close()
-		hdu := f.HDU(1)
-		table := hdu.(*cfitsio.Table)
-		assert.Equal(t, "results", table.Name())
-		assert.Equal(t, 3, table.NumCols()) // id, name, number
-		assert.Equal(t, int64(1), table.NumRows())
-		assert.Equal(t, "id", table.Col(0).Name)
-		assert.Equal(t, "name", table.Col(1).Name)
-		assert.Equal(t, "number", table.Col(2).Name)
-		rows, err := table.Read(0, 2)
+		data, columns, err := parsers.ReadFits(w.Body)
 		if err != nil {
 			t.Fatal(err)
 		}
+		assert.Equal(t, 3, len(columns)) // id, name, number
+		assert.Equal(t, 1, len(data))
+		assert.Equal(t, "id", columns[0].Name)
+		assert.Equal(t, "name", columns[1].Name)
+		assert.Equal(t, "number", columns[2].Name)
 		count := 0
-		for rows.Next() {
-			var id int
-			var name string
-			var number int
-			err = rows.Scan(&id, &name, &number)
-			if err != nil {
-				t.Fatal(err)
-			}
-			assert.Equal(t, "test", name)
-			assert.Equal(t, 1, number)
+		for _, row := range data {
+			assert.Equal(t, "test", row["name"])
+			assert.Equal(t, int64(1), row["number"])
 			count++
 		}
 		assert.Equal(t, 1, count)
@@ -202,8 +267,20 @@ func (suite *TapSyncTestSuite) TestTextQueries() {
 		suite.Service.Router.ServeHTTP(w, req)
 		assert.Equal(t, http.StatusOK, w.Code)
 		assert.Equal(t, "text/plain", w.Header().Get("Content-Type"))
-		assert.Contains(t, w.Body.String(), "id | name | number")
-		assert.Contains(t, w.Body.String(), "test | 1")
+		var data, headers []string
+		assert.NoError(t, ParseTextTable(w.Body.String(), &data, &headers))
+		assert.Equal(t, []string{"id", "name", "number"}, headers)
+		assert.Contains(t, data, "test")
+		assert.Regexp(t, `\(\d+ rows?\)\nTime: \d+\.\d{3} ms\n$`, w.Body.String())
+	})
+	t.Run("TestTextQueryEmpty", func(t *testing.T) {
+		w := httptest.NewRecorder()
+		query := url.Values{"LANG": {"PSQL"}, "FORMAT": {"text"}, "QUERY": {"SELECT 1 AS n WHERE false"}}
+		req, _ := http.NewRequest("POST", "/sync", strings.NewReader(query.Encode()))
+		req.Header.Add("Content-Type", "application/x-www-form-urlencoded")
+		suite.Service.Router.ServeHTTP(w, req)
+		assert.Equal(t, http.StatusOK, w.Code)
+		assert.True(t, strings.HasPrefix(w.Body.String(), " n\n---\n(0 rows)\n"))
 	})
 }
 
@@ -218,10 +295,130 @@ func (suite *TapSyncTestSuite) TestHTMLQueries() {
 		suite.Service.Router.ServeHTTP(w, req)
 		assert.Equal(t, http.StatusOK, w.Code)
 		assert.Equal(t, "text/html", w.Header().Get("Content-Type"))
-		assert.Contains(t, w.Body.String(), "<th>id</th>")
+		assert.Contains(t, w.Body.String(), `<th class="num">id</th>`)
 		assert.Contains(t, w.Body.String(), "<th>name</th>")
-		assert.Contains(t, w.Body.String(), "<th>number</th>")
+		assert.Contains(t, w.Body.String(), `<th class="num">number</th>`)
 		assert.Contains(t, w.Body.String(), "<td>test</td>")
-		assert.Contains(t, w.Body.String(), "<td>1</td>")
+		assert.Contains(t, w.Body.String(), `<td class="num">1</td>`)
+		assert.Contains(t, w.Body.String(), "<dd><pre>SELECT * FROM test</pre></dd>")
+		assert.Contains(t, w.Body.String(), `<button type="submit" name="FORMAT" value="csv">csv</button>`)
+	})
+	t.Run("TestHTMLQueryEmpty", func(t *testing.T) {
+		w := httptest.NewRecorder()
+		query := url.Values{"LANG": {"PSQL"}, "FORMAT": {"html"}, "QUERY": {"SELECT 1 AS n WHERE false"}}
+		req, _ := http.NewRequest("POST", "/sync", strings.NewReader(query.Encode()))
+		req.Header.Add("Content-Type", "application/x-www-form-urlencoded")
+		suite.Service.Router.ServeHTTP(w, req)
+		assert.Equal(t, http.StatusOK, w.Code)
+		assert.Contains(t, w.Body.String(), `<p class="count">0 rows</p>`)
+		assert.Contains(t, w.Body.String(), `<th class="num">n</th>`)
+	})
+}
+
+func (suite *TapSyncTestSuite) TestExplainQueries() {
+	t := suite.T()
+	t.Run("TestExplainReturnsPlan", func(t *testing.T) {
+		w := httptest.NewRecorder()
+		req, _ := http.NewRequest("POST", "/sync", strings.NewReader("LANG=PSQL&&EXPLAIN=true&&QUERY=SELECT * FROM generate_series(1, 10)"))
+		req.Header.Add("Content-Type", "application/x-www-form-urlencoded")
+		suite.Service.Router.ServeHTTP(w, req)
+		assert.Equal(t, http.StatusOK, w.Code)
+		assert.Equal(t, "application/x-votable+xml", w.Header().Get("Content-Type"))
+		assert.Contains(t, w.Body.String(), `<FIELD name="node_type" datatype="char"`)
+		assert.Contains(t, w.Body.String(), "Function Scan")
+	})
+	t.Run("TestInvalidExplain", func(t *testing.T) {
+		w := httptest.NewRecorder()
+		req, _ := http.NewRequest("POST", "/sync", strings.NewReader("LANG=PSQL&&EXPLAIN=maybe&&QUERY=SELECT 1"))
+		req.Header.Add("Content-Type", "application/x-www-form-urlencoded")
+		suite.Service.Router.ServeHTTP(w, req)
+		assert.Equal(t, http.StatusBadRequest, w.Code)
+		assert.Contains(t, w.Body.String(), "Invalid EXPLAIN maybe")
 	})
+	t.Run("TestQueryOverCostLimit", func(t *testing.T) {
+		service := NewTapSyncService(NewConfig(WithDatabaseURL(suite.ConnUrl), WithLimits(LimitsConfig{MaxCost: 1000})))
+		defer service.Close()
+		w := httptest.NewRecorder()
+		req, _ := http.NewRequest("POST", "/sync", strings.NewReader("LANG=PSQL&&QUERY=SELECT * FROM generate_series(1, 1000000) a CROSS JOIN generate_series(1, 1000000) b"))
+		req.Header.Add("Content-Type", "application/x-www-form-urlencoded")
+		service.Router.ServeHTTP(w, req)
+		assert.Equal(t, http.StatusBadRequest, w.Code)
+		assert.Contains(t, w.Body.String(), "exceeds the limit of 1000")
+	})
+}
+
+func TestDescribeColumns(t *testing.T) {
+	types := map[string]string{"ra": "FLOAT8", "meanra": "FLOAT8", "n": "INT8"}
+	// SELECT 1 AS ra, o.meanra, o.ndet AS n FROM object o
+	origins := map[string]ColumnOrigin{
+		"meanra": {Table: "alerce.object", Column: "meanra"},
+		"n":      {Table: "alerce.object", Column: "ndet"},
+	}
+	columns := map[string]parsers.Column{}
+	for _, column := range describeColumns(types, origins) {
+		columns[column.Name] = column
+	}
+	assert.Equal(t, parsers.Column{Name: "ra", Type: "FLOAT8"}, columns["ra"])
+	assert.Equal(t, "deg", columns["meanra"].Unit)
+	assert.NotNil(t, columns["meanra"].Coosys)
+	assert.Equal(t, "meta.number", columns["n"].UCD)
+}
+
+func TestGetTextOptions(t *testing.T) {
+	testCases := []struct {
+		name   string
+		form   url.Values
+		format string
+		params []string
+		err    string
+	}{
+		{"none", url.Values{}, "text", nil, ""},
+		{"widths", url.Values{"TRUNCATE": {"20"}, "WRAP": {"80"}}, "text", []string{"TRUNCATE=20", "WRAP=80"}, ""},
+		{"other format", url.Values{"WRAP": {"wide"}}, "csv", nil, ""},
+		{"invalid", url.Values{"WRAP": {"wide"}}, "text", nil, "Invalid WRAP wide"},
+		{"zero", url.Values{"TRUNCATE": {"0"}}, "text", nil, "Invalid TRUNCATE 0"},
+	}
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			w := httptest.NewRecorder()
+			c, _ := gin.CreateTestContext(w)
+			c.Request, _ = http.NewRequest("POST", "/sync", strings.NewReader(tc.form.Encode()))
+			c.Request.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+			options, params, ok := getTextOptions(c, tc.format)
+			assert.Equal(t, tc.err == "", ok)
+			assert.Equal(t, tc.params, params)
+			assert.Len(t, options, len(tc.params))
+			if !ok {
+				assert.Equal(t, http.StatusBadRequest, w.Code)
+				assert.Contains(t, w.Body.String(), tc.err)
+			}
+		})
+	}
+}
+
+func TestHTMLDownloads(t *testing.T) {
+	w := httptest.NewRecorder()
+	c, _ := gin.CreateTestContext(w)
+	form := url.Values{"LANG": {"PSQL"}, "QUERY": {"SELECT 1 AS n"}, "FORMAT": {"html"}, "MAXREC": {"10"}}
+	c.Request, _ = http.NewRequest("POST", "/sync", strings.NewReader(form.Encode()))
+	c.Request.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	c.Set(htmlOptionsKey, htmlDownloads(c, []string{"votable", "html", "csv"}))
+	require.NoError(t, setResponse(c, []map[string]interface{}{{"n": int64(1)}}, nil, "html", false))
+	assert.Contains(t, w.Body.String(), `<input type="hidden" name="MAXREC" value="10">`)
+	assert.Contains(t, w.Body.String(), `<button type="submit" name="FORMAT" value="votable">votable</button>`)
+	assert.Contains(t, w.Body.String(), `<button type="submit" name="FORMAT" value="csv">csv</button>`)
+	assert.NotContains(t, w.Body.String(), `value="html"`)
+	assert.NotContains(t, w.Body.String(), `name="EXPLAIN"`)
+}
+
+func TestHTMLDownloadsDisabled(t *testing.T) {
+	w := httptest.NewRecorder()
+	c, _ := gin.CreateTestContext(w)
+	form := url.Values{"LANG": {"PSQL"}, "QUERY": {"SELECT 1 AS n"}, "FORMAT": {"html"}, "DOWNLOADS": {"false"}}
+	c.Request, _ = http.NewRequest("POST", "/sync", strings.NewReader(form.Encode()))
+	c.Request.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	c.Set(htmlOptionsKey, htmlDownloads(c, []string{"votable", "html", "csv"}))
+	require.NoError(t, setResponse(c, []map[string]interface{}{{"n": int64(1)}}, nil, "html", false))
+	assert.NotContains(t, w.Body.String(), "<form")
+	assert.NotContains(t, w.Body.String(), `name="FORMAT"`)
 }
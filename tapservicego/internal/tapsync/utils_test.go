@@ -61,26 +61,27 @@ func ParseHTMLTable(doc *html.Node, data *[]string, tag string) {
 	traverse(doc, tag)
 }
 
+// ParseTextTable reads the headers and the values of a text table,
+// the names of the columns being in the first line, over the
+// separator, and the footer after the values
 func ParseTextTable(doc string, data *[]string, headers *[]string) error {
 	scanner := bufio.NewScanner(strings.NewReader(doc))
-	isHeader := false
-	for scanner.Scan() {
+	for i := 0; scanner.Scan(); i++ {
 		line := scanner.Text()
-		isComment := strings.HasPrefix(line, "#")
-		if isComment {
-			if isHeader {
-				h := strings.Split(line, "|")
-				for _, header := range h {
-					header = strings.Replace(header, "#", "", -1)
-					*headers = append(*headers, strings.TrimSpace(header))
-				}
-			}
-			isHeader = strings.Contains(line, "Headers")
+		if i == 1 || line == "" {
+			continue
+		}
+		if strings.HasPrefix(line, "(") || strings.HasPrefix(line, "Time:") {
+			break
+		}
+		cells := strings.Split(line, " | ")
+		for j := range cells {
+			cells[j] = strings.TrimSpace(cells[j])
+		}
+		if i == 0 {
+			*headers = append(*headers, cells...)
 		} else {
-			row := strings.Split(line, "|")
-			for _, cell := range row {
-				*data = append(*data, strings.TrimSpace(cell))
-			}
+			*data = append(*data, cells...)
 		}
 	}
 	if err := scanner.Err(); err != nil {
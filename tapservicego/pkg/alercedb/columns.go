@@ -0,0 +1,116 @@
+package alercedb
+
+import (
+	"ataps/pkg/votable"
+	"strings"
+)
+
+// ColumnMetadata describes the meaning of a column of the ALeRCE tables,
+// using the vocabularies of the IVOA: units of VOUnits, UCD1+ words,
+// utypes of STC and xtypes of VOTable
+type ColumnMetadata struct {
+	Unit        string
+	UCD         string
+	Utype       string
+	Xtype       string
+	Description string
+	// Coosys is the coordinate system of positions
+	Coosys *votable.Coosys
+	// Timesys is the time system of times
+	Timesys *votable.Timesys
+}
+
+// ICRS is the coordinate system of the positions of the alerts
+var ICRS = &votable.Coosys{ID: "icrs", System: "ICRS"}
+
+// MJDTime is the time system of the Modified Julian Dates
+// of the observations, in UTC at the observatory
+var MJDTime = &votable.Timesys{ID: "mjd_utc", TimeOrigin: "MJD-origin", TimeScale: "UTC", RefPosition: "TOPOCENTER"}
+
+// TableNames are the ALeRCE tables, in the order
+// their columns are looked up by LookupColumn
+var TableNames = []string{"object", "detection", "forced_photometry", "non_detection", "feature", "probability"}
+
+var (
+	oidColumn        = ColumnMetadata{UCD: "meta.id;meta.main", Description: "ALeRCE object identifier"}
+	candidColumn     = ColumnMetadata{UCD: "meta.id", Description: "Identifier of the alert of the detection"}
+	mjdColumn        = ColumnMetadata{Unit: "d", UCD: "time.epoch", Utype: "stc:AstroCoords.Time.TimeInstant.MJDTime", Description: "Modified Julian Date of the observation", Timesys: MJDTime}
+	fidColumn        = ColumnMetadata{UCD: "instr.filter", Description: "Filter identifier: 1 for g, 2 for r and 3 for i"}
+	diffmaglimColumn = ColumnMetadata{Unit: "mag", UCD: "phot.mag;stat.max", Description: "5 sigma limiting magnitude of the difference image"}
+)
+
+// photometryColumns are the columns shared by
+// the detection and forced_photometry tables
+var photometryColumns = map[string]ColumnMetadata{
+	"candid":            candidColumn,
+	"oid":               oidColumn,
+	"mjd":               mjdColumn,
+	"fid":               fidColumn,
+	"pid":               {UCD: "meta.id", Description: "Identifier of the processing of the image"},
+	"diffmaglim":        diffmaglimColumn,
+	"isdiffpos":         {UCD: "meta.code", Description: "1 if the source is brighter than in the reference image, -1 otherwise"},
+	"ra":                {Unit: "deg", UCD: "pos.eq.ra", Utype: "stc:AstroCoords.Position2D.Value2.C1", Description: "Right ascension of the detection", Coosys: ICRS},
+	"dec":               {Unit: "deg", UCD: "pos.eq.dec", Utype: "stc:AstroCoords.Position2D.Value2.C2", Description: "Declination of the detection", Coosys: ICRS},
+	"magpsf":            {Unit: "mag", UCD: "phot.mag", Description: "PSF magnitude in the difference image"},
+	"sigmapsf":          {Unit: "mag", UCD: "stat.error;phot.mag", Description: "Error of the PSF magnitude"},
+	"magpsf_corr":       {Unit: "mag", UCD: "phot.mag", Description: "PSF magnitude corrected with the reference image"},
+	"sigmapsf_corr":     {Unit: "mag", UCD: "stat.error;phot.mag", Description: "Error of the corrected PSF magnitude"},
+	"sigmapsf_corr_ext": {Unit: "mag", UCD: "stat.error;phot.mag", Description: "Error of the corrected PSF magnitude of extended sources"},
+	"distnr":            {Unit: "arcsec", UCD: "pos.angDistance", Description: "Distance to the nearest source in the reference image"},
+	"corrected":         {UCD: "meta.code", Description: "Whether the magnitude was corrected with the reference image"},
+	"dubious":           {UCD: "meta.code.qual", Description: "Whether the correction is dubious"},
+	"parent_candid":     {UCD: "meta.id", Description: "Identifier of the alert that reported the detection"},
+	"has_stamp":         {UCD: "meta.code", Description: "Whether the alert has image stamps"},
+}
+
+// Columns holds the metadata of the columns of each ALeRCE table
+var Columns = map[string]map[string]ColumnMetadata{
+	"object": {
+		"oid":       oidColumn,
+		"meanra":    {Unit: "deg", UCD: "pos.eq.ra;meta.main", Utype: "stc:AstroCoords.Position2D.Value2.C1", Description: "Mean right ascension of the detections", Coosys: ICRS},
+		"meandec":   {Unit: "deg", UCD: "pos.eq.dec;meta.main", Utype: "stc:AstroCoords.Position2D.Value2.C2", Description: "Mean declination of the detections", Coosys: ICRS},
+		"sigmara":   {Unit: "deg", UCD: "stat.stdev;pos.eq.ra", Description: "Standard deviation of the right ascension of the detections"},
+		"sigmadec":  {Unit: "deg", UCD: "stat.stdev;pos.eq.dec", Description: "Standard deviation of the declination of the detections"},
+		"firstmjd":  {Unit: "d", UCD: "time.epoch;stat.min", Description: "Modified Julian Date of the first detection", Timesys: MJDTime},
+		"lastmjd":   {Unit: "d", UCD: "time.epoch;stat.max", Description: "Modified Julian Date of the last detection", Timesys: MJDTime},
+		"ndet":      {UCD: "meta.number", Description: "Number of detections"},
+		"stellar":   {UCD: "src.class.starGalaxy", Description: "Whether the object is likely a star"},
+		"corrected": {UCD: "meta.code", Description: "Whether the magnitudes of the object were corrected"},
+	},
+	"detection":         photometryColumns,
+	"forced_photometry": photometryColumns,
+	"non_detection": {
+		"oid":        oidColumn,
+		"fid":        fidColumn,
+		"mjd":        mjdColumn,
+		"diffmaglim": diffmaglimColumn,
+	},
+	"feature": {
+		"oid":     oidColumn,
+		"name":    {UCD: "meta.id", Description: "Name of the feature"},
+		"value":   {UCD: "stat.value", Description: "Value of the feature"},
+		"fid":     fidColumn,
+		"version": {UCD: "meta.version", Description: "Version of the features pipeline"},
+	},
+	"probability": {
+		"oid":                oidColumn,
+		"class_name":         {UCD: "src.class", Description: "Name of the class"},
+		"classifier_name":    {UCD: "meta.id", Description: "Name of the classifier"},
+		"classifier_version": {UCD: "meta.version", Description: "Version of the classifier"},
+		"probability":        {UCD: "stat.probability", Description: "Probability of the object belonging to the class"},
+		"ranking":            {UCD: "meta.number", Description: "Ranking of the class among the classes of the classifier"},
+	},
+}
+
+// LookupColumn returns the metadata of a column of a table, which can
+// be qualified by its schema. Only the columns of the ALeRCE tables are
+// found, so the columns of a result should be looked up by the table
+// and the column they come from, when they are known: a column renamed
+// by a query keeps its metadata, while a computed one has none.
+func LookupColumn(table string, name string) (ColumnMetadata, bool) {
+	if i := strings.LastIndexByte(table, '.'); i >= 0 {
+		table = table[i+1:]
+	}
+	column, ok := Columns[table][name]
+	return column, ok
+}
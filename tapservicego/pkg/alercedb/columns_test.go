@@ -0,0 +1,68 @@
+package alercedb
+
+import (
+	"reflect"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestColumnsDescribeEveryModel(t *testing.T) {
+	models := map[string]interface{}{
+		"object":            Object{},
+		"detection":         Detection{},
+		"forced_photometry": ForcedPhotometry{},
+		"non_detection":     NonDetection{},
+		"feature":           Feature{},
+		"probability":       Probability{},
+	}
+	assert.Len(t, TableNames, len(models))
+	for table, model := range models {
+		modelType := reflect.TypeOf(model)
+		assert.Len(t, Columns[table], modelType.NumField(), table)
+		for i := 0; i < modelType.NumField(); i++ {
+			name := modelType.Field(i).Tag.Get("db")
+			column, ok := Columns[table][name]
+			if assert.True(t, ok, "%s.%s", table, name) {
+				assert.NotEmpty(t, column.Description, "%s.%s", table, name)
+				assert.NotEmpty(t, column.UCD, "%s.%s", table, name)
+			}
+		}
+	}
+}
+
+func TestLookupColumn(t *testing.T) {
+	column, ok := LookupColumn("object", "meanra")
+	assert.True(t, ok)
+	assert.Equal(t, "deg", column.Unit)
+	column, ok = LookupColumn("alerce.feature", "fid")
+	assert.True(t, ok)
+	assert.Equal(t, "instr.filter", column.UCD)
+	column, _ = LookupColumn("object", "corrected")
+	assert.Equal(t, Columns["object"]["corrected"], column)
+	column, _ = LookupColumn("public.detection", "corrected")
+	assert.Equal(t, Columns["detection"]["corrected"], column)
+	// only the columns of the table are found
+	_, ok = LookupColumn("object", "ra")
+	assert.False(t, ok)
+	_, ok = LookupColumn("test", "meanra")
+	assert.False(t, ok)
+	_, ok = LookupColumn("", "meanra")
+	assert.False(t, ok)
+}
+
+func TestColumnSystems(t *testing.T) {
+	for _, column := range []string{"object.meanra", "object.meandec", "detection.ra", "detection.dec"} {
+		table, name, _ := strings.Cut(column, ".")
+		metadata, _ := LookupColumn(table, name)
+		assert.Same(t, ICRS, metadata.Coosys, column)
+	}
+	for _, column := range []string{"detection.mjd", "object.firstmjd", "object.lastmjd"} {
+		table, name, _ := strings.Cut(column, ".")
+		metadata, _ := LookupColumn(table, name)
+		assert.Same(t, MJDTime, metadata.Timesys, column)
+	}
+	column, _ := LookupColumn("object", "sigmara")
+	assert.Nil(t, column.Coosys)
+}
@@ -0,0 +1,133 @@
+// Package fits writes FITS files with a binary table extension, and
+// reads the tables of FITS files, following the FITS Standard 4.0.
+package fits
+
+import (
+	"fmt"
+	"io"
+	"strconv"
+	"strings"
+)
+
+// FITS files are made of blocks of 2880 bytes,
+// and headers of cards of 80 characters
+const (
+	BlockSize = 2880
+	CardSize  = 80
+)
+
+// Card is a keyword record of a header. The value can be a bool,
+// an integer, a float or a string, or nil for cards without value.
+type Card struct {
+	Keyword string
+	Value   interface{}
+	Comment string
+}
+
+// String formats the card in the fixed format, as 80 characters.
+// Strings are truncated to fit in the card, and their characters
+// other than printable ASCII are replaced by "?". The comment is
+// truncated, or left out when there is no room for it.
+func (c Card) String() string {
+	var b strings.Builder
+	switch value := c.Value.(type) {
+	case nil:
+		b.WriteString(fmt.Sprintf("%-8s", c.Keyword))
+		if c.Comment != "" {
+			b.WriteString("  " + c.Comment)
+		}
+		return padCard(b.String())
+	case bool:
+		logical := "F"
+		if value {
+			logical = "T"
+		}
+		b.WriteString(fmt.Sprintf("%-8s= %20s", c.Keyword, logical))
+	case int:
+		b.WriteString(fmt.Sprintf("%-8s= %20d", c.Keyword, value))
+	case int64:
+		b.WriteString(fmt.Sprintf("%-8s= %20d", c.Keyword, value))
+	case float64:
+		b.WriteString(fmt.Sprintf("%-8s= %20s", c.Keyword, formatFloat(value)))
+	case string:
+		b.WriteString(fmt.Sprintf("%-8s= ", c.Keyword))
+		b.WriteString(quoteString(value))
+	default:
+		panic(fmt.Sprintf("fits: unsupported value %T of %s", value, c.Keyword))
+	}
+	if c.Comment != "" && b.Len()+3 < CardSize {
+		b.WriteString(" / " + ASCIIString(c.Comment))
+	}
+	return padCard(b.String())
+}
+
+// quoteString quotes a string value, padding it to 8 characters,
+// as the standard requires for the values of XTENSION and TFORMn,
+// and truncating it to fit in a card after the keyword
+func quoteString(value string) string {
+	value = strings.ReplaceAll(ASCIIString(value), "'", "''")
+	// the value starts at the column 11, and leaves room for the quotes
+	if maxLength := CardSize - 10 - 2; len(value) > maxLength {
+		value = value[:maxLength]
+		// do not split an escaped quote
+		if strings.Count(value, "'")%2 == 1 {
+			value = value[:len(value)-1]
+		}
+	}
+	return fmt.Sprintf("'%-8s'", value)
+}
+
+// ASCIIString replaces the characters other than printable ASCII by "?",
+// as the values of the header and of the character fields must be
+// printable ASCII. Each character takes a single byte once replaced.
+func ASCIIString(value string) string {
+	return strings.Map(func(r rune) rune {
+		if r < ' ' || r > '~' {
+			return '?'
+		}
+		return r
+	}, value)
+}
+
+// formatFloat formats a float with a decimal point or an exponent,
+// so it is not read as an integer
+func formatFloat(value float64) string {
+	formatted := strconv.FormatFloat(value, 'G', -1, 64)
+	if !strings.ContainsAny(formatted, ".EN") {
+		formatted += "."
+	}
+	return formatted
+}
+
+func padCard(card string) string {
+	if len(card) > CardSize {
+		return card[:CardSize]
+	}
+	return fmt.Sprintf("%-80s", card)
+}
+
+// Header is the list of cards of a header, without its END card
+type Header []Card
+
+// Size returns the size of the header written, padded to a whole block
+func (h Header) Size() int64 {
+	return paddedSize(int64(len(h)+1) * CardSize)
+}
+
+// WriteTo writes the cards and the END card of the header,
+// padded with blanks to a whole block
+func (h Header) WriteTo(w io.Writer) (int64, error) {
+	var b strings.Builder
+	for _, card := range h {
+		b.WriteString(card.String())
+	}
+	b.WriteString(padCard("END"))
+	b.WriteString(strings.Repeat(" ", int(h.Size())-b.Len()))
+	n, err := io.WriteString(w, b.String())
+	return int64(n), err
+}
+
+// paddedSize rounds the size up to a whole block
+func paddedSize(size int64) int64 {
+	return (size + BlockSize - 1) / BlockSize * BlockSize
+}
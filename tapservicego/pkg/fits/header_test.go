@@ -0,0 +1,57 @@
+package fits
+
+import (
+	"bytes"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestCardString(t *testing.T) {
+	testCases := []struct {
+		card     Card
+		expected string
+	}{
+		{Card{Keyword: "SIMPLE", Value: true}, "SIMPLE  =                    T"},
+		{Card{Keyword: "EXTEND", Value: false}, "EXTEND  =                    F"},
+		{Card{Keyword: "NAXIS1", Value: 20, Comment: "width"}, "NAXIS1  =                   20 / width"},
+		{Card{Keyword: "NAXIS2", Value: int64(1 << 40)}, "NAXIS2  =        1099511627776"},
+		{Card{Keyword: "TZERO1", Value: 32768.0}, "TZERO1  =               32768."},
+		{Card{Keyword: "TSCAL1", Value: 0.5}, "TSCAL1  =                  0.5"},
+		{Card{Keyword: "XTENSION", Value: "BINTABLE"}, "XTENSION= 'BINTABLE'"},
+		// strings are padded to 8 characters
+		{Card{Keyword: "TFORM1", Value: "K"}, "TFORM1  = 'K       '"},
+		{Card{Keyword: "TCOMM1", Value: "Object's mean declination"}, "TCOMM1  = 'Object''s mean declination'"},
+		{Card{Keyword: "TCOMM1", Value: "aé"}, "TCOMM1  = 'a?      '"},
+		{Card{Keyword: "COMMENT", Comment: "a comment"}, "COMMENT   a comment"},
+	}
+	for _, tc := range testCases {
+		card := tc.card.String()
+		assert.Len(t, card, CardSize)
+		assert.Equal(t, tc.expected, strings.TrimRight(card, " "))
+	}
+}
+
+func TestCardStringTruncates(t *testing.T) {
+	card := Card{Keyword: "TCOMM1", Value: strings.Repeat("a", 100), Comment: "left out"}.String()
+	assert.Len(t, card, CardSize)
+	assert.True(t, strings.HasSuffix(card, "a'"))
+	// an escaped quote is not split
+	card = Card{Keyword: "TCOMM1", Value: strings.Repeat("a", 67) + "'b"}.String()
+	assert.True(t, strings.HasSuffix(strings.TrimRight(card, " "), "a'"), card)
+}
+
+func TestHeaderWriteTo(t *testing.T) {
+	header := Header{{Keyword: "SIMPLE", Value: true}, {Keyword: "NAXIS", Value: 0}}
+	var buffer bytes.Buffer
+	n, err := header.WriteTo(&buffer)
+	require.NoError(t, err)
+	assert.Equal(t, int64(BlockSize), n)
+	assert.Equal(t, header.Size(), n)
+	assert.Equal(t, "END", strings.TrimRight(buffer.String()[2*CardSize:3*CardSize], " "))
+	assert.Equal(t, strings.Repeat(" ", BlockSize-3*CardSize), buffer.String()[3*CardSize:])
+	// 36 cards and the END card take two blocks
+	assert.Equal(t, int64(2*BlockSize), make(Header, 36).Size())
+}
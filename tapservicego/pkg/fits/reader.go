@@ -0,0 +1,646 @@
+package fits
+
+import (
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"math"
+	"regexp"
+	"strconv"
+	"strings"
+)
+
+// Table is a table read from a FITS file
+type Table struct {
+	// Name is the EXTNAME of the table
+	Name   string
+	Header Header
+	// Columns are described by their TTYPEn, TFORMn, TNULLn of binary
+	// tables, TUNITn, TUCDn, TUTYPn and TCOMMn keywords
+	Columns []Column
+	// Rows hold a value for each column, see ReadTable
+	Rows [][]interface{}
+}
+
+// ReadTable reads the first table extension of a FITS file, a binary
+// table or an ASCII table, skipping the HDUs before it.
+// The values of the rows are bools, int64, float64 and strings, with
+// the trailing blanks of strings removed, and the columns with a repeat
+// count other than one, bits or variable-length arrays are slices of
+// their elements. Integers are scaled by their TSCALn and TZEROn,
+// being float64 unless the scale is one and the zero an integer, and
+// floats too. NULLs, which are the TNULLn of integers and of the fields
+// of ASCII tables, blank numbers of ASCII tables, NaN and undefined
+// logical values, are nil.
+func ReadTable(r io.Reader) (*Table, error) {
+	for {
+		header, err := ReadHeader(r)
+		if err == io.EOF {
+			return nil, fmt.Errorf("No table in the FITS file")
+		}
+		if err != nil {
+			return nil, err
+		}
+		size, err := header.dataSize()
+		if err != nil {
+			return nil, err
+		}
+		xtension, _ := header.Get("XTENSION")
+		if xtension == "BINTABLE" || xtension == "TABLE" {
+			// the data is read as it comes rather than allocated
+			// from the size in the header, which can be made up,
+			// and the padding of the last HDU is not needed
+			data, err := io.ReadAll(io.LimitReader(r, size))
+			if err != nil {
+				return nil, fmt.Errorf("Reading FITS table: %w", err)
+			}
+			if int64(len(data)) < size {
+				return nil, fmt.Errorf("Reading FITS table: %w", io.ErrUnexpectedEOF)
+			}
+			if xtension == "TABLE" {
+				return decodeASCIITable(header, data)
+			}
+			return decodeBinaryTable(header, data)
+		}
+		if _, err := io.CopyN(io.Discard, r, paddedSize(size)); err != nil {
+			return nil, fmt.Errorf("Reading FITS data: %w", err)
+		}
+	}
+}
+
+// ReadHeader reads a header up to its END card, and the blanks padding
+// it to a whole block. It returns io.EOF when there is no header left.
+func ReadHeader(r io.Reader) (Header, error) {
+	var header Header
+	block := make([]byte, BlockSize)
+	for {
+		if _, err := io.ReadFull(r, block); err != nil {
+			if err == io.EOF && header == nil {
+				return nil, io.EOF
+			}
+			return nil, fmt.Errorf("Reading FITS header: %w", err)
+		}
+		for i := 0; i < BlockSize; i += CardSize {
+			card, err := parseCard(string(block[i : i+CardSize]))
+			if err != nil {
+				return nil, err
+			}
+			if card.Keyword == "END" {
+				return header, nil
+			}
+			if card.Keyword != "" || card.Comment != "" {
+				header = append(header, card)
+			}
+		}
+	}
+}
+
+// Get returns the value of the first card with the keyword
+func (h Header) Get(keyword string) (interface{}, bool) {
+	for _, card := range h {
+		if card.Keyword == keyword {
+			return card.Value, true
+		}
+	}
+	return nil, false
+}
+
+// getInt returns the integer value of the keyword, or def without it
+func (h Header) getInt(keyword string, def int64) (int64, error) {
+	value, ok := h.Get(keyword)
+	if !ok {
+		return def, nil
+	}
+	i, ok := value.(int64)
+	if !ok {
+		return 0, fmt.Errorf("Invalid FITS keyword %s: %v is not an integer", keyword, value)
+	}
+	return i, nil
+}
+
+// getFloat returns the numeric value of the keyword, or def without it
+func (h Header) getFloat(keyword string, def float64) (float64, error) {
+	value, ok := h.Get(keyword)
+	if !ok {
+		return def, nil
+	}
+	switch value := value.(type) {
+	case int64:
+		return float64(value), nil
+	case float64:
+		return value, nil
+	}
+	return 0, fmt.Errorf("Invalid FITS keyword %s: %v is not a number", keyword, value)
+}
+
+// getString returns the string value of the keyword, or "" without it
+func (h Header) getString(keyword string) string {
+	value, _ := h.Get(keyword)
+	s, _ := value.(string)
+	return s
+}
+
+// getCount returns the value of the keyword, or def without it,
+// which can not be negative nor greater than max
+func (h Header) getCount(keyword string, def int64, max int64) (int64, error) {
+	n, err := h.getInt(keyword, def)
+	if err != nil {
+		return 0, err
+	}
+	if n < 0 || n > max {
+		return 0, fmt.Errorf("Invalid FITS keyword %s: %d is out of range", keyword, n)
+	}
+	return n, nil
+}
+
+// dataSize returns the size of the data of the HDU, without padding
+func (h Header) dataSize() (int64, error) {
+	bitpix, err := h.getInt("BITPIX", 0)
+	if err != nil {
+		return 0, err
+	}
+	switch bitpix {
+	case 8, 16, 32, 64, -32, -64:
+	default:
+		return 0, fmt.Errorf("Invalid FITS keyword BITPIX: %d", bitpix)
+	}
+	naxis, err := h.getCount("NAXIS", 0, 999)
+	if err != nil || naxis == 0 {
+		return 0, err
+	}
+	size := int64(1)
+	for i := int64(1); i <= naxis; i++ {
+		n, err := h.getCount("NAXIS"+strconv.FormatInt(i, 10), 0, math.MaxInt64)
+		if err != nil {
+			return 0, err
+		}
+		// the random groups have no NAXIS1
+		if i == 1 && n == 0 && h.getBool("GROUPS") {
+			continue
+		}
+		if size, err = multiply(size, n); err != nil {
+			return 0, err
+		}
+	}
+	pcount, err := h.getCount("PCOUNT", 0, math.MaxInt64-size)
+	if err != nil {
+		return 0, err
+	}
+	gcount, err := h.getCount("GCOUNT", 1, math.MaxInt64)
+	if err != nil {
+		return 0, err
+	}
+	if bitpix < 0 {
+		bitpix = -bitpix
+	}
+	if size, err = multiply(gcount, pcount+size); err != nil {
+		return 0, err
+	}
+	return multiply(bitpix/8, size)
+}
+
+// multiply returns the product of two sizes,
+// or an error when it overflows
+func multiply(a int64, b int64) (int64, error) {
+	if a != 0 && b > math.MaxInt64/a {
+		return 0, fmt.Errorf("Invalid FITS header: the size of the data overflows")
+	}
+	return a * b, nil
+}
+
+// tableSize returns the width of the rows of a table and their number,
+// checking that they fit in the data
+func (h Header) tableSize(data []byte) (int64, int64, error) {
+	width, err := h.getCount("NAXIS1", 0, math.MaxInt64)
+	if err != nil {
+		return 0, 0, err
+	}
+	rows, err := h.getCount("NAXIS2", 0, math.MaxInt64)
+	if err != nil {
+		return 0, 0, err
+	}
+	size, err := multiply(width, rows)
+	if err != nil {
+		return 0, 0, err
+	}
+	// rows without bytes would be read without reading the data
+	if width == 0 && rows > 0 {
+		return 0, 0, fmt.Errorf("Invalid FITS header: %d rows of 0 bytes", rows)
+	}
+	if size > int64(len(data)) {
+		return 0, 0, fmt.Errorf("Invalid FITS header: %d rows of %d bytes do not fit in %d bytes of data", rows, width, len(data))
+	}
+	return width, rows, nil
+}
+
+func (h Header) getBool(keyword string) bool {
+	value, _ := h.Get(keyword)
+	b, _ := value.(bool)
+	return b
+}
+
+// parseCard parses a card of 80 characters
+func parseCard(s string) (Card, error) {
+	keyword := strings.TrimRight(s[:8], " ")
+	if s[8:10] != "= " {
+		return Card{Keyword: keyword, Comment: strings.TrimSpace(s[8:])}, nil
+	}
+	value, comment, err := parseValue(s[10:])
+	if err != nil {
+		return Card{}, fmt.Errorf("Invalid FITS keyword %s: %w", keyword, err)
+	}
+	return Card{Keyword: keyword, Value: value, Comment: comment}, nil
+}
+
+// parseValue parses the value and the comment of a card. Strings keep
+// their leading blanks, integers are int64, other numbers float64 and
+// values of other types, such as complex numbers, are left as strings.
+func parseValue(s string) (interface{}, string, error) {
+	s = strings.TrimLeft(s, " ")
+	var value interface{}
+	if strings.HasPrefix(s, "'") {
+		var b strings.Builder
+		i := 1
+		for ; i < len(s); i++ {
+			if s[i] != '\'' {
+				b.WriteByte(s[i])
+				continue
+			}
+			// an escaped quote
+			if i+1 < len(s) && s[i+1] == '\'' {
+				b.WriteByte('\'')
+				i++
+				continue
+			}
+			break
+		}
+		if i >= len(s) {
+			return nil, "", errors.New("unterminated string")
+		}
+		value = strings.TrimRight(b.String(), " ")
+		s = s[i+1:]
+	}
+	token, comment, _ := strings.Cut(s, "/")
+	comment = strings.TrimSpace(comment)
+	if value != nil {
+		return value, comment, nil
+	}
+	token = strings.TrimSpace(token)
+	switch token {
+	case "":
+		return nil, comment, nil
+	case "T":
+		return true, comment, nil
+	case "F":
+		return false, comment, nil
+	}
+	if i, err := strconv.ParseInt(token, 10, 64); err == nil {
+		return i, comment, nil
+	}
+	if f, err := strconv.ParseFloat(strings.NewReplacer("D", "E", "d", "e").Replace(token), 64); err == nil {
+		return f, comment, nil
+	}
+	return token, comment, nil
+}
+
+// binaryFormatPattern matches the TFORMn of binary tables: a repeat
+// count and a type code, followed for the descriptors of variable-length
+// arrays, P and Q, by the type code of their elements, which they
+// require, and their maximum length
+var binaryFormatPattern = regexp.MustCompile(`^(\d*)(?:([LXBIJKAEDCM])\d*|([PQ])([LXBIJKAEDCM])(?:\(\d*\))?)$`)
+
+// readSizes are the sizes in bytes of the elements of each type code
+// that can be read, including the descriptors P and Q
+var readSizes = map[byte]int{
+	'L': 1,
+	'B': 1,
+	'I': 2,
+	'J': 4,
+	'K': 8,
+	'E': 4,
+	'D': 8,
+	'A': 1,
+	'P': 8,
+	'Q': 16,
+}
+
+// columnReader decodes the values of a column
+type columnReader struct {
+	name   string
+	repeat int
+	code   byte
+	// element is the type code of variable-length arrays
+	element byte
+	// offset is the position of the field in the rows, and width its size
+	offset int
+	width  int
+	null   *int64
+	// nullString is the TNULLn of ASCII tables
+	nullString  string
+	scale, zero float64
+	// decimals is the number of decimals of Fw.d formats of ASCII tables
+	decimals int
+}
+
+// readColumns returns the columns of a table, and their readers
+// with their scaling and TNULLn, leaving their formats to parse
+func readColumns(header Header) ([]Column, []columnReader, error) {
+	tfields, err := header.getCount("TFIELDS", 0, 999)
+	if err != nil {
+		return nil, nil, err
+	}
+	columns := make([]Column, tfields)
+	readers := make([]columnReader, tfields)
+	for i := range columns {
+		n := strconv.Itoa(i + 1)
+		columns[i] = Column{
+			Name:        header.getString("TTYPE" + n),
+			Format:      strings.TrimSpace(header.getString("TFORM" + n)),
+			Unit:        header.getString("TUNIT" + n),
+			UCD:         header.getString("TUCD" + n),
+			Utype:       header.getString("TUTYP" + n),
+			Description: header.getString("TCOMM" + n),
+		}
+		reader := &readers[i]
+		reader.name = columns[i].Name
+		if reader.scale, err = header.getFloat("TSCAL"+n, 1); err != nil {
+			return nil, nil, err
+		}
+		if reader.zero, err = header.getFloat("TZERO"+n, 0); err != nil {
+			return nil, nil, err
+		}
+		switch null, _ := header.Get("TNULL" + n); null := null.(type) {
+		case int64:
+			reader.null = &null
+			columns[i].Null = &null
+		case string:
+			reader.nullString = strings.TrimSpace(null)
+		}
+	}
+	return columns, readers, nil
+}
+
+// decodeBinaryTable decodes the rows and the heap of a binary table
+func decodeBinaryTable(header Header, data []byte) (*Table, error) {
+	columns, readers, err := readColumns(header)
+	if err != nil {
+		return nil, err
+	}
+	width, rows, err := header.tableSize(data)
+	if err != nil {
+		return nil, err
+	}
+	theap, err := header.getInt("THEAP", width*rows)
+	if err != nil {
+		return nil, err
+	}
+	if theap < width*rows || theap > int64(len(data)) {
+		return nil, fmt.Errorf("Invalid FITS keyword THEAP: %d is out of the heap", theap)
+	}
+	heap := data[theap:]
+	offset := int64(0)
+	for i := range readers {
+		reader := &readers[i]
+		match := binaryFormatPattern.FindStringSubmatch(columns[i].Format)
+		if match == nil {
+			return nil, fmt.Errorf("Column %s: Unsupported FITS column format %s", reader.name, columns[i].Format)
+		}
+		repeat := int64(1)
+		if match[1] != "" {
+			if repeat, err = strconv.ParseInt(match[1], 10, 64); err != nil {
+				return nil, fmt.Errorf("Column %s: Invalid FITS column format %s", reader.name, columns[i].Format)
+			}
+		}
+		if match[2] != "" {
+			reader.code = match[2][0]
+		} else {
+			reader.code, reader.element = match[3][0], match[4][0]
+		}
+		// the fields must fit in the rows, which is checked
+		// before computing their width so it can not overflow
+		left := width - offset
+		switch size := int64(readSizes[reader.code]); {
+		case reader.code == 'X':
+			if repeat/8 > left {
+				return nil, fmt.Errorf("Invalid FITS keyword NAXIS1: %d is narrower than the columns", width)
+			}
+			reader.width = int(repeat/8 + min(repeat%8, 1))
+		case size == 0, reader.element != 0 && reader.element != 'X' && readSizes[reader.element] == 0:
+			return nil, fmt.Errorf("Column %s: Unsupported FITS column format %s", reader.name, columns[i].Format)
+		case repeat > left/size:
+			return nil, fmt.Errorf("Invalid FITS keyword NAXIS1: %d is narrower than the columns", width)
+		default:
+			reader.width = int(repeat * size)
+		}
+		reader.repeat = int(repeat)
+		reader.offset = int(offset)
+		offset += int64(reader.width)
+	}
+	if offset != width {
+		return nil, fmt.Errorf("Invalid FITS keyword NAXIS1: %d for columns of %d bytes", width, offset)
+	}
+	table := &Table{Name: header.getString("EXTNAME"), Header: header, Columns: columns}
+	for i := int64(0); i < rows; i++ {
+		row := data[i*width : (i+1)*width]
+		values := make([]interface{}, len(readers))
+		for j, reader := range readers {
+			field := row[reader.offset : reader.offset+reader.width]
+			if values[j], err = reader.decodeField(field, heap); err != nil {
+				return nil, fmt.Errorf("Column %s: %w", reader.name, err)
+			}
+		}
+		table.Rows = append(table.Rows, values)
+	}
+	return table, nil
+}
+
+// decodeField decodes a field of a binary table, reading
+// the elements of variable-length arrays from the heap
+func (c columnReader) decodeField(field []byte, heap []byte) (interface{}, error) {
+	code, repeat := c.code, c.repeat
+	switch code {
+	case 'P', 'Q':
+		if repeat == 0 {
+			return nil, nil
+		}
+		var count, offset uint64
+		if code == 'P' {
+			count, offset = uint64(binary.BigEndian.Uint32(field)), uint64(binary.BigEndian.Uint32(field[4:]))
+		} else {
+			count, offset = binary.BigEndian.Uint64(field), binary.BigEndian.Uint64(field[8:])
+		}
+		// the counts are checked before computing the size of the
+		// array, as each element takes a byte at least, or a bit
+		heapSize := uint64(len(heap))
+		if count > 8*heapSize || offset > heapSize {
+			return nil, fmt.Errorf("Array of %d elements at %d is out of the heap", count, offset)
+		}
+		code, repeat = c.element, int(count)
+		size := count * uint64(readSizes[code])
+		if code == 'X' {
+			size = (count + 7) / 8
+		}
+		if size > heapSize-offset {
+			return nil, fmt.Errorf("Array of %d elements at %d is out of the heap", count, offset)
+		}
+		field = heap[offset : offset+size]
+		return c.decodeElements(code, repeat, field, true), nil
+	}
+	return c.decodeElements(code, repeat, field, false), nil
+}
+
+// decodeElements decodes repeat elements of the type code, which are
+// a slice unless there is one, and the column is not an array
+func (c columnReader) decodeElements(code byte, repeat int, field []byte, array bool) interface{} {
+	switch code {
+	case 'A':
+		s, _, _ := strings.Cut(string(field), "\x00")
+		return strings.TrimRight(s, " ")
+	case 'X':
+		bits := make([]interface{}, repeat)
+		for i := range bits {
+			bits[i] = field[i/8]&(0x80>>(i%8)) != 0
+		}
+		return bits
+	}
+	size := readSizes[code]
+	if repeat == 1 && !array {
+		return c.decodeElement(code, field)
+	}
+	elements := make([]interface{}, repeat)
+	for i := range elements {
+		elements[i] = c.decodeElement(code, field[i*size:(i+1)*size])
+	}
+	return elements
+}
+
+// decodeElement decodes a single element, scaling it
+func (c columnReader) decodeElement(code byte, b []byte) interface{} {
+	switch code {
+	case 'L':
+		switch b[0] {
+		case 'T':
+			return true
+		case 'F':
+			return false
+		}
+		return nil
+	case 'E', 'D':
+		var f float64
+		if code == 'E' {
+			f = float64(math.Float32frombits(binary.BigEndian.Uint32(b)))
+		} else {
+			f = math.Float64frombits(binary.BigEndian.Uint64(b))
+		}
+		if math.IsNaN(f) {
+			return nil
+		}
+		return f*c.scale + c.zero
+	}
+	var i int64
+	switch code {
+	case 'B':
+		i = int64(b[0])
+	case 'I':
+		i = int64(int16(binary.BigEndian.Uint16(b)))
+	case 'J':
+		i = int64(int32(binary.BigEndian.Uint32(b)))
+	case 'K':
+		i = int64(binary.BigEndian.Uint64(b))
+	}
+	if c.null != nil && i == *c.null {
+		return nil
+	}
+	return c.scaleInt(i)
+}
+
+// scaleInt scales an integer, which stays one when the scale is
+// one and the zero an integer, as for the unsigned integers
+func (c columnReader) scaleInt(i int64) interface{} {
+	if c.scale != 1 || c.zero != math.Trunc(c.zero) {
+		return float64(i)*c.scale + c.zero
+	}
+	// the unsigned 64-bit integers
+	if c.zero == 1<<63 {
+		return uint64(i) ^ 1<<63
+	}
+	return i + int64(c.zero)
+}
+
+// asciiFormatPattern matches the TFORMn of ASCII tables
+var asciiFormatPattern = regexp.MustCompile(`^([AIFED])(\d+)(?:\.(\d+))?$`)
+
+// decodeASCIITable decodes the rows of an ASCII table
+func decodeASCIITable(header Header, data []byte) (*Table, error) {
+	columns, readers, err := readColumns(header)
+	if err != nil {
+		return nil, err
+	}
+	width, rows, err := header.tableSize(data)
+	if err != nil {
+		return nil, err
+	}
+	for i := range readers {
+		reader := &readers[i]
+		match := asciiFormatPattern.FindStringSubmatch(columns[i].Format)
+		if match == nil {
+			return nil, fmt.Errorf("Column %s: Unsupported FITS column format %s", reader.name, columns[i].Format)
+		}
+		reader.code = match[1][0]
+		fieldWidth, err := strconv.ParseInt(match[2], 10, 64)
+		if err != nil || fieldWidth > width {
+			return nil, fmt.Errorf("Column %s: Invalid FITS column format %s for rows of %d bytes", reader.name, columns[i].Format, width)
+		}
+		reader.width = int(fieldWidth)
+		reader.decimals, _ = strconv.Atoi(match[3])
+		tbcol, err := header.getInt("TBCOL"+strconv.Itoa(i+1), 0)
+		if err != nil {
+			return nil, err
+		}
+		if tbcol < 1 || tbcol-1 > width-fieldWidth {
+			return nil, fmt.Errorf("Column %s: Invalid FITS keyword TBCOL%d: %d", reader.name, i+1, tbcol)
+		}
+		reader.offset = int(tbcol) - 1
+	}
+	table := &Table{Name: header.getString("EXTNAME"), Header: header, Columns: columns}
+	for i := int64(0); i < rows; i++ {
+		row := data[i*width : (i+1)*width]
+		values := make([]interface{}, len(readers))
+		for j, reader := range readers {
+			field := string(row[reader.offset : reader.offset+reader.width])
+			if values[j], err = reader.decodeASCIIField(field); err != nil {
+				return nil, fmt.Errorf("Column %s: %w", reader.name, err)
+			}
+		}
+		table.Rows = append(table.Rows, values)
+	}
+	return table, nil
+}
+
+// decodeASCIIField decodes a field of an ASCII table
+func (c columnReader) decodeASCIIField(field string) (interface{}, error) {
+	if c.code == 'A' {
+		return strings.TrimRight(field, " "), nil
+	}
+	token := strings.TrimSpace(field)
+	if token == "" || c.nullString != "" && token == c.nullString {
+		return nil, nil
+	}
+	if c.code == 'I' {
+		i, err := strconv.ParseInt(token, 10, 64)
+		if err != nil {
+			return nil, fmt.Errorf("Invalid integer %s", token)
+		}
+		return c.scaleInt(i), nil
+	}
+	f, err := strconv.ParseFloat(strings.NewReplacer("D", "E", "d", "e").Replace(token), 64)
+	if err != nil {
+		return nil, fmt.Errorf("Invalid number %s", token)
+	}
+	// the decimal point is implied by the format when it is left out
+	if !strings.ContainsAny(token, ".EeDd") {
+		f /= math.Pow10(c.decimals)
+	}
+	return f*c.scale + c.zero, nil
+}
@@ -0,0 +1,328 @@
+package fits
+
+import (
+	"bytes"
+	"math"
+	"slices"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// fitsFile returns a FITS file with an empty primary HDU
+// followed by an extension with the header and data
+func fitsFile(t *testing.T, header Header, data []byte) *bytes.Buffer {
+	var buffer bytes.Buffer
+	_, err := Header{{Keyword: "SIMPLE", Value: true}, {Keyword: "BITPIX", Value: 8}, {Keyword: "NAXIS", Value: 0}}.WriteTo(&buffer)
+	require.NoError(t, err)
+	_, err = header.WriteTo(&buffer)
+	require.NoError(t, err)
+	buffer.Write(data)
+	buffer.Write(make([]byte, paddedSize(int64(len(data)))-int64(len(data))))
+	return &buffer
+}
+
+func TestReadTable(t *testing.T) {
+	table, err := ReadTable(bytes.NewReader(writeTestTable(t, int64(len(testRows)))))
+	require.NoError(t, err)
+	assert.Equal(t, "results", table.Name)
+	assert.Equal(t, testColumns, table.Columns)
+	assert.Equal(t, [][]interface{}{
+		{"ZTF1", int64(3), 10.5, true, []interface{}{18.0, 19.0}},
+		{"ZTF123", int64(0), -1.5, false, []interface{}{20.0, nil}},
+	}, table.Rows)
+}
+
+func TestReadTableNulls(t *testing.T) {
+	null := int64(-32768)
+	columns := []Column{{Name: "i", Format: "I", Null: &null}, {Name: "d", Format: "D"}, {Name: "l", Format: "L"}}
+	var buffer bytes.Buffer
+	writer, err := NewTableWriter(&buffer, "", columns, 2)
+	require.NoError(t, err)
+	require.NoError(t, writer.WriteRow([]interface{}{int64(1), 1.5, true}))
+	require.NoError(t, writer.WriteRow([]interface{}{nil, nil, nil}))
+	require.NoError(t, writer.Close())
+	table, err := ReadTable(&buffer)
+	require.NoError(t, err)
+	assert.Equal(t, columns, table.Columns)
+	assert.Equal(t, [][]interface{}{{int64(1), 1.5, true}, {nil, nil, nil}}, table.Rows)
+}
+
+func TestReadTableScaling(t *testing.T) {
+	header := Header{
+		{Keyword: "XTENSION", Value: "BINTABLE"},
+		{Keyword: "BITPIX", Value: 8},
+		{Keyword: "NAXIS", Value: 2},
+		{Keyword: "NAXIS1", Value: 5},
+		{Keyword: "NAXIS2", Value: 1},
+		{Keyword: "PCOUNT", Value: 0},
+		{Keyword: "GCOUNT", Value: 1},
+		{Keyword: "TFIELDS", Value: 3},
+		{Keyword: "TTYPE1", Value: "u"},
+		{Keyword: "TFORM1", Value: "I"},
+		{Keyword: "TZERO1", Value: 32768.0},
+		{Keyword: "TTYPE2", Value: "f"},
+		{Keyword: "TFORM2", Value: "B"},
+		{Keyword: "TSCAL2", Value: 0.5},
+		{Keyword: "TZERO2", Value: 1.0},
+		{Keyword: "TTYPE3", Value: "bits"},
+		{Keyword: "TFORM3", Value: "10X"},
+	}
+	table, err := ReadTable(fitsFile(t, header, []byte("\x7f\xff\x03\xa0\x40")))
+	require.NoError(t, err)
+	bits := []interface{}{true, false, true, false, false, false, false, false, false, true}
+	assert.Equal(t, [][]interface{}{{int64(65535), 2.5, bits}}, table.Rows)
+}
+
+func TestReadTableVariableLengthArrays(t *testing.T) {
+	header := Header{
+		{Keyword: "XTENSION", Value: "BINTABLE"},
+		{Keyword: "BITPIX", Value: 8},
+		{Keyword: "NAXIS", Value: 2},
+		{Keyword: "NAXIS1", Value: 24},
+		{Keyword: "NAXIS2", Value: 2},
+		{Keyword: "PCOUNT", Value: 14},
+		{Keyword: "GCOUNT", Value: 1},
+		{Keyword: "TFIELDS", Value: 2},
+		{Keyword: "TTYPE1", Value: "mags"},
+		{Keyword: "TFORM1", Value: "1PJ(2)"},
+		{Keyword: "TNULL1", Value: int64(-1)},
+		{Keyword: "TTYPE2", Value: "name"},
+		{Keyword: "TFORM2", Value: "1QA(6)"},
+	}
+	rows := []byte("\x00\x00\x00\x02\x00\x00\x00\x00" + "\x00\x00\x00\x00\x00\x00\x00\x06\x00\x00\x00\x00\x00\x00\x00\x08" +
+		"\x00\x00\x00\x00\x00\x00\x00\x00" + "\x00\x00\x00\x00\x00\x00\x00\x00\x00\x00\x00\x00\x00\x00\x00\x00")
+	heap := []byte("\x00\x00\x00\x07\xff\xff\xff\xffZTF123")
+	table, err := ReadTable(fitsFile(t, header, append(rows, heap...)))
+	require.NoError(t, err)
+	assert.Equal(t, [][]interface{}{{[]interface{}{int64(7), nil}, "ZTF123"}, {[]interface{}{}, ""}}, table.Rows)
+	// the descriptors can not point out of the heap
+	rows[3] = 4
+	_, err = ReadTable(fitsFile(t, header, append(rows, heap...)))
+	assert.ErrorContains(t, err, "Column mags: Array of 4 elements at 0 is out of the heap")
+}
+
+func TestReadTableASCII(t *testing.T) {
+	header := Header{
+		{Keyword: "XTENSION", Value: "TABLE"},
+		{Keyword: "BITPIX", Value: 8},
+		{Keyword: "NAXIS", Value: 2},
+		{Keyword: "NAXIS1", Value: 24},
+		{Keyword: "NAXIS2", Value: 3},
+		{Keyword: "PCOUNT", Value: 0},
+		{Keyword: "GCOUNT", Value: 1},
+		{Keyword: "TFIELDS", Value: 3},
+		{Keyword: "TTYPE1", Value: "oid"},
+		{Keyword: "TBCOL1", Value: 1},
+		{Keyword: "TFORM1", Value: "A6"},
+		{Keyword: "TTYPE2", Value: "ndet"},
+		{Keyword: "TBCOL2", Value: 8},
+		{Keyword: "TFORM2", Value: "I4"},
+		{Keyword: "TNULL2", Value: "-99"},
+		{Keyword: "TTYPE3", Value: "ra"},
+		{Keyword: "TBCOL3", Value: 13},
+		{Keyword: "TFORM3", Value: "D12.4"},
+		{Keyword: "TUNIT3", Value: "deg"},
+		{Keyword: "EXTNAME", Value: "objects"},
+	}
+	data := []byte("ZTF1      3 1.05000D+01 " + "ZTF2    -99      105000" + " " + "          2             ")
+	table, err := ReadTable(fitsFile(t, header, data))
+	require.NoError(t, err)
+	assert.Equal(t, "objects", table.Name)
+	assert.Equal(t, "deg", table.Columns[2].Unit)
+	assert.Equal(t, [][]interface{}{{"ZTF1", int64(3), 10.5}, {"ZTF2", nil, 10.5}, {"", int64(2), nil}}, table.Rows)
+}
+
+func TestReadTableErrors(t *testing.T) {
+	_, err := ReadTable(fitsFile(t, Header{{Keyword: "XTENSION", Value: "IMAGE"}, {Keyword: "BITPIX", Value: 8}, {Keyword: "NAXIS", Value: 0}}, nil))
+	assert.ErrorContains(t, err, "No table in the FITS file")
+	_, err = ReadTable(bytes.NewReader([]byte("SIMPLE")))
+	assert.ErrorContains(t, err, "Reading FITS header")
+	header := Header{
+		{Keyword: "XTENSION", Value: "BINTABLE"},
+		{Keyword: "BITPIX", Value: 8},
+		{Keyword: "NAXIS", Value: 2},
+		{Keyword: "NAXIS1", Value: 8},
+		{Keyword: "NAXIS2", Value: 0},
+		{Keyword: "TFIELDS", Value: 1},
+		{Keyword: "TTYPE1", Value: "z"},
+		{Keyword: "TFORM1", Value: "C"},
+	}
+	_, err = ReadTable(fitsFile(t, header, nil))
+	assert.ErrorContains(t, err, "Column z: Unsupported FITS column format C")
+}
+
+// malformedHeader returns the header of a binary table of a row
+// of a variable-length array, changing the values of the keywords
+func malformedHeader(values map[string]interface{}) Header {
+	header := Header{
+		{Keyword: "XTENSION", Value: "BINTABLE"},
+		{Keyword: "BITPIX", Value: 8},
+		{Keyword: "NAXIS", Value: 2},
+		{Keyword: "NAXIS1", Value: 16},
+		{Keyword: "NAXIS2", Value: 1},
+		{Keyword: "PCOUNT", Value: 4},
+		{Keyword: "GCOUNT", Value: 1},
+		{Keyword: "TFIELDS", Value: 1},
+		{Keyword: "TTYPE1", Value: "a"},
+		{Keyword: "TFORM1", Value: "1QB(4)"},
+	}
+	for i, card := range header {
+		if value, ok := values[card.Keyword]; ok {
+			header[i].Value = value
+			delete(values, card.Keyword)
+		}
+	}
+	for keyword, value := range values {
+		header = append(header, Card{Keyword: keyword, Value: value})
+	}
+	return header
+}
+
+func TestReadTableMalformed(t *testing.T) {
+	descriptor := []byte("\x00\x00\x00\x00\x00\x00\x00\x04\x00\x00\x00\x00\x00\x00\x00\x00")
+	heap := []byte("\x01\x02\x03\x04")
+	table, err := ReadTable(fitsFile(t, malformedHeader(nil), append(descriptor, heap...)))
+	require.NoError(t, err)
+	assert.Equal(t, [][]interface{}{{[]interface{}{int64(1), int64(2), int64(3), int64(4)}}}, table.Rows)
+	testCases := []struct {
+		name   string
+		values map[string]interface{}
+		data   []byte
+		err    string
+	}{
+		{"negative NAXIS", map[string]interface{}{"NAXIS": -2}, nil, "NAXIS: -2 is out of range"},
+		{"negative NAXIS1", map[string]interface{}{"NAXIS1": -16, "NAXIS2": -1}, nil, "NAXIS1: -16 is out of range"},
+		{"negative NAXIS2", map[string]interface{}{"NAXIS2": -1, "PCOUNT": 36}, nil, "NAXIS2: -1 is out of range"},
+		{"negative TFIELDS", map[string]interface{}{"TFIELDS": -1}, nil, "TFIELDS: -1 is out of range"},
+		{"negative PCOUNT", map[string]interface{}{"PCOUNT": -16}, nil, "PCOUNT: -16 is out of range"},
+		{"invalid BITPIX", map[string]interface{}{"BITPIX": 7}, nil, "BITPIX: 7"},
+		{"no groups", map[string]interface{}{"GCOUNT": 0}, nil, "1 rows of 16 bytes do not fit in 0 bytes of data"},
+		{"no NAXIS2", map[string]interface{}{"NAXIS": 1, "NAXIS2": 2}, nil, "2 rows of 16 bytes do not fit in 20 bytes of data"},
+		{"rows of 0 bytes", map[string]interface{}{"NAXIS1": 0, "NAXIS2": int64(1) << 62, "TFIELDS": 0}, nil, "4611686018427387904 rows of 0 bytes"},
+		{"size overflow", map[string]interface{}{"NAXIS1": int64(1) << 40, "NAXIS2": int64(1) << 40}, nil, "the size of the data overflows"},
+		{"negative THEAP", map[string]interface{}{"THEAP": -8}, nil, "THEAP: -8 is out of the heap"},
+		{"THEAP in the rows", map[string]interface{}{"THEAP": 8}, nil, "THEAP: 8 is out of the heap"},
+		{"THEAP after the data", map[string]interface{}{"THEAP": 100}, nil, "THEAP: 100 is out of the heap"},
+		{"huge repeat", map[string]interface{}{"TFORM1": "9223372036854775807J"}, nil, "NAXIS1: 16 is narrower than the columns"},
+		{"repeat overflow", map[string]interface{}{"TFORM1": "99999999999999999999J"}, nil, "Invalid FITS column format"},
+		{"huge bits", map[string]interface{}{"TFORM1": "9223372036854775807X"}, nil, "NAXIS1: 16 is narrower than the columns"},
+		{"descriptor without element", map[string]interface{}{"TFORM1": "1Q"}, nil, "Unsupported FITS column format 1Q"},
+		{"descriptor without repeat nor element", map[string]interface{}{"TFORM1": "P"}, nil, "Unsupported FITS column format P"},
+		{"no descriptor without element", map[string]interface{}{"TFORM1": "0Q"}, nil, "Unsupported FITS column format 0Q"},
+		{
+			"huge count",
+			nil,
+			[]byte("\x7f\xff\xff\xff\xff\xff\xff\xff\x00\x00\x00\x00\x00\x00\x00\x01"),
+			"Array of 9223372036854775807 elements at 1 is out of the heap",
+		},
+		{
+			"count and offset overflow",
+			nil,
+			[]byte("\x00\x00\x00\x00\x00\x00\x00\x02\xff\xff\xff\xff\xff\xff\xff\xff"),
+			"Array of 2 elements at 18446744073709551615 is out of the heap",
+		},
+		{
+			"array after the heap",
+			nil,
+			[]byte("\x00\x00\x00\x00\x00\x00\x00\x02\x00\x00\x00\x00\x00\x00\x00\x03"),
+			"Array of 2 elements at 3 is out of the heap",
+		},
+	}
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			data := tc.data
+			if data == nil {
+				data = descriptor
+			}
+			_, err := ReadTable(fitsFile(t, malformedHeader(tc.values), append(slices.Clone(data), heap...)))
+			assert.ErrorContains(t, err, tc.err)
+		})
+	}
+}
+
+func TestReadTableMalformedASCII(t *testing.T) {
+	testCases := []struct {
+		name  string
+		tform string
+		tbcol int64
+		err   string
+	}{
+		{"huge width", "A9223372036854775807", 1, "Invalid FITS column format A9223372036854775807"},
+		{"width overflow", "I99999999999999999999", 1, "Invalid FITS column format"},
+		{"negative TBCOL", "A2", -9223372036854775807, "Invalid FITS keyword TBCOL1"},
+		{"TBCOL after the row", "A2", 9223372036854775807, "Invalid FITS keyword TBCOL1"},
+	}
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			header := Header{
+				{Keyword: "XTENSION", Value: "TABLE"},
+				{Keyword: "BITPIX", Value: 8},
+				{Keyword: "NAXIS", Value: 2},
+				{Keyword: "NAXIS1", Value: 4},
+				{Keyword: "NAXIS2", Value: 1},
+				{Keyword: "TFIELDS", Value: 1},
+				{Keyword: "TFORM1", Value: tc.tform},
+				{Keyword: "TBCOL1", Value: tc.tbcol},
+			}
+			_, err := ReadTable(fitsFile(t, header, []byte("ab  ")))
+			assert.ErrorContains(t, err, tc.err)
+		})
+	}
+}
+
+// FuzzReadTable checks that malformed tables return errors
+// rather than panicking or allocating more than they hold
+func FuzzReadTable(f *testing.F) {
+	var buffer bytes.Buffer
+	_, err := malformedHeader(nil).WriteTo(&buffer)
+	require.NoError(f, err)
+	f.Add(buffer.Bytes(), []byte("\x00\x00\x00\x00\x00\x00\x00\x04\x00\x00\x00\x00\x00\x00\x00\x00\x01\x02\x03\x04"))
+	f.Add([]byte(nil), []byte(nil))
+	for _, tform := range []string{"1P", "P", "0Q"} {
+		buffer.Reset()
+		_, err := malformedHeader(map[string]interface{}{"TFORM1": tform}).WriteTo(&buffer)
+		require.NoError(f, err)
+		f.Add(buffer.Bytes(), []byte(nil))
+	}
+	f.Fuzz(func(t *testing.T, header []byte, data []byte) {
+		// the keywords of the fuzzed header replace those of the table
+		values := map[string]interface{}{}
+		for i := 0; i+CardSize <= len(header); i += CardSize {
+			card, err := parseCard(string(header[i : i+CardSize]))
+			if err == nil && card.Keyword != "" && card.Keyword != "END" && card.Value != nil {
+				values[card.Keyword] = card.Value
+			}
+		}
+		ReadTable(fitsFile(t, malformedHeader(values), data))
+	})
+}
+
+func TestParseCard(t *testing.T) {
+	testCases := []struct {
+		card     string
+		expected Card
+	}{
+		{"SIMPLE  =                    T / conforms", Card{Keyword: "SIMPLE", Value: true, Comment: "conforms"}},
+		{"NAXIS2  =        1099511627776", Card{Keyword: "NAXIS2", Value: int64(1 << 40)}},
+		{"TZERO1  =               32768.", Card{Keyword: "TZERO1", Value: 32768.0}},
+		{"TSCAL1  =               1.5D-1", Card{Keyword: "TSCAL1", Value: 0.15}},
+		{"TCOMM1  = 'Object''s / ra  ' / a comment", Card{Keyword: "TCOMM1", Value: "Object's / ra", Comment: "a comment"}},
+		{"UNDEF   =                      / undefined", Card{Keyword: "UNDEF", Comment: "undefined"}},
+		{"COMMENT   a comment", Card{Keyword: "COMMENT", Comment: "a comment"}},
+	}
+	for _, tc := range testCases {
+		card, err := parseCard(padCard(tc.card))
+		require.NoError(t, err)
+		assert.Equal(t, tc.expected, card)
+	}
+	_, err := parseCard(padCard("TTYPE1  = 'ra"))
+	assert.ErrorContains(t, err, "Invalid FITS keyword TTYPE1: unterminated string")
+	// the cards written are read back
+	for _, card := range []Card{{Keyword: "TDMIN1", Value: math.Pi}, {Keyword: "TTYPE1", Value: "  ra"}} {
+		parsed, err := parseCard(card.String())
+		require.NoError(t, err)
+		assert.Equal(t, card, parsed)
+	}
+}
@@ -0,0 +1,385 @@
+package fits
+
+import (
+	"bufio"
+	"encoding/binary"
+	"fmt"
+	"io"
+	"math"
+	"os"
+	"reflect"
+	"strconv"
+	"strings"
+)
+
+// Column describes a column of a binary table
+type Column struct {
+	// Name is the TTYPEn of the column
+	Name string
+	// Format is the TFORMn of the column, a repeat count followed by
+	// the type code, such as "K" for 64-bit integers or "20A" for
+	// strings of 20 characters. The codes L, B, I, J, K, E, D and A
+	// are supported.
+	Format string
+	// Null is the TNULLn of integer columns, the value
+	// their NULLs are written with, left out when nil
+	Null *int64
+	// Unit, UCD, Utype and Description are the TUNITn, TUCDn,
+	// TUTYPn and TCOMMn of the column, left out when empty
+	Unit        string
+	UCD         string
+	Utype       string
+	Description string
+}
+
+// codeSizes are the sizes in bytes of the elements of each type code
+var codeSizes = map[byte]int{
+	'L': 1,
+	'B': 1,
+	'I': 2,
+	'J': 4,
+	'K': 8,
+	'E': 4,
+	'D': 8,
+	'A': 1,
+}
+
+// format is a parsed TFORMn
+type format struct {
+	repeat int
+	code   byte
+}
+
+func (f format) size() int {
+	return f.repeat * codeSizes[f.code]
+}
+
+// parseFormat parses a TFORMn, whose repeat count defaults to 1
+func parseFormat(tform string) (format, error) {
+	if tform == "" {
+		return format{}, fmt.Errorf("Empty FITS column format")
+	}
+	code := tform[len(tform)-1]
+	if _, ok := codeSizes[code]; !ok {
+		return format{}, fmt.Errorf("Unsupported FITS column format %s", tform)
+	}
+	repeat := 1
+	if count := tform[:len(tform)-1]; count != "" {
+		var err error
+		repeat, err = strconv.Atoi(count)
+		if err != nil || repeat < 0 {
+			return format{}, fmt.Errorf("Invalid FITS column format %s", tform)
+		}
+	}
+	return format{repeat: repeat, code: code}, nil
+}
+
+// TableWriter writes a FITS file with an empty primary HDU followed by
+// a binary table, streaming the rows to the underlying writer.
+// When the number of rows is known up front the headers are written
+// by NewTableWriter and each row is written as it comes. Otherwise the
+// rows are spooled to a temporary file until Close, which writes the
+// headers with the number of rows and then copies the rows.
+type TableWriter struct {
+	w       *bufio.Writer
+	name    string
+	columns []Column
+	// keywords are the other cards of the header of the table
+	keywords Header
+	formats  []format
+	width    int
+	// rows is the number of rows announced, or -1 when it is unknown
+	rows    int64
+	written int64
+	// spool holds the rows when their number is unknown
+	spool *os.File
+	out   *bufio.Writer
+	row   []byte
+}
+
+// NewTableWriter creates a writer of a binary table named name, with
+// the columns and the number of rows, or -1 if it is not known yet.
+// The keywords are added to the header of the table after those
+// describing the columns.
+func NewTableWriter(w io.Writer, name string, columns []Column, rows int64, keywords ...Card) (*TableWriter, error) {
+	t := &TableWriter{w: bufio.NewWriter(w), name: name, columns: columns, keywords: keywords, rows: rows}
+	for _, column := range columns {
+		f, err := parseFormat(column.Format)
+		if err != nil {
+			return nil, fmt.Errorf("Column %s: %w", column.Name, err)
+		}
+		t.formats = append(t.formats, f)
+		t.width += f.size()
+	}
+	t.row = make([]byte, t.width)
+	if rows < 0 {
+		spool, err := os.CreateTemp("", "*.fits.rows")
+		if err != nil {
+			return nil, err
+		}
+		t.spool = spool
+		t.out = bufio.NewWriter(spool)
+		return t, nil
+	}
+	t.out = t.w
+	if err := t.writeHeaders(rows); err != nil {
+		return nil, err
+	}
+	return t, nil
+}
+
+// Header returns the header of the table with the number of rows
+func (t *TableWriter) Header(rows int64) Header {
+	header := Header{
+		{Keyword: "XTENSION", Value: "BINTABLE", Comment: "binary table extension"},
+		{Keyword: "BITPIX", Value: 8, Comment: "8-bit bytes"},
+		{Keyword: "NAXIS", Value: 2, Comment: "2-dimensional binary table"},
+		{Keyword: "NAXIS1", Value: t.width, Comment: "width of a row in bytes"},
+		{Keyword: "NAXIS2", Value: rows, Comment: "number of rows"},
+		{Keyword: "PCOUNT", Value: 0, Comment: "size of the heap"},
+		{Keyword: "GCOUNT", Value: 1, Comment: "one data group"},
+		{Keyword: "TFIELDS", Value: len(t.columns), Comment: "number of columns"},
+	}
+	for i, column := range t.columns {
+		n := strconv.Itoa(i + 1)
+		header = append(header,
+			Card{Keyword: "TTYPE" + n, Value: column.Name},
+			Card{Keyword: "TFORM" + n, Value: column.Format},
+		)
+		if column.Null != nil {
+			header = append(header, Card{Keyword: "TNULL" + n, Value: *column.Null})
+		}
+		optional := []struct{ keyword, value string }{
+			{"TUNIT", column.Unit},
+			{"TUCD", column.UCD},
+			{"TUTYP", column.Utype},
+			{"TCOMM", column.Description},
+		}
+		for _, card := range optional {
+			if card.value != "" {
+				header = append(header, Card{Keyword: card.keyword + n, Value: card.value})
+			}
+		}
+	}
+	if t.name != "" {
+		header = append(header, Card{Keyword: "EXTNAME", Value: t.name, Comment: "name of the table"})
+	}
+	return append(header, t.keywords...)
+}
+
+// writeHeaders writes the primary header and the header of the table
+func (t *TableWriter) writeHeaders(rows int64) error {
+	primary := Header{
+		{Keyword: "SIMPLE", Value: true, Comment: "conforms to the FITS standard"},
+		{Keyword: "BITPIX", Value: 8},
+		{Keyword: "NAXIS", Value: 0, Comment: "no data in the primary HDU"},
+		{Keyword: "EXTEND", Value: true, Comment: "extensions follow"},
+	}
+	if _, err := primary.WriteTo(t.w); err != nil {
+		return err
+	}
+	_, err := t.Header(rows).WriteTo(t.w)
+	return err
+}
+
+// WriteRow writes a row with a value for each column. Integers and
+// floats are converted to the type of their column, strings are
+// truncated or padded with blanks to its width, and the other columns
+// take slices, padded with NULLs to their repeat count, or single values
+// when their repeat count is one.
+// NULLs, which are nil values or elements, are written following the
+// FITS conventions: as NaN in float columns, as the TNULLn of integer
+// columns, or zero when they have none, as a zero byte in logical
+// columns, and as blanks in string columns, so they can not be told
+// apart from empty strings.
+func (t *TableWriter) WriteRow(values []interface{}) error {
+	if len(values) != len(t.columns) {
+		return fmt.Errorf("Row has %d values for %d columns", len(values), len(t.columns))
+	}
+	if t.rows >= 0 && t.written >= t.rows {
+		return fmt.Errorf("More rows than the %d announced", t.rows)
+	}
+	offset := 0
+	for i, value := range values {
+		f := t.formats[i]
+		dst := t.row[offset : offset+f.size()]
+		offset += f.size()
+		if err := encodeValue(f, t.columns[i].Null, value, dst); err != nil {
+			return fmt.Errorf("Column %s: %w", t.columns[i].Name, err)
+		}
+	}
+	t.written++
+	_, err := t.out.Write(t.row)
+	return err
+}
+
+// Close completes the table, padding the rows to a whole block,
+// and flushes the underlying writer. It does not close it.
+func (t *TableWriter) Close() error {
+	if t.spool != nil {
+		defer os.Remove(t.spool.Name())
+		defer t.spool.Close()
+		if err := t.out.Flush(); err != nil {
+			return err
+		}
+		if err := t.writeHeaders(t.written); err != nil {
+			return err
+		}
+		if _, err := t.spool.Seek(0, io.SeekStart); err != nil {
+			return err
+		}
+		if _, err := io.Copy(t.w, t.spool); err != nil {
+			return err
+		}
+	} else if t.written != t.rows {
+		return fmt.Errorf("Wrote %d rows of the %d announced", t.written, t.rows)
+	}
+	size := t.written * int64(t.width)
+	if _, err := t.w.Write(make([]byte, paddedSize(size)-size)); err != nil {
+		return err
+	}
+	return t.w.Flush()
+}
+
+// encodeValue writes the value in the format to dst, in big-endian order,
+// writing its NULLs with null in integer columns
+func encodeValue(f format, null *int64, value interface{}, dst []byte) error {
+	clear(dst)
+	if f.code == 'A' {
+		s, ok := value.(string)
+		if !ok && value != nil {
+			if b, isBytes := value.([]byte); isBytes {
+				s, ok = string(b), true
+			} else {
+				return fmt.Errorf("Unsupported value %T for format A", value)
+			}
+		}
+		s = ASCIIString(s)
+		copy(dst, s)
+		for i := len(s); i < len(dst); i++ {
+			dst[i] = ' '
+		}
+		return nil
+	}
+	size := codeSizes[f.code]
+	if value == nil {
+		for i := 0; i < f.repeat; i++ {
+			encodeNull(f.code, null, dst[i*size:(i+1)*size])
+		}
+		return nil
+	}
+	v := reflect.ValueOf(value)
+	if v.Kind() != reflect.Slice && v.Kind() != reflect.Array {
+		if f.repeat == 1 {
+			return encodeScalar(f.code, value, dst)
+		}
+		return fmt.Errorf("Unsupported value %T for format %d%c", value, f.repeat, f.code)
+	}
+	for i := 0; i < f.repeat; i++ {
+		dst := dst[i*size : (i+1)*size]
+		var element interface{}
+		if i < v.Len() {
+			element = v.Index(i).Interface()
+		}
+		// missing elements are NULLs too
+		if element == nil {
+			encodeNull(f.code, null, dst)
+			continue
+		}
+		if err := encodeScalar(f.code, element, dst); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// encodeNull writes a NULL element of the type code to dst,
+// which is zeroed
+func encodeNull(code byte, null *int64, dst []byte) {
+	switch code {
+	// the quiet NaNs without payload
+	case 'E':
+		binary.BigEndian.PutUint32(dst, 0x7fc00000)
+	case 'D':
+		binary.BigEndian.PutUint64(dst, 0x7ff8000000000000)
+	case 'B', 'I', 'J', 'K':
+		if null != nil {
+			encodeScalar(code, *null, dst)
+		}
+	}
+}
+
+// encodeScalar writes a single element of the type code to dst
+func encodeScalar(code byte, value interface{}, dst []byte) error {
+	switch code {
+	case 'L':
+		b, ok := value.(bool)
+		if !ok {
+			return fmt.Errorf("Unsupported value %T for format L", value)
+		}
+		dst[0] = 'F'
+		if b {
+			dst[0] = 'T'
+		}
+		return nil
+	case 'E', 'D':
+		f, ok := toFloat64(value)
+		if !ok {
+			return fmt.Errorf("Unsupported value %T for format %c", value, code)
+		}
+		if code == 'E' {
+			binary.BigEndian.PutUint32(dst, math.Float32bits(float32(f)))
+		} else {
+			binary.BigEndian.PutUint64(dst, math.Float64bits(f))
+		}
+		return nil
+	}
+	i, ok := toInt64(value)
+	if !ok {
+		return fmt.Errorf("Unsupported value %T for format %c", value, code)
+	}
+	switch code {
+	case 'B':
+		dst[0] = byte(i)
+	case 'I':
+		binary.BigEndian.PutUint16(dst, uint16(i))
+	case 'J':
+		binary.BigEndian.PutUint32(dst, uint32(i))
+	case 'K':
+		binary.BigEndian.PutUint64(dst, uint64(i))
+	}
+	return nil
+}
+
+func toInt64(value interface{}) (int64, bool) {
+	v := reflect.ValueOf(value)
+	switch v.Kind() {
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64:
+		return v.Int(), true
+	case reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64:
+		return int64(v.Uint()), true
+	case reflect.Bool:
+		if v.Bool() {
+			return 1, true
+		}
+		return 0, true
+	default:
+		return 0, false
+	}
+}
+
+func toFloat64(value interface{}) (float64, bool) {
+	v := reflect.ValueOf(value)
+	switch v.Kind() {
+	case reflect.Float32, reflect.Float64:
+		return v.Float(), true
+	case reflect.String:
+		// numeric values are given as strings
+		f, err := strconv.ParseFloat(strings.TrimSpace(v.String()), 64)
+		return f, err == nil
+	}
+	if i, ok := toInt64(value); ok {
+		return float64(i), true
+	}
+	return 0, false
+}
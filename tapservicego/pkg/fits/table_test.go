@@ -0,0 +1,146 @@
+package fits
+
+import (
+	"bytes"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+var testColumns = []Column{
+	{Name: "oid", Format: "6A"},
+	{Name: "ndet", Format: "J"},
+	{Name: "ra", Format: "D", Unit: "deg", UCD: "pos.eq.ra"},
+	{Name: "stellar", Format: "L"},
+	{Name: "mags", Format: "2E"},
+}
+
+var testRows = [][]interface{}{
+	{"ZTF1", int64(3), 10.5, true, []float64{18, 19}},
+	{"ZTF123456", nil, "-1.5", false, []interface{}{20.0}},
+}
+
+// expectedRows are the bytes of testRows
+var expectedRows = []byte("ZTF1  \x00\x00\x00\x03\x40\x25\x00\x00\x00\x00\x00\x00T\x41\x90\x00\x00\x41\x98\x00\x00" +
+	"ZTF123\x00\x00\x00\x00\xbf\xf8\x00\x00\x00\x00\x00\x00F\x41\xa0\x00\x00\x7f\xc0\x00\x00")
+
+func writeTestTable(t *testing.T, rows int64) []byte {
+	var buffer bytes.Buffer
+	table, err := NewTableWriter(&buffer, "results", testColumns, rows)
+	require.NoError(t, err)
+	for _, row := range testRows {
+		require.NoError(t, table.WriteRow(row))
+	}
+	require.NoError(t, table.Close())
+	return buffer.Bytes()
+}
+
+func TestTableWriter(t *testing.T) {
+	content := writeTestTable(t, int64(len(testRows)))
+	require.Len(t, content, 3*BlockSize)
+	primary := string(content[:BlockSize])
+	assert.True(t, strings.HasPrefix(primary, "SIMPLE  =                    T"))
+	assert.Contains(t, primary, "EXTEND  =                    T")
+	header := string(content[BlockSize : 2*BlockSize])
+	assert.True(t, strings.HasPrefix(header, "XTENSION= 'BINTABLE'"))
+	for _, card := range []string{
+		"NAXIS1  =                   27",
+		"NAXIS2  =                    2",
+		"TFIELDS =                    5",
+		"TTYPE1  = 'oid     '",
+		"TFORM1  = '6A      '",
+		"TUNIT3  = 'deg     '",
+		"TUCD3   = 'pos.eq.ra'",
+		"TFORM5  = '2E      '",
+		"EXTNAME = 'results '",
+	} {
+		assert.Contains(t, header, card)
+	}
+	assert.NotContains(t, header, "TUNIT1")
+	data := content[2*BlockSize:]
+	assert.Equal(t, expectedRows, data[:len(expectedRows)])
+	// the rows are padded with zeros
+	assert.Equal(t, make([]byte, BlockSize-len(expectedRows)), data[len(expectedRows):])
+}
+
+func TestTableWriterSpool(t *testing.T) {
+	// without the number of rows the table is spooled, giving the same file
+	assert.Equal(t, writeTestTable(t, int64(len(testRows))), writeTestTable(t, -1))
+}
+
+func TestTableWriterErrors(t *testing.T) {
+	_, err := NewTableWriter(&bytes.Buffer{}, "", []Column{{Name: "a", Format: "P"}}, 0)
+	assert.ErrorContains(t, err, "Column a: Unsupported FITS column format P")
+	table, err := NewTableWriter(&bytes.Buffer{}, "", testColumns, 1)
+	require.NoError(t, err)
+	assert.ErrorContains(t, table.WriteRow([]interface{}{"a"}), "Row has 1 values for 5 columns")
+	assert.ErrorContains(t, table.WriteRow([]interface{}{"a", "b", 1.0, true, nil}), "Column ndet: Unsupported value string for format J")
+	assert.ErrorContains(t, table.Close(), "Wrote 0 rows of the 1 announced")
+	require.NoError(t, table.WriteRow(testRows[0]))
+	assert.ErrorContains(t, table.WriteRow(testRows[0]), "More rows than the 1 announced")
+}
+
+func TestTableWriterNulls(t *testing.T) {
+	null := int64(-32768)
+	columns := []Column{
+		{Name: "i", Format: "I", Null: &null},
+		{Name: "k", Format: "K"},
+		{Name: "d", Format: "D"},
+		{Name: "l", Format: "L"},
+		{Name: "a", Format: "2A"},
+		{Name: "v", Format: "2I", Null: &null},
+	}
+	var buffer bytes.Buffer
+	table, err := NewTableWriter(&buffer, "", columns, 1)
+	require.NoError(t, err)
+	require.NoError(t, table.WriteRow([]interface{}{nil, nil, nil, nil, nil, []interface{}{int64(1), nil}}))
+	require.NoError(t, table.Close())
+	content := buffer.Bytes()
+	assert.Contains(t, string(content[BlockSize:2*BlockSize]), "TNULL1  =               -32768")
+	assert.Equal(t, []byte("\x80\x00"+
+		"\x00\x00\x00\x00\x00\x00\x00\x00"+
+		"\x7f\xf8\x00\x00\x00\x00\x00\x00"+
+		"\x00"+
+		"  "+
+		"\x00\x01\x80\x00"), content[2*BlockSize:2*BlockSize+25])
+}
+
+func TestEncodeValueASCII(t *testing.T) {
+	dst := make([]byte, 4)
+	// the characters are not cut in the middle of their bytes
+	require.NoError(t, encodeValue(format{code: 'A', repeat: 4}, nil, "añejo", dst))
+	assert.Equal(t, "a?ej", string(dst))
+	require.NoError(t, encodeValue(format{code: 'A', repeat: 4}, nil, []byte("\x00é"), dst))
+	assert.Equal(t, "??  ", string(dst))
+}
+
+func TestParseFormat(t *testing.T) {
+	f, err := parseFormat("20A")
+	require.NoError(t, err)
+	assert.Equal(t, format{repeat: 20, code: 'A'}, f)
+	assert.Equal(t, 20, f.size())
+	f, err = parseFormat("K")
+	require.NoError(t, err)
+	assert.Equal(t, 8, f.size())
+	_, err = parseFormat("xD")
+	assert.Error(t, err)
+	_, err = parseFormat("")
+	assert.Error(t, err)
+}
+
+func TestTableWriterKeywords(t *testing.T) {
+	var buffer bytes.Buffer
+	columns := []Column{{Name: "mjd", Format: "D", Unit: "d"}, {Name: "fid", Format: "J"}}
+	table, err := NewTableWriter(&buffer, "results", columns, 1, Card{Keyword: "TIMESYS", Value: "UTC"})
+	require.NoError(t, err)
+	// single values can be given as slices too
+	require.NoError(t, table.WriteRow([]interface{}{[]float64{60000.5}, int64(1)}))
+	require.NoError(t, table.Close())
+	header := buffer.String()[BlockSize : 2*BlockSize]
+	assert.Contains(t, header, "EXTNAME = 'results '")
+	assert.Contains(t, header, "TIMESYS = 'UTC     '")
+	assert.Less(t, strings.Index(header, "EXTNAME"), strings.Index(header, "TIMESYS"))
+	assert.Equal(t, []byte("\x40\xed\x4c\x10\x00\x00\x00\x00\x00\x00\x00\x01"), buffer.Bytes()[2*BlockSize:2*BlockSize+12])
+}
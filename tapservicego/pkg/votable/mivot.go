@@ -0,0 +1,96 @@
+package votable
+
+import (
+	"encoding/xml"
+)
+
+// MIVOTNamespace is the namespace of the VODML element of MIVOT
+const MIVOTNamespace = "http://www.ivoa.net/xml/mivot"
+
+// VODML represents the VODML element of MIVOT, which maps
+// the fields of a table to the instances of data models
+type VODML struct {
+	XMLName   xml.Name    `xml:"VODML"`
+	Xmlns     string      `xml:"xmlns,attr"`
+	Report    *Report     `xml:"REPORT"`
+	Models    []Model     `xml:"MODEL"`
+	Globals   *Globals    `xml:"GLOBALS"`
+	Templates []Templates `xml:"TEMPLATES"`
+}
+
+// Report represents a REPORT element in MIVOT
+type Report struct {
+	Status  string `xml:"status,attr"`
+	Message string `xml:",chardata"`
+}
+
+// Model represents a MODEL element in MIVOT
+type Model struct {
+	Name string `xml:"name,attr"`
+	URL  string `xml:"url,attr,omitempty"`
+}
+
+// Globals represents a GLOBALS element in MIVOT, holding
+// the instances shared by every row, such as coordinate systems
+type Globals struct {
+	Instances   []Instance   `xml:"INSTANCE"`
+	Collections []Collection `xml:"COLLECTION"`
+}
+
+// Templates represents a TEMPLATES element in MIVOT, holding
+// the instances built from each row of the table
+type Templates struct {
+	TableRef  string     `xml:"tableref,attr,omitempty"`
+	Instances []Instance `xml:"INSTANCE"`
+}
+
+// Instance represents an INSTANCE element in MIVOT
+type Instance struct {
+	DmID        string       `xml:"dmid,attr,omitempty"`
+	DmRole      string       `xml:"dmrole,attr,omitempty"`
+	DmType      string       `xml:"dmtype,attr"`
+	PrimaryKeys []PrimaryKey `xml:"PRIMARY_KEY"`
+	Attributes  []Attribute  `xml:"ATTRIBUTE"`
+	Instances   []Instance   `xml:"INSTANCE"`
+	References  []Reference  `xml:"REFERENCE"`
+}
+
+// Attribute represents an ATTRIBUTE element in MIVOT, whose value
+// is constant, or taken from the field with the ID of ref
+type Attribute struct {
+	DmRole     string `xml:"dmrole,attr"`
+	DmType     string `xml:"dmtype,attr"`
+	Ref        string `xml:"ref,attr,omitempty"`
+	Value      string `xml:"value,attr,omitempty"`
+	Unit       string `xml:"unit,attr,omitempty"`
+	ArrayIndex string `xml:"arrayindex,attr,omitempty"`
+}
+
+// Reference represents a REFERENCE element in MIVOT. Static
+// references use dmref, and dynamic ones use sourceref with
+// the foreign keys that select the instance of the collection.
+type Reference struct {
+	DmRole      string       `xml:"dmrole,attr"`
+	DmRef       string       `xml:"dmref,attr,omitempty"`
+	SourceRef   string       `xml:"sourceref,attr,omitempty"`
+	ForeignKeys []ForeignKey `xml:"FOREIGN_KEY"`
+}
+
+// Collection represents a COLLECTION element in MIVOT
+type Collection struct {
+	DmID      string     `xml:"dmid,attr,omitempty"`
+	DmRole    string     `xml:"dmrole,attr,omitempty"`
+	Instances []Instance `xml:"INSTANCE"`
+}
+
+// PrimaryKey represents a PRIMARY_KEY element in MIVOT
+type PrimaryKey struct {
+	DmType string `xml:"dmtype,attr"`
+	Value  string `xml:"value,attr,omitempty"`
+	Ref    string `xml:"ref,attr,omitempty"`
+}
+
+// ForeignKey represents a FOREIGN_KEY element in MIVOT
+type ForeignKey struct {
+	Ref string `xml:"ref,attr"`
+}
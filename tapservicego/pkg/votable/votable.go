@@ -12,13 +12,18 @@ type VOTable struct {
 	Resource Resource `xml:"RESOURCE"`
 }
 
-// Resource represents a RESOURCE element in VOTable
+// Resource represents a RESOURCE element in VOTable.
+// Resources of type "meta" hold the MIVOT annotations
+// of the tables of their parent resource in VODML.
 type Resource struct {
-	Type   string   `xml:"type,attr"`
-	Infos  []Info   `xml:"INFO"`
-	Params []Param  `xml:"PARAM"`
-	Tables []Table  `xml:"TABLE"`
-	Coosys []Coosys `xml:"COOSYS"`
+	Type      string     `xml:"type,attr"`
+	Infos     []Info     `xml:"INFO"`
+	Coosys    []Coosys   `xml:"COOSYS"`
+	Timesys   []Timesys  `xml:"TIMESYS"`
+	Params    []Param    `xml:"PARAM"`
+	VODML     *VODML     `xml:"VODML"`
+	Resources []Resource `xml:"RESOURCE"`
+	Tables    []Table    `xml:"TABLE"`
 }
 
 // Info represents an INFO element in VOTable
@@ -38,6 +43,7 @@ type Param struct {
 
 // Table represents a TABLE element in VOTable
 type Table struct {
+	ID          string  `xml:"ID,attr,omitempty"`
 	Name        string  `xml:"name,attr"`
 	Description string  `xml:"DESCRIPTION,omitempty"`
 	Fields      []Field `xml:"FIELD"`
@@ -54,15 +60,32 @@ type Coosys struct {
 	Epoch   string `xml:"epoch,attr,omitempty"`
 }
 
+// Timesys represents a TIMESYS element in VOTable
+type Timesys struct {
+	ID          string `xml:"ID,attr"`
+	TimeOrigin  string `xml:"timeorigin,attr,omitempty"`
+	TimeScale   string `xml:"timescale,attr"`
+	RefPosition string `xml:"refposition,attr"`
+}
+
 // Field represents a FIELD element in VOTable
 type Field struct {
-	Name        string `xml:"name,attr"`
-	Description string `xml:"DESCRIPTION,omitempty"`
-	ID          string `xml:"ID,attr,omitempty"`
-	Datatype    string `xml:"datatype,attr"`
-	Unit        string `xml:"unit,attr,omitempty"`
-	Ucd         string `xml:"ucd,attr,omitempty"`
-	ArraySize   string `xml:"arraysize,attr,omitempty"`
+	Name        string  `xml:"name,attr"`
+	Description string  `xml:"DESCRIPTION,omitempty"`
+	Values      *Values `xml:"VALUES"`
+	ID          string  `xml:"ID,attr,omitempty"`
+	Datatype    string  `xml:"datatype,attr"`
+	Ref         string  `xml:"ref,attr,omitempty"`
+	Unit        string  `xml:"unit,attr,omitempty"`
+	Ucd         string  `xml:"ucd,attr,omitempty"`
+	Utype       string  `xml:"utype,attr,omitempty"`
+	Xtype       string  `xml:"xtype,attr,omitempty"`
+	ArraySize   string  `xml:"arraysize,attr,omitempty"`
+}
+
+// Values represents a VALUES element in VOTable
+type Values struct {
+	Null string `xml:"null,attr,omitempty"`
 }
 
 // Group represents a GROUP element in VOTable